@@ -0,0 +1,692 @@
+// Package client joins collaborative editing sessions from Go. It speaks
+// the server's WebSocket protocol, keeps a local copy of the document and
+// runs the same OT state machine as the browser client, so services and
+// tests can edit documents alongside human users.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alimasry/go-collab-editor/ot"
+)
+
+// Errors returned by Client methods.
+var (
+	ErrNotConnected = errors.New("client: not connected")
+	ErrClosed       = errors.New("client: closed")
+)
+
+const (
+	writeWait             = 10 * time.Second
+	defaultReconnectDelay = 2 * time.Second
+)
+
+// state is the OT client state, as in the browser client.
+type state int
+
+const (
+	synchronized          state = iota // no operation in flight
+	awaitingAck                        // pending sent, waiting for its ack
+	awaitingAckWithBuffer              // pending in flight, buffer holds later edits
+)
+
+// clientMessage and serverMessage mirror the server's wire format.
+type clientMessage struct {
+	Type     string `json:"type"`
+	DocID    string `json:"docId,omitempty"`
+	DocType  string `json:"docType,omitempty"`
+	Revision int    `json:"revision"`
+	Op       ot.Op  `json:"op,omitempty"`
+	Seq      int    `json:"seq,omitempty"`
+	Since    *int   `json:"since,omitempty"`
+}
+
+type serverMessage struct {
+	Type     string          `json:"type"`
+	DocType  string          `json:"docType"`
+	Content  string          `json:"content"`
+	Revision int             `json:"revision"`
+	Op       json.RawMessage `json:"op"`
+	ClientID string          `json:"clientId"`
+	Message  string          `json:"message"`
+	Code     string          `json:"code"`
+	Edits    []historyEdit   `json:"edits"`
+}
+
+type historyEdit struct {
+	Op       json.RawMessage `json:"op"`
+	ClientID string          `json:"clientId"`
+	Seq      int             `json:"seq"`
+}
+
+// Client is a participant in one document's editing session. Set the
+// exported fields before calling Connect.
+//
+// If the connection drops, the client reconnects and takes the server's
+// copy of the document, calling OnChange with a nil operation. Local edits
+// the server hadn't acknowledged are carried over: the client catches up
+// on the edits it missed, recognizing its own edit in flight among them if
+// the server applied it, and sends the rest again. Edits that can't be
+// carried over are passed to OnDiscard.
+type Client struct {
+	// DocType is the type of the document if joining creates it. Empty
+	// means the server's default.
+	DocType string
+	// Header is sent with every WebSocket handshake.
+	Header http.Header
+	// ReconnectDelay is the wait between reconnection attempts. Zero
+	// means two seconds, like the browser client.
+	ReconnectDelay time.Duration
+	// OnChange is called after an operation from the server is applied to
+	// the local copy, with the operation as applied locally. op is nil
+	// when the local copy is replaced on reconnecting.
+	OnChange func(op ot.Op)
+	// OnError is called with error messages from the server.
+	OnError func(message string)
+	// OnDiscard is called with local edits the server will never apply,
+	// composed into one operation, when the client gives them up: the
+	// server no longer has the history to transform them, or the local
+	// copy could no longer follow the server's. The local copy is then
+	// replaced with the server's, without them.
+	OnDiscard func(op ot.Op)
+
+	url   string
+	docID string
+
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	connected bool
+	closed    bool
+	running   bool          // the read loop was started
+	changed   chan struct{} // closed and replaced whenever state changes
+	done      chan struct{} // closed when the read loop exits
+
+	id       string
+	docType  ot.Type
+	snapshot any
+	content  string
+	revision int
+	state    state
+	pending  ot.Op
+	buffer   ot.Op
+	// seq numbers the edits sent; pendingSeq is pending's number.
+	seq        int
+	pendingSeq int
+}
+
+// New returns a client for document docID on the server whose WebSocket
+// endpoint is url (e.g. "ws://localhost:8080/ws").
+func New(url, docID string) *Client {
+	return &Client{
+		url:     url,
+		docID:   docID,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Connect dials the server and joins the document, returning once the
+// local copy is loaded. The client then stays connected until Close.
+func (c *Client) Connect(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.join(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+	go c.run(conn)
+	return nil
+}
+
+// Close disconnects from the server. The local copy stays readable.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.connected = false
+	conn, running := c.conn, c.running
+	c.notify()
+	c.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	if running {
+		<-c.done
+	}
+	return nil
+}
+
+// ID returns the ID the server assigned to this client on its current
+// connection.
+func (c *Client) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// Type returns the document's type.
+func (c *Client) Type() ot.Type {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.docType
+}
+
+// Content returns the local copy of the document, serialized by its type.
+// It includes local edits the server hasn't acknowledged yet.
+func (c *Client) Content() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.content
+}
+
+// Snapshot returns the local copy of the document in its type's
+// representation.
+func (c *Client) Snapshot() any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshot
+}
+
+// Revision returns the last server revision the local copy includes.
+func (c *Client) Revision() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.revision
+}
+
+// Submit applies op to the local copy and sends it to the server. op must
+// apply to the current local copy; use Edit to build it from the copy
+// safely. It returns ErrNotConnected while the client is reconnecting.
+func (c *Client) Submit(op ot.Op) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.submit(op)
+}
+
+// Edit calls build with the local copy and submits the operation it
+// returns, without letting remote operations change the copy in between.
+// build must not call the client's methods.
+func (c *Client) Edit(build func(snapshot any) (ot.Op, error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if !c.connected {
+		return ErrNotConnected
+	}
+	op, err := build(c.snapshot)
+	if err != nil {
+		return err
+	}
+	return c.submit(op)
+}
+
+func (c *Client) submit(op ot.Op) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if !c.connected {
+		return ErrNotConnected
+	}
+
+	// Check the operation the way the server will.
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if op, err = c.docType.DecodeOp(data); err != nil {
+		return fmt.Errorf("invalid operation: %w", err)
+	}
+	if c.docType.IsNoop(op) {
+		return nil
+	}
+	if err := c.applyLocal(op); err != nil {
+		return err
+	}
+
+	switch c.state {
+	case synchronized:
+		c.pending = op
+		c.state = awaitingAck
+		// If sending fails the connection is gone; pending is sent again
+		// after reconnecting.
+		c.sendPending()
+	case awaitingAck:
+		c.buffer = op
+		c.state = awaitingAckWithBuffer
+	case awaitingAckWithBuffer:
+		if c.buffer, err = c.docType.Compose(c.buffer, op); err != nil {
+			return err
+		}
+	}
+	c.notify()
+	return nil
+}
+
+// Undo asks the server to undo this client's last acknowledged change. The
+// result arrives like a remote operation.
+func (c *Client) Undo() error { return c.sendCommand("undo") }
+
+// Redo asks the server to redo this client's last undone change.
+func (c *Client) Redo() error { return c.sendCommand("redo") }
+
+func (c *Client) sendCommand(typ string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if !c.connected {
+		return ErrNotConnected
+	}
+	return c.send(clientMessage{Type: typ, DocID: c.docID})
+}
+
+// WaitSynced blocks until the client is connected and every local edit
+// has been acknowledged by the server.
+func (c *Client) WaitSynced(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return ErrClosed
+		}
+		if c.connected && c.state == synchronized {
+			c.mu.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes WaitSynced callers. c.mu must be held.
+func (c *Client) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// sendPending sends the pending edit, numbered so that it can be found in
+// the server's history after a reconnect. c.mu must be held.
+func (c *Client) sendPending() error {
+	c.seq++
+	c.pendingSeq = c.seq
+	return c.send(clientMessage{Type: "op", DocID: c.docID, Revision: c.revision, Op: c.pending, Seq: c.pendingSeq})
+}
+
+// send writes a message to the current connection. c.mu must be held.
+func (c *Client) send(msg clientMessage) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteJSON(msg)
+}
+
+// applyLocal applies op to the local copy. c.mu must be held.
+func (c *Client) applyLocal(op ot.Op) error {
+	snapshot, err := c.docType.Apply(c.snapshot, op)
+	if err != nil {
+		return err
+	}
+	content, err := c.docType.Serialize(snapshot)
+	if err != nil {
+		return err
+	}
+	c.snapshot, c.content = snapshot, content
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.url, c.Header)
+	if err != nil {
+		return nil, fmt.Errorf("client: dial %s: %w", c.url, err)
+	}
+	return conn, nil
+}
+
+// join joins the document on a new connection. If local edits are still
+// unacknowledged from an earlier connection, it asks for the edits made
+// since the local copy's revision, for load to catch up on.
+func (c *Client) join(conn *websocket.Conn) error {
+	c.mu.Lock()
+	c.conn = conn
+	join := clientMessage{Type: "join", DocID: c.docID, DocType: c.DocType}
+	if c.pending != nil {
+		since := c.revision
+		join.Since = &since
+	}
+	err := c.send(join)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	doc, err := c.await(conn, "doc")
+	if err != nil {
+		return err
+	}
+	return c.load(doc)
+}
+
+// await reads messages until one of type typ arrives, failing on an error
+// message. Anything else is out of date and is dropped.
+func (c *Client) await(conn *websocket.Conn, typ string) (serverMessage, error) {
+	conn.SetReadDeadline(time.Now().Add(writeWait))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		var msg serverMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return msg, err
+		}
+		switch msg.Type {
+		case typ:
+			return msg, nil
+		case "error":
+			return msg, fmt.Errorf("client: server error: %s", msg.Message)
+		}
+	}
+}
+
+// load replaces the local copy with the one in a doc message. Local edits
+// the server hasn't acknowledged are caught up with the edits listed in the
+// message, applied to the new copy and sent again.
+func (c *Client) load(doc serverMessage) error {
+	t, err := ot.LookupType(doc.DocType)
+	if err != nil {
+		return err
+	}
+	snapshot, err := t.Create(doc.Content)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	lost := c.catchUp(doc)
+	c.id = doc.ClientID
+	c.docType = t
+	c.snapshot = snapshot
+	c.content = doc.Content
+	c.revision = doc.Revision
+	c.state = synchronized
+	if c.pending != nil {
+		err := c.applyLocal(c.pending)
+		if err == nil && c.buffer != nil {
+			err = c.applyLocal(c.buffer)
+		}
+		if err != nil {
+			c.snapshot, c.content = snapshot, doc.Content
+			lost = c.discard()
+		}
+	}
+	switch {
+	case c.buffer != nil:
+		c.state = awaitingAckWithBuffer
+	case c.pending != nil:
+		c.state = awaitingAck
+	}
+	if c.pending != nil {
+		c.sendPending()
+	}
+	c.connected = !c.closed
+	c.notify()
+	c.mu.Unlock()
+
+	if lost != nil && c.OnDiscard != nil {
+		c.OnDiscard(lost)
+	}
+	return nil
+}
+
+// catchUp brings the unacknowledged edits up to date with the edits made
+// since the local copy's revision, which a rejoin's doc message lists. If
+// the server applied the edit in flight, its ack was lost with the old
+// connection: it is dropped and the buffer takes its place. If the edits
+// can't be brought up to date they are discarded and returned. c.mu must be
+// held, and c.id must still be the old connection's ID.
+func (c *Client) catchUp(doc serverMessage) ot.Op {
+	if c.pending == nil {
+		return nil
+	}
+	if doc.Code == "resync" || len(doc.Edits) != doc.Revision-c.revision {
+		return c.discard()
+	}
+	for _, e := range doc.Edits {
+		if c.pending != nil && e.ClientID == c.id && e.Seq == c.pendingSeq {
+			c.pending, c.buffer = c.buffer, nil
+			continue
+		}
+		op, err := c.docType.DecodeOp(e.Op)
+		if err == nil {
+			_, err = c.transformUnacked(op, e.ClientID)
+		}
+		if err != nil {
+			return c.discard()
+		}
+	}
+	return nil
+}
+
+// discard drops the unacknowledged edits and returns them composed, or nil
+// if there were none. c.mu must be held.
+func (c *Client) discard() ot.Op {
+	lost := c.pending
+	if c.buffer != nil {
+		if op, err := c.docType.Compose(c.pending, c.buffer); err == nil {
+			lost = op
+		}
+	}
+	c.pending, c.buffer = nil, nil
+	c.state = synchronized
+	return lost
+}
+
+// abandon discards the unacknowledged edits, reporting them to OnDiscard,
+// and closes conn, so the client reconnects and takes the server's copy.
+func (c *Client) abandon(conn *websocket.Conn) {
+	c.mu.Lock()
+	lost := c.discard()
+	c.mu.Unlock()
+	if lost != nil && c.OnDiscard != nil {
+		c.OnDiscard(lost)
+	}
+	conn.Close()
+}
+
+// run reads messages until the client is closed, reconnecting whenever
+// the connection drops.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+	for {
+		c.read(conn)
+
+		c.mu.Lock()
+		c.connected = false
+		c.notify()
+		c.mu.Unlock()
+		if conn = c.reconnect(); conn == nil {
+			return
+		}
+		if c.OnChange != nil {
+			c.OnChange(nil)
+		}
+	}
+}
+
+// read handles messages from conn until it fails.
+func (c *Client) read(conn *websocket.Conn) {
+	for {
+		var msg serverMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			conn.Close()
+			return
+		}
+		switch msg.Type {
+		case "ack":
+			op, err := c.handleAck(msg)
+			if err != nil {
+				c.abandon(conn)
+				return
+			}
+			if op != nil && c.OnChange != nil {
+				c.OnChange(op)
+			}
+		case "op":
+			op, err := c.handleRemoteOp(msg)
+			if err != nil {
+				// The local copy can't follow the server any more;
+				// reconnect to get a fresh one.
+				c.abandon(conn)
+				return
+			}
+			if c.OnChange != nil {
+				c.OnChange(op)
+			}
+		case "error":
+			if c.OnError != nil {
+				c.OnError(msg.Message)
+			}
+			if msg.Code == "resync" {
+				// The server no longer has the history to transform our
+				// edits against; reconnect to get a fresh copy.
+				c.abandon(conn)
+				return
+			}
+		}
+	}
+}
+
+// reconnect dials and rejoins until it succeeds, returning nil if the
+// client is closed first.
+func (c *Client) reconnect() *websocket.Conn {
+	delay := c.ReconnectDelay
+	if delay == 0 {
+		delay = defaultReconnectDelay
+	}
+	for {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return nil
+		}
+		time.Sleep(delay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+		conn, err := c.dial(ctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+		if err := c.join(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		c.mu.Lock()
+		closed = c.closed
+		c.mu.Unlock()
+		if closed {
+			conn.Close()
+			return nil
+		}
+		return conn
+	}
+}
+
+// handleAck completes the pending edit. If the server placed it differently
+// from the way it was transformed here, the ack carries a correction from
+// the local copy to the server's, which is applied like a remote operation.
+func (c *Client) handleAck(msg serverMessage) (ot.Op, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var fix ot.Op
+	if len(msg.Op) > 0 && string(msg.Op) != "null" {
+		var err error
+		if fix, err = c.docType.DecodeOp(msg.Op); err != nil {
+			return nil, err
+		}
+		if c.buffer != nil {
+			if c.buffer, fix, err = c.docType.Transform(c.buffer, fix); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.applyLocal(fix); err != nil {
+			return nil, err
+		}
+	}
+
+	c.revision = msg.Revision
+	switch c.state {
+	case awaitingAck:
+		c.pending = nil
+		c.state = synchronized
+	case awaitingAckWithBuffer:
+		c.pending, c.buffer = c.buffer, nil
+		c.state = awaitingAck
+		c.sendPending()
+	}
+	c.notify()
+	return fix, nil
+}
+
+// handleRemoteOp transforms a remote operation against local edits the
+// server hasn't seen yet and applies it. Ties go to the lower client ID,
+// as on the server.
+func (c *Client) handleRemoteOp(msg serverMessage) (ot.Op, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	op, err := c.docType.DecodeOp(msg.Op)
+	if err != nil {
+		return nil, err
+	}
+	if op, err = c.transformUnacked(op, msg.ClientID); err != nil {
+		return nil, err
+	}
+	if err := c.applyLocal(op); err != nil {
+		return nil, err
+	}
+	c.revision = msg.Revision
+	return op, nil
+}
+
+// transformUnacked transforms the unacknowledged edits past op, a remote
+// edit made by site, and returns op transformed past them. c.mu must be
+// held.
+func (c *Client) transformUnacked(op ot.Op, site string) (ot.Op, error) {
+	side := ot.SiteSide(c.id, site)
+	var err error
+	if c.pending != nil {
+		if c.pending, op, err = ot.TransformSide(c.docType, c.pending, op, side); err != nil {
+			return nil, err
+		}
+	}
+	if c.buffer != nil {
+		if c.buffer, op, err = ot.TransformSide(c.docType, c.buffer, op, side); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
@@ -0,0 +1,398 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/alimasry/go-collab-editor/ot"
+	"github.com/alimasry/go-collab-editor/server"
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+func ctx() context.Context { return context.Background() }
+
+func setupServer(t *testing.T) (string, store.DocumentStore) {
+	t.Helper()
+	return setupHub(t, nil)
+}
+
+// setupHub is like setupServer but calls configure on the hub first.
+func setupHub(t *testing.T, configure func(*server.Hub)) (string, store.DocumentStore) {
+	t.Helper()
+	st := store.NewMemoryStore()
+	hub := server.NewHub(st, &ot.JupiterEngine{})
+	if configure != nil {
+		configure(hub)
+	}
+	go hub.Run()
+	srv := httptest.NewServer(server.NewHandler(hub))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws", st
+}
+
+func connect(t *testing.T, url, docID string) *Client {
+	t.Helper()
+	c := New(url, docID)
+	c.ReconnectDelay = 10 * time.Millisecond
+	if err := c.Connect(ctx()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func waitSynced(t *testing.T, c *Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(ctx(), 3*time.Second)
+	defer cancel()
+	if err := c.WaitSynced(ctx); err != nil {
+		t.Fatalf("WaitSynced: %v", err)
+	}
+}
+
+// waitContent polls until c's local copy is want.
+func waitContent(t *testing.T, c *Client, want string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for c.Content() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("content = %q, want %q", c.Content(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClient_Collaborate(t *testing.T) {
+	url, _ := setupServer(t)
+	c1 := connect(t, url, "doc")
+	c2 := connect(t, url, "doc")
+
+	if err := c1.Submit(ot.NewInsert(0, "hello", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if c1.Content() != "hello" {
+		t.Errorf("local content = %q, want %q", c1.Content(), "hello")
+	}
+	waitSynced(t, c1)
+	waitContent(t, c2, "hello")
+
+	if err := c2.Submit(ot.NewInsert(5, " world", 5)); err != nil {
+		t.Fatal(err)
+	}
+	waitContent(t, c1, "hello world")
+	if c1.Revision() != 2 {
+		t.Errorf("revision = %d, want 2", c1.Revision())
+	}
+
+	// A client joining later loads the current copy.
+	c3 := connect(t, url, "doc")
+	if c3.Content() != "hello world" {
+		t.Errorf("joined content = %q, want %q", c3.Content(), "hello world")
+	}
+}
+
+func TestClient_ConcurrentEditsConverge(t *testing.T) {
+	t.Run("jupiter", func(t *testing.T) {
+		url, st := setupServer(t)
+		testConcurrentEdits(t, url, st)
+	})
+	t.Run("crdt", func(t *testing.T) {
+		// The server may place edits differently from the clients and
+		// correct them in acks.
+		url, st := setupHub(t, func(h *server.Hub) {
+			h.NewEngine = func() ot.Engine { return &ot.CRDTEngine{} }
+		})
+		testConcurrentEdits(t, url, st)
+	})
+}
+
+// testConcurrentEdits has three clients make random edits at once and
+// checks they all end with the copy rebuilt from the stored edits.
+func testConcurrentEdits(t *testing.T, url string, st store.DocumentStore) {
+	clients := []*Client{connect(t, url, "doc"), connect(t, url, "doc"), connect(t, url, "doc")}
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(c *Client, seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < 30; j++ {
+				err := c.Edit(func(snapshot any) (ot.Op, error) {
+					doc := snapshot.(string)
+					n := ot.UTF16.Len(doc)
+					if n > 0 && r.Intn(3) == 0 {
+						return ot.NewDelete(r.Intn(n), 1, n), nil
+					}
+					return ot.NewInsert(r.Intn(n+1), string(rune('a'+seed)), n), nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				time.Sleep(time.Duration(r.Intn(3)) * time.Millisecond)
+			}
+		}(c, int64(i))
+	}
+	wg.Wait()
+
+	for _, c := range clients {
+		waitSynced(t, c)
+	}
+	edits, err := st.GetOperations(ctx(), "doc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := ot.NewDocument("")
+	for _, e := range edits {
+		if err := doc.ApplyEdit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, c := range clients {
+		waitContent(t, c, doc.Content)
+		if c.Revision() != doc.Version {
+			t.Errorf("client %d revision = %d, want %d", i, c.Revision(), doc.Version)
+		}
+	}
+}
+
+func TestClient_RejectsInvalidOp(t *testing.T) {
+	url, _ := setupServer(t)
+	c := connect(t, url, "doc")
+
+	if err := c.Submit(ot.NewInsert(3, "x", 3)); err == nil {
+		t.Error("expected error for an operation that doesn't apply")
+	}
+	if err := c.Submit(ot.Operation{Ops: []ot.Component{{Retain: 1, Insert: "x"}}}); err == nil {
+		t.Error("expected error for a malformed operation")
+	}
+	if c.Content() != "" {
+		t.Errorf("content = %q, want empty", c.Content())
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	url, _ := setupServer(t)
+	c1 := connect(t, url, "doc")
+	c2 := connect(t, url, "doc")
+	oldID := c1.ID()
+
+	changes := make(chan ot.Op, 16)
+	c1.OnChange = func(op ot.Op) { changes <- op }
+
+	// Drop the connection and edit before the client notices, so the edit
+	// is never delivered and must be sent again.
+	c1.mu.Lock()
+	c1.conn.Close()
+	err := c1.submit(ot.NewInsert(0, "offline", 0))
+	c1.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case op := <-changes:
+		if op != nil {
+			t.Errorf("OnChange(%v), want nil on reconnect", op)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	waitSynced(t, c1)
+	if c1.ID() == oldID {
+		t.Error("expected a new client ID after reconnecting")
+	}
+	if c1.Content() != "offline" {
+		t.Errorf("content = %q, want %q", c1.Content(), "offline")
+	}
+	waitContent(t, c2, "offline")
+}
+
+// lossyProxy relays WebSocket connections to the server at url. When
+// dropAck is set it swallows the next ack and cuts the connection, as if
+// the connection dropped just after the server applied an edit.
+type lossyProxy struct {
+	url string
+
+	mu      sync.Mutex
+	dropAck bool
+}
+
+func (p *lossyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	up, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	down, _, err := websocket.DefaultDialer.Dial(p.url, nil)
+	if err != nil {
+		return
+	}
+	defer down.Close()
+
+	go func() {
+		defer down.Close()
+		for {
+			typ, data, err := up.ReadMessage()
+			if err != nil || down.WriteMessage(typ, data) != nil {
+				return
+			}
+		}
+	}()
+	for {
+		typ, data, err := down.ReadMessage()
+		if err != nil || p.drop(data) || up.WriteMessage(typ, data) != nil {
+			return
+		}
+	}
+}
+
+func (p *lossyProxy) drop(data []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dropAck && strings.Contains(string(data), `"type":"ack"`) {
+		p.dropAck = false
+		return true
+	}
+	return false
+}
+
+func TestClient_ReconnectAfterLostAck(t *testing.T) {
+	url, _ := setupServer(t)
+	proxy := &lossyProxy{url: url}
+	srv := httptest.NewServer(proxy)
+	t.Cleanup(srv.Close)
+
+	c1 := New("ws"+strings.TrimPrefix(srv.URL, "http"), "doc")
+	c1.ReconnectDelay = 100 * time.Millisecond
+	c1.OnDiscard = func(op ot.Op) { t.Errorf("OnDiscard(%v)", op) }
+	if err := c1.Connect(ctx()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c1.Close() })
+	c2 := connect(t, url, "doc")
+
+	if err := c2.Submit(ot.NewInsert(0, "world", 0)); err != nil {
+		t.Fatal(err)
+	}
+	waitContent(t, c1, "world")
+
+	// The server applies the first edit, but its ack is lost, so the
+	// second is still buffered when the connection drops.
+	proxy.mu.Lock()
+	proxy.dropAck = true
+	proxy.mu.Unlock()
+	c1.mu.Lock()
+	err := c1.submit(ot.NewInsert(0, "hello ", 5))
+	if err == nil {
+		err = c1.submit(ot.NewInsert(6, "big ", 11))
+	}
+	c1.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else edits while the client is away.
+	waitContent(t, c2, "hello world")
+	if err := c2.Submit(ot.NewInsert(11, "!", 11)); err != nil {
+		t.Fatal(err)
+	}
+
+	waitContent(t, c2, "hello big world!")
+	waitSynced(t, c1)
+	waitContent(t, c1, "hello big world!")
+}
+
+func TestClient_DiscardsEditsOnResync(t *testing.T) {
+	url, _ := setupHub(t, func(h *server.Hub) { h.HistoryLimit = 1 })
+	c1 := connect(t, url, "doc")
+	c2 := connect(t, url, "doc")
+
+	discarded := make(chan ot.Op, 1)
+	c1.OnDiscard = func(op ot.Op) { discarded <- op }
+
+	// Edit offline while the server moves on further than its history
+	// reaches back.
+	c1.mu.Lock()
+	c1.conn.Close()
+	err := c1.submit(ot.NewInsert(0, "lost", 0))
+	for i := 0; err == nil && i < 3; i++ {
+		err = c2.Submit(ot.NewInsert(i, "x", i))
+	}
+	c1.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case op := <-discarded:
+		if got, _ := ot.Apply("", op.(ot.Operation)); got != "lost" {
+			t.Errorf("discarded edit inserts %q, want %q", got, "lost")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnDiscard was not called")
+	}
+	waitSynced(t, c1)
+	waitContent(t, c1, "xxx")
+}
+
+func TestClient_Undo(t *testing.T) {
+	url, _ := setupServer(t)
+	c := connect(t, url, "doc")
+
+	if err := c.Submit(ot.NewInsert(0, "abc", 0)); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(t, c)
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	waitContent(t, c, "")
+	if err := c.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	waitContent(t, c, "abc")
+}
+
+func TestClient_JSONDocument(t *testing.T) {
+	url, _ := setupServer(t)
+	c1 := New(url, "board")
+	c1.DocType = ot.JSON.Name()
+	if err := c1.Connect(ctx()); err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2 := connect(t, url, "board")
+
+	if c2.Type().Name() != "json" {
+		t.Fatalf("type = %q, want json", c2.Type().Name())
+	}
+	op := ot.JSONOperation{Ops: []ot.JSONComponent{ot.ObjectInsert([]any{"cards"}, []any{"todo"})}}
+	if err := c1.Submit(op); err != nil {
+		t.Fatal(err)
+	}
+	waitContent(t, c2, `{"cards":["todo"]}`)
+}
+
+func TestClient_Closed(t *testing.T) {
+	url, _ := setupServer(t)
+	c := connect(t, url, "doc")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Submit(ot.NewInsert(0, "x", 0)); err != ErrClosed {
+		t.Errorf("Submit after Close = %v, want %v", err, ErrClosed)
+	}
+	if err := c.WaitSynced(ctx()); err != ErrClosed {
+		t.Errorf("WaitSynced after Close = %v, want %v", err, ErrClosed)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -19,6 +20,9 @@ func main() {
 	addr := flag.String("addr", ":8080", "HTTP listen address")
 	storeType := flag.String("store", "memory", "Storage backend: memory or firestore")
 	project := flag.String("project", "", "GCP project ID (required for firestore store)")
+	typeName := flag.String("type", ot.Text.Name(), "Default type for new documents: "+strings.Join(ot.Types(), ", "))
+	engineName := flag.String("engine", "jupiter", "Collaboration engine: jupiter or crdt")
+	historyLimit := flag.Int("history", server.DefaultHistoryLimit, "Edits of history each document keeps in memory (0 = unlimited)")
 	flag.Parse()
 
 	// Cloud Run sets PORT; override -addr if present.
@@ -52,8 +56,28 @@ func main() {
 		log.Fatalf("Unknown store type: %s", *storeType)
 	}
 
+	defaultType, err := ot.LookupType(*typeName)
+	if err != nil {
+		log.Fatal(err)
+	}
 	engine := &ot.JupiterEngine{}
 	hub := server.NewHub(docStore, engine)
+	hub.DefaultType = defaultType
+	hub.HistoryLimit = *historyLimit
+	switch *engineName {
+	case "jupiter":
+	case "crdt":
+		// The CRDT engine keeps a sequence per document.
+		hub.NewEngine = func() ot.Engine { return &ot.CRDTEngine{} }
+	default:
+		log.Fatalf("Unknown engine: %s", *engineName)
+	}
+	// With AUTH_SECRET set, WebSocket connections need a token signed
+	// with it.
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		hub.Auth = server.TokenAuth{Secret: []byte(secret)}
+		log.Printf("Requiring signed tokens on /ws")
+	}
 	go hub.Run()
 
 	handler := server.NewHandler(hub)
@@ -0,0 +1,127 @@
+package ot
+
+import "fmt"
+
+// Span is a run of text inserted by one author.
+type Span struct {
+	Author string `json:"author"` // empty if unknown
+	Len    int    `json:"len"`
+}
+
+// Blame attributes each unit of a text document to the author who
+// inserted it, as spans in document order. Adjacent spans have different
+// authors, so equal blames compare equal with reflect.DeepEqual.
+type Blame []Span
+
+// Blamer is implemented by types whose documents are text, so that their
+// units can be attributed to authors.
+type Blamer interface {
+	// Blame returns the blame of snapshot, attributing all of it to the
+	// unknown author.
+	Blame(snapshot any) (Blame, error)
+	// ApplyBlame returns b updated for op, made by author: inserted text
+	// is attributed to author, and deleted text is dropped.
+	ApplyBlame(b Blame, op Op, author string) (Blame, error)
+}
+
+// NewBlame returns the blame of a document of n units whose author is
+// unknown.
+func NewBlame(n int) Blame {
+	if n == 0 {
+		return nil
+	}
+	return Blame{{Len: n}}
+}
+
+// Len returns the number of units b covers.
+func (b Blame) Len() int {
+	n := 0
+	for _, s := range b {
+		n += s.Len
+	}
+	return n
+}
+
+// Author returns who inserted the unit at index i, and false if i is out
+// of range.
+func (b Blame) Author(i int) (string, bool) {
+	for _, s := range b {
+		if i < s.Len {
+			return s.Author, i >= 0
+		}
+		i -= s.Len
+	}
+	return "", false
+}
+
+// ApplyBlame updates b for op, made by author. Offsets are counted in
+// UTF-16 code units.
+func ApplyBlame(b Blame, op Operation, author string) (Blame, error) {
+	return UTF16.ApplyBlame(b, op, author)
+}
+
+// ApplyBlame is like the package-level ApplyBlame but counts offsets in u.
+func (u Unit) ApplyBlame(b Blame, op Operation, author string) (Blame, error) {
+	if n := b.Len(); n != op.BaseLen() {
+		return nil, fmt.Errorf("blame length %d != operation base length %d", n, op.BaseLen())
+	}
+	var out Blame
+	add := func(s Span) {
+		switch {
+		case s.Len == 0:
+		case len(out) > 0 && out[len(out)-1].Author == s.Author:
+			out[len(out)-1].Len += s.Len
+		default:
+			out = append(out, s)
+		}
+	}
+	i := 0    // index of the next span of b
+	used := 0 // units of b[i] already consumed
+	// take consumes n units of b, calling fn on each piece.
+	take := func(n int, fn func(Span)) {
+		for n > 0 {
+			k := min(n, b[i].Len-used)
+			fn(Span{Author: b[i].Author, Len: k})
+			n -= k
+			if used += k; used == b[i].Len {
+				i, used = i+1, 0
+			}
+		}
+	}
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			take(c.Retain, add)
+		case c.IsInsert():
+			add(Span{Author: author, Len: u.Len(c.Insert)})
+		case c.IsDelete():
+			take(c.Delete, func(Span) {})
+		}
+	}
+	return out, nil
+}
+
+// BlameHistory returns the blame of the document edits produce, attributing
+// each unit to the Author of the edit that inserted it. The text of the
+// document the first edit applies to has no known author. t must be a
+// Blamer and its operations Operation values.
+func BlameHistory(t Type, edits []Edit) (Blame, error) {
+	bt, ok := t.(Blamer)
+	if !ok {
+		return nil, fmt.Errorf("%s documents have no blame", t.Name())
+	}
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	first, err := asOperation(edits[0].Op)
+	if err != nil {
+		return nil, err
+	}
+	b := NewBlame(first.BaseLen())
+	for i, e := range edits {
+		if b, err = bt.ApplyBlame(b, e.Op, e.Author); err != nil {
+			return nil, fmt.Errorf("edit %d: %w", i, err)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,126 @@
+package ot
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestApplyBlame(t *testing.T) {
+	b := NewBlame(5) // "hello"
+	steps := []struct {
+		op     Operation
+		author string
+		want   Blame
+	}{
+		{NewInsert(5, " world", 5), "a", Blame{{"", 5}, {"a", 6}}},
+		{NewInsert(2, "😀", 11), "b", Blame{{"", 2}, {"b", 2}, {"", 3}, {"a", 6}}},
+		{NewDelete(2, 2, 13), "c", Blame{{"", 5}, {"a", 6}}},
+		{NewDelete(3, 4, 11), "c", Blame{{"", 3}, {"a", 4}}},
+		// Formatting changes keep the author.
+		{Operation{Ops: []Component{{Retain: 7, Attributes: Attributes{"bold": true}}}}, "d", Blame{{"", 3}, {"a", 4}}},
+	}
+	for i, s := range steps {
+		var err error
+		if b, err = ApplyBlame(b, s.op, s.author); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(b, s.want) {
+			t.Fatalf("step %d: got %v, want %v", i, b, s.want)
+		}
+	}
+	if _, err := ApplyBlame(b, NewInsert(0, "x", 3), "a"); err == nil {
+		t.Error("expected error for length mismatch")
+	}
+}
+
+func TestBlame_Author(t *testing.T) {
+	b := Blame{{"", 2}, {"a", 3}}
+	for i, want := range []string{"", "", "a", "a", "a"} {
+		if got, ok := b.Author(i); !ok || got != want {
+			t.Errorf("Author(%d) = %q, %v; want %q", i, got, ok, want)
+		}
+	}
+	if _, ok := b.Author(5); ok {
+		t.Error("Author(5) reported in range")
+	}
+}
+
+// TestBlameHistory checks that blame computed from random edits by random
+// authors attributes every unit to the edit that inserted it.
+func TestBlameHistory(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	authors := []string{"a", "b", "c"}
+	doc := randomText(r, 5)
+	// who[i] is the author of rune i of doc.
+	who := make([]string, len([]rune(doc)))
+	var edits []Edit
+	for i := 0; i < 300; i++ {
+		op := randomLocalOp(r, doc, false)
+		author := authors[r.Intn(len(authors))]
+		edits = append(edits, Edit{Op: op, Author: author})
+
+		// Replay op on who, rune by rune.
+		runes := []rune(doc)
+		var next []string
+		pos := 0 // rune index into doc
+		for _, c := range op.Ops {
+			switch {
+			case c.IsRetain():
+				n := runesIn(runes[pos:], c.Retain)
+				next = append(next, who[pos:pos+n]...)
+				pos += n
+			case c.IsInsert():
+				for range []rune(c.Insert) {
+					next = append(next, author)
+				}
+			case c.IsDelete():
+				pos += runesIn(runes[pos:], c.Delete)
+			}
+		}
+		who = next
+		doc, _ = Apply(doc, op)
+	}
+
+	b, err := BlameHistory(Text, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := 0 // UTF-16 offset
+	for j, r := range []rune(doc) {
+		if got, _ := b.Author(i); got != who[j] {
+			t.Fatalf("rune %d: author %q, want %q", j, got, who[j])
+		}
+		i += runeWidth(r)
+	}
+	if b.Len() != i {
+		t.Errorf("blame length %d, want %d", b.Len(), i)
+	}
+}
+
+// runesIn returns how many of runes make up n UTF-16 units.
+func runesIn(runes []rune, n int) int {
+	k := 0
+	for n > 0 {
+		n -= runeWidth(runes[k])
+		k++
+	}
+	return k
+}
+
+func TestBlameHistory_RichText(t *testing.T) {
+	edits := []Edit{
+		{Op: Operation{Ops: []Component{{Insert: "hi", Attributes: Attributes{"bold": true}}}}, Author: "a"},
+		{Op: NewInsert(2, "!", 2), Author: "b"},
+	}
+	b, err := BlameHistory(Rich, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Blame{{"a", 2}, {"b", 1}}); !reflect.DeepEqual(b, want) {
+		t.Errorf("got %v, want %v", b, want)
+	}
+	if _, err := BlameHistory(JSON, nil); err == nil {
+		t.Error("expected error for JSON documents")
+	}
+}
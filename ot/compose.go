@@ -0,0 +1,65 @@
+package ot
+
+import "fmt"
+
+// Compose merges two sequential operations into one. b must apply to the
+// document produced by a, and the result satisfies:
+//
+//	Apply(Apply(doc, a), b) == Apply(doc, Compose(a, b))
+//
+// Offsets are counted in UTF-16 code units.
+func Compose(a, b Operation) (Operation, error) {
+	return UTF16.Compose(a, b)
+}
+
+// Compose is like the package-level Compose but counts offsets in u.
+func (u Unit) Compose(a, b Operation) (Operation, error) {
+	if u.TargetLen(a) != b.BaseLen() {
+		return Operation{}, fmt.Errorf(
+			"compose: a target length %d != b base length %d", u.TargetLen(a), b.BaseLen())
+	}
+
+	var ops []Component
+	ia := newIter(a.Ops, u)
+	ib := newIter(b.Ops, u)
+
+	for ia.hasNext() || ib.hasNext() {
+		// a's deletes don't produce output for b to see.
+		if ia.peekType() == compDelete {
+			ops = append(ops, ia.take(ia.peekLen()))
+			continue
+		}
+		// b's inserts don't consume any of a's output.
+		if ib.peekType() == compInsert {
+			c := ib.take(0)
+			c.Attributes = composeAttributes(nil, c.Attributes, false)
+			ops = append(ops, c)
+			continue
+		}
+
+		if !ia.hasNext() || !ib.hasNext() {
+			return Operation{}, fmt.Errorf("compose ran out of operations")
+		}
+
+		n := min(ia.peekLen(), ib.peekLen())
+		ca := ia.take(n)
+		cb := ib.take(n)
+
+		switch {
+		case ca.IsRetain() && cb.IsRetain():
+			// Keep removals so they still apply to the original text.
+			ops = append(ops, Component{Retain: n, Attributes: composeAttributes(ca.Attributes, cb.Attributes, true)})
+		case ca.IsRetain() && cb.IsDelete():
+			ops = append(ops, Component{Delete: n})
+		case ca.IsInsert() && cb.IsRetain():
+			ops = append(ops, Component{Insert: ca.Insert, Attributes: composeAttributes(ca.Attributes, cb.Attributes, false)})
+		case ca.IsInsert() && cb.IsDelete():
+			// b deletes text that a inserted — they cancel out.
+		}
+	}
+
+	if ia.err != nil {
+		return Operation{}, fmt.Errorf("compose: %w", ia.err)
+	}
+	return Operation{Ops: compact(ops)}, nil
+}
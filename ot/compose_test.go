@@ -0,0 +1,184 @@
+package ot
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// verifyCompose checks the compose invariant: Apply(Apply(doc,a),b) == Apply(doc,Compose(a,b))
+func verifyCompose(t *testing.T, doc string, a, b Operation) Operation {
+	t.Helper()
+
+	ab, err := Compose(a, b)
+	if err != nil {
+		t.Fatalf("Compose error: %v", err)
+	}
+
+	afterA, err := Apply(doc, a)
+	if err != nil {
+		t.Fatalf("Apply(doc, a) error: %v", err)
+	}
+	sequential, err := Apply(afterA, b)
+	if err != nil {
+		t.Fatalf("Apply(afterA, b) error: %v", err)
+	}
+	composed, err := Apply(doc, ab)
+	if err != nil {
+		t.Fatalf("Apply(doc, ab) error: %v\nab=%+v", err, ab.Ops)
+	}
+
+	if sequential != composed {
+		t.Errorf("compose mismatch:\n  doc=%q\n  a=%+v\n  b=%+v\n  sequential=%q\n  composed=%q\n  ab=%+v",
+			doc, a.Ops, b.Ops, sequential, composed, ab.Ops)
+	}
+	return ab
+}
+
+func TestCompose(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b Operation
+		want []Component
+	}{
+		{
+			"two inserts",
+			"abc",
+			NewInsert(1, "X", 3), // "aXbc"
+			NewInsert(2, "Y", 4), // "aXYbc"
+			[]Component{{Retain: 1}, {Insert: "XY"}, {Retain: 2}},
+		},
+		{
+			"insert then delete it",
+			"abc",
+			NewInsert(1, "XY", 3), // "aXYbc"
+			NewDelete(1, 2, 5),    // "abc"
+			[]Component{{Retain: 3}},
+		},
+		{
+			"insert then delete part of it",
+			"abc",
+			NewInsert(1, "XYZ", 3), // "aXYZbc"
+			NewDelete(2, 1, 6),     // "aXZbc"
+			[]Component{{Retain: 1}, {Insert: "XZ"}, {Retain: 2}},
+		},
+		{
+			"delete then insert",
+			"abcde",
+			NewDelete(1, 2, 5),   // "ade"
+			NewInsert(1, "X", 3), // "aXde"
+			[]Component{{Retain: 1}, {Delete: 2}, {Insert: "X"}, {Retain: 2}},
+		},
+		{
+			"two deletes",
+			"abcdef",
+			NewDelete(0, 2, 6), // "cdef"
+			NewDelete(2, 2, 4), // "cd"
+			[]Component{{Delete: 2}, {Retain: 2}, {Delete: 2}},
+		},
+		{
+			"delete spanning inserted and original text",
+			"abc",
+			NewInsert(1, "X", 3), // "aXbc"
+			NewDelete(1, 2, 4),   // "ac"
+			[]Component{{Retain: 1}, {Delete: 1}, {Retain: 1}},
+		},
+		{
+			"noop then insert",
+			"ab",
+			Operation{[]Component{{Retain: 2}}},
+			NewInsert(2, "!", 2),
+			[]Component{{Retain: 2}, {Insert: "!"}},
+		},
+		{
+			"empty doc",
+			"",
+			Operation{[]Component{{Insert: "hi"}}},
+			NewInsert(2, "!", 2),
+			[]Component{{Insert: "hi!"}},
+		},
+		{
+			"unicode",
+			"😀",
+			NewInsert(2, "é😀", 2), // "😀é😀"
+			NewDelete(2, 1, 5),    // "😀😀"
+			[]Component{{Retain: 2}, {Insert: "😀"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ab := verifyCompose(t, tt.doc, tt.a, tt.b)
+			if !opsEqual(ab.Ops, tt.want) {
+				t.Errorf("Compose() = %+v, want %+v", ab.Ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompose_ErrorOnLengthMismatch(t *testing.T) {
+	a := NewInsert(0, "x", 3) // target len 4
+	b := NewInsert(0, "y", 3) // base len 3
+	if _, err := Compose(a, b); err == nil {
+		t.Error("expected error for mismatched lengths")
+	}
+}
+
+func TestCompose_ErrorOnSplitSurrogatePair(t *testing.T) {
+	a := Operation{[]Component{{Insert: "😀"}}}
+	b := Operation{[]Component{{Retain: 1}, {Delete: 1}}}
+	if _, err := Compose(a, b); err == nil {
+		t.Error("expected error for delete inside surrogate pair")
+	}
+}
+
+func TestCompose_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := randomText(r, r.Intn(10))
+		a := randomOp(r, doc)
+		afterA, err := Apply(doc, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := randomOp(r, afterA)
+		verifyCompose(t, doc, a, b)
+	}
+}
+
+func opsEqual(a, b []Component) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// randomText returns n random characters, including some outside the BMP.
+func randomText(r *rand.Rand, n int) string {
+	alphabet := []rune("abcxyzé😀")
+	out := make([]rune, n)
+	for i := range out {
+		out[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(out)
+}
+
+// randomOp returns a random valid operation on doc, in UTF-16 units.
+func randomOp(r *rand.Rand, doc string) Operation {
+	var ops []Component
+	runes := []rune(doc)
+	for i := 0; i < len(runes); {
+		n := 1 + r.Intn(len(runes)-i)
+		width := UTF16.Len(string(runes[i : i+n]))
+		switch r.Intn(3) {
+		case 0:
+			ops = append(ops, Component{Retain: width})
+		case 1:
+			ops = append(ops, Component{Delete: width})
+		case 2:
+			ops = append(ops, Component{Insert: randomText(r, 1+r.Intn(3))}, Component{Retain: width})
+		}
+		i += n
+	}
+	if r.Intn(2) == 0 {
+		ops = append(ops, Component{Insert: randomText(r, 1+r.Intn(3))})
+	}
+	return Operation{Ops: compact(ops)}
+}
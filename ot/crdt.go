@@ -0,0 +1,258 @@
+package ot
+
+import "fmt"
+
+// CRDTEngine integrates edits into a replicated sequence (RGA) instead of
+// transforming them against history. Every unit of text ever inserted stays
+// in the sequence, deleted units as tombstones, so an incoming edit is
+// placed next to the units its author saw, whatever happened to them since.
+// Concurrent inserts after the same unit are ordered newest first (by the
+// revision their authors had seen), then by site like SiteSide, which makes
+// the order independent of arrival.
+//
+// Where JupiterEngine ties concurrent inserts that end up at the same
+// position, the sequence keeps them apart by the deleted text between them,
+// so the two engines can order them differently. Clients transform their
+// unacknowledged edits the Jupiter way, so the server corrects the author
+// in that case (see server.Session).
+//
+// The sequence belongs to one document, so each document needs its own
+// CRDTEngine; the zero value is ready to use. The operation returned by
+// TransformIncoming must be applied to the document, or dropped, before
+// the next call. Types other than TextType have no sequence to integrate
+// into and are transformed like JupiterEngine does.
+type CRDTEngine struct {
+	unit    Unit
+	items   []crdtItem
+	started bool
+
+	// start is the revision the sequence starts at, and synced the last
+	// revision in items. tentative is the revision of the last result,
+	// integrated but not yet in history, or 0.
+	start     int
+	synced    int
+	tentative int
+}
+
+// crdtItem is a run of units inserted together. Runs are split as edits
+// address units inside them.
+type crdtItem struct {
+	site    string
+	lamport int    // ordering clock: the author's revision + 1
+	ins     int    // revision that inserted the run; 0 for the initial text
+	del     int    // revision that deleted the run, or 0
+	n       int    // length in units
+	text    string // inserted text; empty for the initial text
+}
+
+// visible reports whether the run is part of the document at revision rev.
+func (it crdtItem) visible(rev int) bool {
+	return it.ins <= rev && (it.del == 0 || it.del > rev)
+}
+
+// before reports whether a run inserted by site with clock lamport goes
+// before it when both follow the same unit.
+func (it crdtItem) before(lamport int, site string) bool {
+	if it.lamport != lamport {
+		return it.lamport > lamport
+	}
+	return it.site < site
+}
+
+func (c *CRDTEngine) TransformIncoming(t Type, e Edit, revision, base int, history []Edit) (Op, error) {
+	tt, ok := t.(TextType)
+	if !ok {
+		return (&JupiterEngine{}).TransformIncoming(t, e, revision, base, history)
+	}
+	if err := checkRevision(revision, base, history); err != nil {
+		return nil, err
+	}
+	op, err := asOperation(e.Op)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sync(tt.Unit, op, base, history); err != nil {
+		return nil, err
+	}
+
+	rev := base + len(history) + 1
+	if err := c.integrate(Normalize(op), revision, e.Site, rev); err != nil {
+		c.rollback(rev)
+		return nil, fmt.Errorf("integrate edit at revision %d: %w", revision, err)
+	}
+	c.tentative = rev
+	return c.operation(rev), nil
+}
+
+// sync brings the sequence up to date with history, which holds the edits
+// since revision base. The last result is kept if it made it into history
+// and dropped otherwise; edits applied without the engine, such as those
+// loaded from a store, are integrated as they are.
+func (c *CRDTEngine) sync(u Unit, incoming Operation, base int, history []Edit) error {
+	version := base + len(history)
+	if c.tentative != 0 {
+		if version >= c.tentative {
+			c.synced = c.tentative
+		} else {
+			c.rollback(c.tentative)
+		}
+		c.tentative = 0
+		if c.synced == c.start {
+			// The first edit was dropped, so it may not have matched the
+			// document's length.
+			*c = CRDTEngine{}
+		}
+	}
+	if !c.started {
+		// The sequence starts from the text the first edit applies to.
+		n := incoming.BaseLen()
+		if len(history) > 0 {
+			o, err := asOperation(history[0].Op)
+			if err != nil {
+				return err
+			}
+			n = o.BaseLen()
+		}
+		c.unit = u
+		if n > 0 {
+			c.items = append(c.items, crdtItem{n: n})
+		}
+		c.start, c.synced = base, base
+		c.started = true
+	}
+	if version < c.synced {
+		return fmt.Errorf("document is at revision %d, engine has seen %d", version, c.synced)
+	}
+	if c.synced < base {
+		return fmt.Errorf("history starts at revision %d, engine has seen %d", base, c.synced)
+	}
+	for ; c.synced < version; c.synced++ {
+		e := history[c.synced-base]
+		op, err := asOperation(e.Op)
+		if err != nil {
+			return err
+		}
+		if err := c.integrate(Normalize(op), c.synced, e.Site, c.synced+1); err != nil {
+			return fmt.Errorf("integrate revision %d: %w", c.synced+1, err)
+		}
+	}
+	return nil
+}
+
+// integrate adds op, made by site on the document at revision base, to the
+// sequence as revision rev.
+func (c *CRDTEngine) integrate(op Operation, base int, site string, rev int) error {
+	i := 0 // index of the next run to look at
+	// skip moves past n units visible at base, calling fn on each run.
+	skip := func(n int, fn func(*crdtItem)) error {
+		for n > 0 {
+			if i >= len(c.items) {
+				return fmt.Errorf("operation is longer than the document")
+			}
+			if !c.items[i].visible(base) {
+				i++
+				continue
+			}
+			if c.items[i].n > n {
+				if err := c.split(i, n); err != nil {
+					return err
+				}
+			}
+			n -= c.items[i].n
+			fn(&c.items[i])
+			i++
+		}
+		return nil
+	}
+
+	for _, comp := range op.Ops {
+		switch {
+		case comp.IsRetain():
+			if err := skip(comp.Retain, func(*crdtItem) {}); err != nil {
+				return err
+			}
+		case comp.IsDelete():
+			err := skip(comp.Delete, func(it *crdtItem) {
+				if it.del == 0 {
+					it.del = rev
+				}
+			})
+			if err != nil {
+				return err
+			}
+		case comp.IsInsert():
+			// Go past runs inserted after the same unit that win the tie,
+			// and everything inserted after them.
+			lamport := base + 1
+			for i < len(c.items) && c.items[i].before(lamport, site) {
+				i++
+			}
+			c.items = append(c.items, crdtItem{})
+			copy(c.items[i+1:], c.items[i:])
+			c.items[i] = crdtItem{
+				site:    site,
+				lamport: lamport,
+				ins:     rev,
+				n:       c.unit.Len(comp.Insert),
+				text:    comp.Insert,
+			}
+			i++
+		}
+	}
+	for ; i < len(c.items); i++ {
+		if c.items[i].visible(base) {
+			return fmt.Errorf("operation is shorter than the document")
+		}
+	}
+	return nil
+}
+
+// split divides run i after its first n units.
+func (c *CRDTEngine) split(i, n int) error {
+	left, right := c.items[i], c.items[i]
+	if left.text != "" {
+		pos, err := c.unit.advance(left.text, 0, n)
+		if err != nil {
+			return err
+		}
+		left.text, right.text = left.text[:pos], left.text[pos:]
+	}
+	left.n, right.n = n, left.n-n
+	c.items = append(c.items, crdtItem{})
+	copy(c.items[i+2:], c.items[i+1:])
+	c.items[i], c.items[i+1] = left, right
+	return nil
+}
+
+// rollback removes the effects of revision rev from the sequence.
+func (c *CRDTEngine) rollback(rev int) {
+	items := c.items[:0]
+	for _, it := range c.items {
+		if it.ins == rev {
+			continue
+		}
+		if it.del == rev {
+			it.del = 0
+		}
+		items = append(items, it)
+	}
+	c.items = items
+}
+
+// operation returns the changes revision rev makes to the document at
+// revision rev-1.
+func (c *CRDTEngine) operation(rev int) Operation {
+	var ops []Component
+	for _, it := range c.items {
+		switch {
+		case it.ins == rev:
+			ops = append(ops, Component{Insert: it.text})
+		case !it.visible(rev - 1):
+		case it.del == rev:
+			ops = append(ops, Component{Delete: it.n})
+		default:
+			ops = append(ops, Component{Retain: it.n})
+		}
+	}
+	return Operation{Ops: compact(ops)}
+}
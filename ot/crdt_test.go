@@ -0,0 +1,250 @@
+package ot
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// applyEdits runs edits, each made at the given revision, through engine on
+// a document with content and returns the result.
+func applyEdits(t *testing.T, engine Engine, content string, edits []Edit, revisions []int) *Document {
+	t.Helper()
+	doc := NewDocument(content)
+	for i, e := range edits {
+		op, err := engine.TransformIncoming(Text, e, revisions[i], 0, doc.History)
+		if err != nil {
+			t.Fatalf("edit %d: %v", i, err)
+		}
+		if err := doc.ApplyEdit(Edit{Op: op, Site: e.Site}); err != nil {
+			t.Fatalf("edit %d: %v", i, err)
+		}
+	}
+	return doc
+}
+
+func TestCRDTEngine_TransformIncoming(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		edits     []Edit
+		revisions []int
+		want      string
+	}{
+		{"no history", "hello",
+			[]Edit{{Op: NewInsert(5, "!", 5)}}, []int{0}, "hello!"},
+		{"insert after concurrent insert", "hello",
+			[]Edit{{Op: NewInsert(0, "X", 5), Site: "a"}, {Op: NewInsert(5, "Y", 5), Site: "b"}}, []int{0, 0}, "XhelloY"},
+		{"delete after concurrent insert", "abc",
+			[]Edit{{Op: NewInsert(0, "X", 3), Site: "a"}, {Op: NewDelete(1, 1, 3), Site: "b"}}, []int{0, 0}, "Xac"},
+		{"delete same text", "abc",
+			[]Edit{{Op: NewDelete(0, 2, 3), Site: "a"}, {Op: NewDelete(1, 2, 3), Site: "b"}}, []int{0, 0}, ""},
+		{"insert into deleted text", "abcd",
+			[]Edit{{Op: NewDelete(1, 2, 4), Site: "a"}, {Op: NewInsert(2, "X", 4), Site: "b"}}, []int{0, 0}, "aXd"},
+		{"newer insert goes first", "ab",
+			[]Edit{{Op: NewInsert(0, "1", 2), Site: "c"}, {Op: NewInsert(2, "A", 3), Site: "b"}, {Op: NewInsert(1, "B", 2), Site: "a"}},
+			[]int{0, 1, 0}, "1aABb"},
+		{"sequential edits", "",
+			[]Edit{{Op: NewInsert(0, "ac", 0)}, {Op: NewInsert(1, "b", 2)}, {Op: NewDelete(0, 1, 3)}}, []int{0, 1, 2}, "bc"},
+		{"surrogate pairs", "😀😀",
+			[]Edit{{Op: NewInsert(2, "x", 4), Site: "a"}, {Op: NewDelete(0, 2, 4), Site: "b"}}, []int{0, 0}, "x😀"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := applyEdits(t, &CRDTEngine{}, tt.content, tt.edits, tt.revisions)
+			if doc.Content != tt.want {
+				t.Errorf("content = %q, want %q", doc.Content, tt.want)
+			}
+		})
+	}
+}
+
+// TestCRDTEngine_DeletedText shows where the engines differ: B is made
+// after "xy" is deleted, A before, next to text that is now gone.
+// JupiterEngine ties them by site; the sequence remembers A came after x.
+func TestCRDTEngine_DeletedText(t *testing.T) {
+	edits := []Edit{
+		{Op: NewDelete(0, 2, 2), Site: "s0"},
+		{Op: NewInsert(1, "A", 2), Site: "s1"},
+		{Op: NewInsert(0, "B", 0), Site: "s2"},
+	}
+	revisions := []int{0, 0, 1}
+	if doc := applyEdits(t, &JupiterEngine{}, "xy", edits, revisions); doc.Content != "AB" {
+		t.Errorf("JupiterEngine: content = %q, want %q", doc.Content, "AB")
+	}
+	if doc := applyEdits(t, &CRDTEngine{}, "xy", edits, revisions); doc.Content != "BA" {
+		t.Errorf("CRDTEngine: content = %q, want %q", doc.Content, "BA")
+	}
+}
+
+func TestCRDTEngine_ArrivalOrder(t *testing.T) {
+	edits := []Edit{
+		{Op: NewInsert(1, "A", 2), Site: "site-a"},
+		{Op: NewInsert(1, "B", 2), Site: "site-b"},
+		{Op: NewInsert(1, "C", 2), Site: "site-c"},
+	}
+	orders := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	for _, order := range orders {
+		var ordered []Edit
+		for _, i := range order {
+			ordered = append(ordered, edits[i])
+		}
+		if doc := applyEdits(t, &CRDTEngine{}, "xy", ordered, []int{0, 0, 0}); doc.Content != "xABCy" {
+			t.Errorf("arrival order %v: got %q, want %q", order, doc.Content, "xABCy")
+		}
+	}
+}
+
+func TestCRDTEngine_DroppedResult(t *testing.T) {
+	engine := &CRDTEngine{}
+	doc := NewDocument("ab")
+	if _, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(1, "X", 2)}, 0, 0, doc.History); err != nil {
+		t.Fatal(err)
+	}
+	// The result is never applied, so the next edit doesn't see X.
+	op, err := engine.TransformIncoming(Text, Edit{Op: NewDelete(0, 1, 2)}, 0, 0, doc.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "b" {
+		t.Errorf("content = %q, want %q", doc.Content, "b")
+	}
+}
+
+func TestCRDTEngine_LoadedHistory(t *testing.T) {
+	// History applied without the engine, as when loaded from a store.
+	doc := NewDocument("")
+	for _, op := range []Operation{NewInsert(0, "abc", 0), NewDelete(1, 1, 3)} {
+		if err := doc.Apply(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	op, err := (&CRDTEngine{}).TransformIncoming(Text, Edit{Op: NewInsert(2, "X", 3)}, 1, 0, doc.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "aXc" {
+		t.Errorf("content = %q, want %q", doc.Content, "aXc")
+	}
+}
+
+func TestCRDTEngine_Errors(t *testing.T) {
+	engine := &CRDTEngine{}
+	history := []Edit{{Op: NewInsert(0, "a", 5)}}
+	if _, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(0, "x", 5)}, 2, 0, history); err == nil {
+		t.Error("expected error for revision past history")
+	}
+	if _, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(0, "x", 4)}, 0, 0, history); err == nil {
+		t.Error("expected error for wrong base length")
+	}
+	// A failed edit leaves the sequence as it was.
+	op, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(6, "x", 6)}, 1, 0, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := Apply("aaaaaa", op.(Operation)); got != "aaaaaax" {
+		t.Errorf("got %q, want %q", got, "aaaaaax")
+	}
+}
+
+// TestCRDTEngine_HistoryLimit checks that the engine follows a document
+// whose history is bounded, starting from a base other than 0.
+func TestCRDTEngine_HistoryLimit(t *testing.T) {
+	doc := NewDocument("ab")
+	doc.Version, doc.Base = 10, 10
+	doc.HistoryLimit = 2
+	engine := &CRDTEngine{}
+	for i, e := range []Edit{
+		{Op: NewInsert(1, "X", 2), Site: "a"},
+		{Op: NewInsert(3, "Y", 3), Site: "a"},
+		{Op: NewInsert(0, "Z", 4), Site: "a"},
+	} {
+		op, err := engine.TransformIncoming(Text, e, doc.Version, doc.Base, doc.History)
+		if err != nil {
+			t.Fatalf("edit %d: %v", i, err)
+		}
+		if err := doc.ApplyEdit(Edit{Op: op, Site: e.Site}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Made at revision 11, concurrent with Y and Z.
+	op, err := engine.TransformIncoming(Text, Edit{Op: NewDelete(0, 1, 3), Site: "b"}, 11, doc.Base, doc.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "ZXbY" {
+		t.Errorf("content = %q, want %q", doc.Content, "ZXbY")
+	}
+	if _, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(0, "x", 4)}, 11, doc.Base, doc.History); !errors.Is(err, ErrRevisionTooOld) {
+		t.Errorf("err = %v, want ErrRevisionTooOld", err)
+	}
+}
+
+func TestCRDTEngine_OtherTypes(t *testing.T) {
+	doc, _ := NewTypedDocument(JSON, `{"l":[]}`)
+	if err := doc.Apply(jop(ListInsert(path("l", 0), "a"))); err != nil {
+		t.Fatal(err)
+	}
+	op, err := (&CRDTEngine{}).TransformIncoming(JSON, Edit{Op: jop(ListInsert(path("l", 0), "b")), Site: "b"}, 0, 0, doc.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"l":["a","b"]}`; doc.Content != want {
+		t.Errorf("content = %s, want %s", doc.Content, want)
+	}
+}
+
+// TestCRDTEngine_Random has a few sites edit concurrently, each on a
+// revision it has seen as a client would, and checks every result applies
+// and the sequence's visible text is the document's.
+func TestCRDTEngine_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		engine := &CRDTEngine{}
+		doc := NewDocument("")
+		contents := []string{""} // content at each revision
+		seen := map[string]int{} // last revision each site has seen
+		for step := 0; step < 20; step++ {
+			site := fmt.Sprintf("site%d", r.Intn(3))
+			revision := seen[site] + r.Intn(len(contents)-seen[site])
+			e := Edit{Op: randomOp(r, contents[revision]), Site: site}
+			op, err := engine.TransformIncoming(Text, e, revision, 0, doc.History)
+			if err != nil {
+				t.Fatalf("iteration %d, step %d: %v", i, step, err)
+			}
+			if err := doc.ApplyEdit(Edit{Op: op, Site: site}); err != nil {
+				t.Fatalf("iteration %d, step %d: %v", i, step, err)
+			}
+			if doc.Version == len(contents) {
+				contents = append(contents, doc.Content)
+			}
+			seen[site] = doc.Version
+		}
+		// Bring the sequence up to date with the last edit.
+		if err := engine.sync(UTF16, Operation{}, 0, doc.History); err != nil {
+			t.Fatal(err)
+		}
+		var text string
+		for _, it := range engine.items {
+			if it.visible(len(doc.History)) {
+				text += it.text
+			}
+		}
+		if text != doc.Content {
+			t.Fatalf("iteration %d: sequence has %q, document %q", i, text, doc.Content)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ot
+
+// Bias decides which way a position moves when text is inserted exactly at
+// it.
+type Bias int
+
+const (
+	// BiasLeft keeps the position before text inserted at it, so the
+	// position sticks to the character on its left.
+	BiasLeft Bias = iota
+	// BiasRight moves the position after text inserted at it, so the
+	// position sticks to the character on its right. This is how the
+	// browser client moves its own caret through remote operations.
+	BiasRight
+)
+
+// Positioner is implemented by types whose documents are text, so that
+// positions in them, such as carets and comment anchors, can follow edits.
+type Positioner interface {
+	// Len returns the length of snapshot, in the units positions count.
+	Len(snapshot any) (int, error)
+	// TransformIndex is like the package-level TransformIndex, counting
+	// offsets in the type's unit.
+	TransformIndex(index int, op Op, bias Bias) (int, error)
+}
+
+// Range is a span of a document, such as a selection or the text a comment
+// is attached to. Start may be greater than End for a backwards selection.
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// TransformIndex returns where index, a position in the document op applies
+// to, ends up in the document op produces. A position inside deleted text
+// moves to the start of the deletion. Offsets are counted in UTF-16 code
+// units.
+func TransformIndex(index int, op Operation, bias Bias) int {
+	return UTF16.TransformIndex(index, op, bias)
+}
+
+// TransformIndex is like the package-level TransformIndex but counts
+// offsets in u.
+func (u Unit) TransformIndex(index int, op Operation, bias Bias) int {
+	pos := 0 // position in the input document
+	newIndex := index
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			pos += c.Retain
+		case c.IsInsert():
+			if pos < index || pos == index && bias == BiasRight {
+				newIndex += u.Len(c.Insert)
+			}
+		case c.IsDelete():
+			if pos < index {
+				newIndex -= min(c.Delete, index-pos)
+			}
+			pos += c.Delete
+		}
+		if pos > index {
+			break
+		}
+	}
+	return newIndex
+}
+
+// TransformRange transforms both ends of r through op with the same bias.
+// Offsets are counted in UTF-16 code units.
+func TransformRange(r Range, op Operation, bias Bias) Range {
+	return UTF16.TransformRange(r, op, bias)
+}
+
+// TransformRange is like the package-level TransformRange but counts
+// offsets in u.
+func (u Unit) TransformRange(r Range, op Operation, bias Bias) Range {
+	return Range{
+		Start: u.TransformIndex(r.Start, op, bias),
+		End:   u.TransformIndex(r.End, op, bias),
+	}
+}
@@ -0,0 +1,170 @@
+package ot
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestTransformIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		index     int
+		op        Operation
+		wantLeft  int
+		wantRight int
+	}{
+		{"insert before", 3, NewInsert(1, "XY", 5), 5, 5},
+		{"insert after", 1, NewInsert(3, "XY", 5), 1, 1},
+		{"insert at index", 2, NewInsert(2, "XY", 5), 2, 4},
+		{"insert at start, index 0", 0, NewInsert(0, "X", 5), 0, 1},
+		{"insert at end", 5, NewInsert(5, "X", 5), 5, 6},
+		{"delete before", 4, NewDelete(0, 2, 5), 2, 2},
+		{"delete after", 1, NewDelete(2, 2, 5), 1, 1},
+		{"delete containing index", 3, NewDelete(1, 3, 5), 1, 1},
+		{"delete ending at index", 3, NewDelete(1, 2, 5), 1, 1},
+		{"delete starting at index", 1, NewDelete(1, 2, 5), 1, 1},
+		{"replace around index", 2, Operation{[]Component{{Retain: 1}, {Delete: 3}, {Insert: "XY"}, {Retain: 1}}}, 1, 1},
+		{"unicode insert before", 1, NewInsert(0, "😀", 3), 3, 3},
+		{"noop", 2, Operation{[]Component{{Retain: 5}}}, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TransformIndex(tt.index, tt.op, BiasLeft); got != tt.wantLeft {
+				t.Errorf("BiasLeft: got %d, want %d", got, tt.wantLeft)
+			}
+			if got := TransformIndex(tt.index, tt.op, BiasRight); got != tt.wantRight {
+				t.Errorf("BiasRight: got %d, want %d", got, tt.wantRight)
+			}
+		})
+	}
+}
+
+func TestTransformIndex_CodePoint(t *testing.T) {
+	// "😀" is one code point, so the index shifts by 1, not 2.
+	if got := CodePoint.TransformIndex(1, NewInsert(0, "😀", 3), BiasLeft); got != 2 {
+		t.Errorf("got %d, want 2", got)
+	}
+}
+
+func TestTransformRange(t *testing.T) {
+	op := Operation{[]Component{{Retain: 2}, {Insert: "X"}, {Retain: 2}, {Insert: "Y"}, {Retain: 1}}}
+	r := Range{Start: 2, End: 4}
+
+	// "X" is inserted at Start and "Y" at End. With BiasLeft both ends stay
+	// before the text inserted at them; with BiasRight both move past it.
+	if got := TransformRange(r, op, BiasLeft); got != (Range{Start: 2, End: 5}) {
+		t.Errorf("BiasLeft: got %+v", got)
+	}
+	if got := TransformRange(r, op, BiasRight); got != (Range{Start: 3, End: 6}) {
+		t.Errorf("BiasRight: got %+v", got)
+	}
+
+	backwards := Range{Start: 4, End: 2}
+	if got := TransformRange(backwards, op, BiasRight); got != (Range{Start: 6, End: 3}) {
+		t.Errorf("backwards: got %+v", got)
+	}
+}
+
+// jsTransformIndex is a direct port of transformIndex in static/js/main.js.
+func jsTransformIndex(index int, op Operation) int {
+	pos, newIndex := 0, index
+	for _, c := range op.Ops {
+		if pos > index {
+			break
+		}
+		if c.Retain > 0 {
+			pos += c.Retain
+		} else if c.Insert != "" {
+			if pos <= index {
+				newIndex += UTF16.Len(c.Insert)
+			}
+		} else if c.Delete > 0 {
+			if pos+c.Delete <= index {
+				newIndex -= c.Delete
+			} else if pos < index {
+				newIndex -= index - pos
+			}
+			pos += c.Delete
+		}
+	}
+	return max(0, newIndex)
+}
+
+// TestTransformIndex_MatchesClient checks that BiasRight moves positions
+// exactly as the browser client moves its caret.
+func TestTransformIndex_MatchesClient(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := randomText(r, r.Intn(10))
+		op := randomOp(r, doc)
+		for index := 0; index <= UTF16.Len(doc); index++ {
+			got := TransformIndex(index, op, BiasRight)
+			want := jsTransformIndex(index, op)
+			if got != want {
+				t.Fatalf("index %d through %+v: got %d, client gives %d", index, op.Ops, got, want)
+			}
+		}
+	}
+}
+
+// TestTransformIndex_StaysInBounds checks that transformed positions are
+// valid in the output document and keep their order.
+func TestTransformIndex_StaysInBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 500; i++ {
+		doc := randomText(r, r.Intn(10))
+		op := randomOp(r, doc)
+		for _, bias := range []Bias{BiasLeft, BiasRight} {
+			prev := 0
+			for index := 0; index <= op.BaseLen(); index++ {
+				got := TransformIndex(index, op, bias)
+				if got < prev || got > op.TargetLen() {
+					t.Fatalf("index %d through %+v (bias %d): got %d (prev %d, target len %d)",
+						index, op.Ops, bias, got, prev, op.TargetLen())
+				}
+				prev = got
+			}
+		}
+	}
+}
+
+func TestPositioner(t *testing.T) {
+	for _, tt := range []struct {
+		typ      Type
+		snapshot any
+	}{
+		{Text, "a😀b"},
+		{Text, NewRope("a😀b")},
+		{Rich, RichText{[]Component{{Insert: "a😀", Attributes: Attributes{"bold": true}}, {Insert: "b"}}}},
+	} {
+		p, ok := tt.typ.(Positioner)
+		if !ok {
+			t.Fatalf("%s is not a Positioner", tt.typ.Name())
+		}
+		n, err := p.Len(tt.snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 4 {
+			t.Errorf("%s: Len = %d, want 4", tt.typ.Name(), n)
+		}
+		got, err := p.TransformIndex(3, NewInsert(0, "xy", 4), BiasLeft)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 5 {
+			t.Errorf("%s: TransformIndex = %d, want 5", tt.typ.Name(), got)
+		}
+	}
+
+	cp := TextType{Unit: CodePoint}
+	if n, _ := cp.Len("a😀b"); n != 3 {
+		t.Errorf("code point Len = %d, want 3", n)
+	}
+	if _, err := Text.TransformIndex(0, 1, BiasLeft); err == nil {
+		t.Error("expected error for non-Operation op")
+	}
+	if _, ok := Type(JSON).(Positioner); ok {
+		t.Error("JSON documents have no text positions")
+	}
+}
@@ -0,0 +1,252 @@
+package ot
+
+import "strings"
+
+// Diff tuning. Texts whose changed middle is longer than lineModeLen runes
+// are diffed line by line first, and the changed lines then character by
+// character. A diff needing more than maxDiffEdits insertions and deletions
+// gives up on minimality and replaces the whole region instead.
+const (
+	lineModeLen  = 2000
+	maxDiffEdits = 1000
+)
+
+// FromDiff returns an operation that turns the text before into after,
+// inserting and deleting as little as possible, for integrations that
+// only have the old and new text. The result is normalized and can be
+// submitted like any client edit. Offsets are counted in UTF-16 code units.
+func FromDiff(before, after string) Operation {
+	return UTF16.FromDiff(before, after)
+}
+
+// FromDiff is like the package-level FromDiff but counts offsets in u.
+func (u Unit) FromDiff(before, after string) Operation {
+	a, b := []rune(before), []rune(after)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	d := differ{unit: u, a: a, b: b}
+	d.retain(prefix)
+	d.diff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	d.retain(suffix)
+	return Normalize(Operation{Ops: d.ops})
+}
+
+// differ builds an operation from a diff of the runes a into b.
+type differ struct {
+	unit Unit
+	a, b []rune
+	i, j int // runes of a and b covered so far
+	ops  []Component
+}
+
+func (d *differ) retain(n int) {
+	d.ops = append(d.ops, Component{Retain: d.unit.Len(string(d.a[d.i : d.i+n]))})
+	d.i += n
+	d.j += n
+}
+
+func (d *differ) delete(n int) {
+	d.ops = append(d.ops, Component{Delete: d.unit.Len(string(d.a[d.i : d.i+n]))})
+	d.i += n
+}
+
+func (d *differ) insert(n int) {
+	d.ops = append(d.ops, Component{Insert: string(d.b[d.j : d.j+n])})
+	d.j += n
+}
+
+// diff adds the changes from a to b, the next runes of d.a and d.b.
+func (d *differ) diff(a, b []rune) {
+	if len(a)+len(b) > lineModeLen {
+		d.diffLines(a, b)
+		return
+	}
+	d.diffRunes(a, b)
+}
+
+func (d *differ) diffRunes(a, b []rune) {
+	script, ok := myers(a, b)
+	if !ok {
+		d.delete(len(a))
+		d.insert(len(b))
+		return
+	}
+	for _, r := range script {
+		switch r.kind {
+		case diffEqual:
+			d.retain(r.n)
+		case diffDelete:
+			d.delete(r.n)
+		case diffInsert:
+			d.insert(r.n)
+		}
+	}
+}
+
+// diffLines diffs a and b as lines, then diffs each run of changed lines
+// by rune.
+func (d *differ) diffLines(a, b []rune) {
+	ids := map[string]int{}
+	la, lb := splitLines(a, ids), splitLines(b, ids)
+	script, ok := myers(la.ids, lb.ids)
+	if !ok {
+		d.delete(len(a))
+		d.insert(len(b))
+		return
+	}
+	x, y := 0, 0 // lines of la and lb covered so far
+	for k := 0; k < len(script); k++ {
+		r := script[k]
+		if r.kind == diffEqual {
+			d.retain(la.runes(x, x+r.n))
+			x += r.n
+			y += r.n
+			continue
+		}
+		dx, dy := 0, 0
+		for ; k < len(script) && script[k].kind != diffEqual; k++ {
+			if script[k].kind == diffDelete {
+				dx += script[k].n
+			} else {
+				dy += script[k].n
+			}
+		}
+		k--
+		d.diffRunes(d.a[d.i:d.i+la.runes(x, x+dx)], d.b[d.j:d.j+lb.runes(y, y+dy)])
+		x += dx
+		y += dy
+	}
+}
+
+// lines is a text split into lines, each ending with its newline.
+type lines struct {
+	ids   []int // line contents, numbered
+	start []int // rune offset of each line, and of the end of the text
+}
+
+func splitLines(text []rune, ids map[string]int) lines {
+	var l lines
+	s := string(text)
+	offset := 0
+	for s != "" {
+		line := s
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			line = s[:i+1]
+		}
+		id, ok := ids[line]
+		if !ok {
+			id = len(ids)
+			ids[line] = id
+		}
+		l.ids = append(l.ids, id)
+		l.start = append(l.start, offset)
+		offset += len([]rune(line))
+		s = s[len(line):]
+	}
+	l.start = append(l.start, offset)
+	return l
+}
+
+// runes returns the number of runes in lines [i, j).
+func (l lines) runes(i, j int) int { return l.start[j] - l.start[i] }
+
+type diffKind int
+
+const (
+	diffEqual diffKind = iota
+	diffDelete
+	diffInsert
+)
+
+// diffRun is n tokens kept, deleted or inserted.
+type diffRun struct {
+	kind diffKind
+	n    int
+}
+
+// myers returns the shortest edit script from a to b, using Myers'
+// O((N+M)D) algorithm. It reports false if the script needs more than
+// maxDiffEdits insertions and deletions.
+func myers[T comparable](a, b []T) ([]diffRun, bool) {
+	n, m := len(a), len(b)
+	// v[k+off] is the furthest x reached on diagonal k = x-y; trace[d]
+	// holds v before step d, for diagonals -d-1 to d+1.
+	off := maxDiffEdits + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; d <= min(n+m, maxDiffEdits); d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1] // down: insert b[y-1]
+			} else {
+				x = v[off+k-1] + 1 // right: delete a[x-1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrack follows the furthest-reaching paths in trace back from (n, m)
+// and returns the edit script they make.
+func backtrack(trace [][]int, n, m int) []diffRun {
+	var script []diffRun
+	add := func(kind diffKind, count int) {
+		if count == 0 {
+			return
+		}
+		if l := len(script); l > 0 && script[l-1].kind == kind {
+			script[l-1].n += count
+			return
+		}
+		script = append(script, diffRun{kind, count})
+	}
+
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		snake := min(x-prevX, y-prevY)
+		if d == 0 {
+			snake = x
+		}
+		add(diffEqual, snake)
+		x, y = x-snake, y-snake
+		if d > 0 {
+			if x == prevX {
+				add(diffInsert, 1)
+			} else {
+				add(diffDelete, 1)
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
@@ -0,0 +1,176 @@
+package ot
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestFromDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          []Component
+	}{
+		{"identical", "abc", "abc", []Component{{Retain: 3}}},
+		{"both empty", "", "", nil},
+		{"from empty", "", "abc", []Component{{Insert: "abc"}}},
+		{"to empty", "abc", "", []Component{{Delete: 3}}},
+		{"insert", "hello world", "hello, world", []Component{{Retain: 5}, {Insert: ","}, {Retain: 6}}},
+		{"delete", "hello, world", "hello world", []Component{{Retain: 5}, {Delete: 1}, {Retain: 6}}},
+		{"replace", "the cat sat", "the dog sat", []Component{{Retain: 4}, {Insert: "dog"}, {Delete: 3}, {Retain: 4}}},
+		{"several changes", "abcdef", "xbcdyf", []Component{{Insert: "x"}, {Delete: 1}, {Retain: 3}, {Insert: "y"}, {Delete: 1}, {Retain: 1}}},
+		{"surrogate pairs", "a😀b", "a😀c😀b", []Component{{Retain: 3}, {Insert: "c😀"}, {Retain: 1}}},
+		{"shared surrogate half", "😀", "😁", []Component{{Insert: "😁"}, {Delete: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := FromDiff(tt.before, tt.after)
+			if !opsEqual(op.Ops, tt.want) {
+				t.Errorf("FromDiff(%q, %q) = %+v, want %+v", tt.before, tt.after, op.Ops, tt.want)
+			}
+			got, err := Apply(tt.before, op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.after {
+				t.Errorf("Apply() = %q, want %q", got, tt.after)
+			}
+		})
+	}
+}
+
+func TestFromDiff_CodePoint(t *testing.T) {
+	op := CodePoint.FromDiff("a😀b", "a😀c😀b")
+	want := []Component{{Retain: 2}, {Insert: "c😀"}, {Retain: 1}}
+	if !opsEqual(op.Ops, want) {
+		t.Errorf("FromDiff() = %+v, want %+v", op.Ops, want)
+	}
+}
+
+// editDistance returns the fewest runes to insert and delete to turn a
+// into b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur[j] = min(prev[j], cur[j-1]) + 1
+			if a[i-1] == b[j-1] {
+				cur[j] = min(cur[j], prev[j-1])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// changed returns the number of runes op inserts and deletes in doc.
+func changed(doc string, op Operation) int {
+	n, pos := 0, 0
+	for _, c := range op.Ops {
+		switch {
+		case c.IsInsert():
+			n += len([]rune(c.Insert))
+		case c.IsDelete():
+			end, _ := UTF16.advance(doc, pos, c.Delete)
+			n += len([]rune(doc[pos:end]))
+			pos = end
+		case c.IsRetain():
+			pos, _ = UTF16.advance(doc, pos, c.Retain)
+		}
+	}
+	return n
+}
+
+func TestFromDiff_Minimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		before := randomText(r, r.Intn(20))
+		after, err := Apply(before, randomOp(r, before))
+		if err != nil {
+			t.Fatal(err)
+		}
+		op := FromDiff(before, after)
+		if got, err := Apply(before, op); err != nil || got != after {
+			t.Fatalf("Apply(%q, FromDiff(%q, %q)) = %q, %v", before, before, after, got, err)
+		}
+		if got, want := changed(before, op), editDistance([]rune(before), []rune(after)); got != want {
+			t.Fatalf("FromDiff(%q, %q) changes %d runes, want %d", before, after, got, want)
+		}
+		if !opsEqual(op.Ops, Normalize(op).Ops) {
+			t.Fatalf("FromDiff(%q, %q) = %+v is not normalized", before, after, op.Ops)
+		}
+	}
+}
+
+// randomLines returns n random lines.
+func randomLines(r *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = randomText(r, r.Intn(40)) + "\n"
+	}
+	return lines
+}
+
+func TestFromDiff_LineMode(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 50; i++ {
+		lines := randomLines(r, 200)
+		before := strings.Join(lines, "")
+		// Change a few lines: edit, delete or add them.
+		for j := 0; j < 5; j++ {
+			k := r.Intn(len(lines))
+			switch r.Intn(3) {
+			case 0:
+				lines[k] = randomText(r, 3) + lines[k]
+			case 1:
+				lines = append(lines[:k], lines[k+1:]...)
+			case 2:
+				lines = append(lines[:k], append(randomLines(r, 2), lines[k:]...)...)
+			}
+		}
+		after := strings.Join(lines, "")
+
+		op := FromDiff(before, after)
+		if got, err := Apply(before, op); err != nil || got != after {
+			t.Fatalf("iteration %d: Apply(before, FromDiff(before, after)) failed: %v", i, err)
+		}
+		// Unchanged lines are retained: only the few changed ones are
+		// inserted or deleted.
+		if n := changed(before, op); n > 5*2*45 {
+			t.Errorf("iteration %d: %d runes changed", i, n)
+		}
+	}
+}
+
+func TestFromDiff_TooManyEdits(t *testing.T) {
+	// Alternate runes differ, so the diff needs more edits than allowed
+	// and falls back to replacing the text.
+	var a, b strings.Builder
+	for i := 0; i < maxDiffEdits; i++ {
+		a.WriteString("ax")
+		b.WriteString("ay")
+	}
+	op := FromDiff(a.String(), b.String())
+	if got, err := Apply(a.String(), op); err != nil || got != b.String() {
+		t.Fatalf("Apply(before, FromDiff(before, after)) failed: %v", err)
+	}
+}
+
+func BenchmarkFromDiff(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	lines := randomLines(r, 5000)
+	before := strings.Join(lines, "")
+	lines[100] = "changed\n"
+	lines = append(lines[:2000], lines[2001:]...)
+	after := strings.Join(lines, "")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FromDiff(before, after)
+	}
+}
@@ -2,29 +2,125 @@ package ot
 
 import "fmt"
 
-// Document represents a collaborative document with its full operation history.
+// Edit is an operation in a document's history, with the site (client)
+// that made it and its author. Engines use the site to break ties between
+// concurrent edits; the author, who may edit from several sites, is what
+// Blame attributes text to. Either is empty if unknown. Seq is the site's
+// number for the edit, if it numbers its edits, so that it can recognize
+// them in the history; it is zero otherwise.
+type Edit struct {
+	Op     Op
+	Site   string
+	Author string
+	Seq    int
+
+	// checkpoint caches, on the last edit of each checkpoint interval,
+	// the composition of the interval's edits (see JupiterEngine).
+	checkpoint Op
+}
+
+// Document represents a collaborative document with the operation history
+// since revision Base. History holds the edits that took the document from
+// Base to Version, so Version == Base+len(History).
 type Document struct {
+	Type Type
+	// Content is the serialized snapshot, kept in sync with every Apply
+	// unless LazyContent is set.
+	//
+	// Deprecated: Use Serialized. Keeping Content in sync copies a
+	// plain-text document on every edit.
 	Content string
 	Version int
-	History []Operation
+	Base    int
+	History []Edit
+
+	// HistoryLimit, if positive, is the most edits History keeps. Older
+	// edits are dropped as new ones are applied, advancing Base.
+	HistoryLimit int
+
+	// LazyContent, if set, stops Apply from keeping Content in sync, so
+	// that applying a run of edits to a large text document doesn't copy
+	// the text each time. Serialized is then the only way to read it.
+	LazyContent bool
+
+	snapshot any
+	// content is the serialized snapshot. For Rope snapshots it is stale
+	// while dirty is set and rebuilt by Serialized.
+	content string
+	dirty   bool
 }
 
-// NewDocument creates a new document with the given initial content.
+// NewDocument creates a new plain-text document with the given initial content.
 func NewDocument(content string) *Document {
-	return &Document{Content: content}
+	return &Document{Type: Text, Content: content, snapshot: NewRope(content), content: content}
+}
+
+// NewTypedDocument creates a document of type t from a serialized snapshot.
+// Content holds the snapshot re-serialized, so an empty string becomes the
+// type's encoding of an empty document.
+func NewTypedDocument(t Type, content string) (*Document, error) {
+	snapshot, err := t.Create(content)
+	if err != nil {
+		return nil, fmt.Errorf("create %s document: %w", t.Name(), err)
+	}
+	if content, err = t.Serialize(snapshot); err != nil {
+		return nil, fmt.Errorf("create %s document: %w", t.Name(), err)
+	}
+	if _, ok := t.(TextType); ok {
+		snapshot = NewRope(content)
+	}
+	return &Document{Type: t, Content: content, snapshot: snapshot, content: content}, nil
+}
+
+// Snapshot returns the document state in its type's representation. The
+// snapshot of a plain-text document is a Rope.
+func (d *Document) Snapshot() any {
+	return d.snapshot
+}
+
+// Serialized returns the serialized snapshot. For a plain-text document it
+// is computed on the first call after an edit.
+func (d *Document) Serialized() string {
+	if d.dirty {
+		d.content, d.dirty = d.snapshot.(Rope).String(), false
+	}
+	return d.content
 }
 
-// Apply applies an operation to the document, appending it to history.
-func (d *Document) Apply(op Operation) error {
-	if op.IsNoop() {
+// Apply applies an operation from an unknown site to the document,
+// appending it to history.
+func (d *Document) Apply(op Op) error {
+	return d.ApplyEdit(Edit{Op: op})
+}
+
+// ApplyEdit applies e's operation to the document, appending e to history.
+func (d *Document) ApplyEdit(e Edit) error {
+	if d.Type.IsNoop(e.Op) {
 		return nil
 	}
-	result, err := Apply(d.Content, op)
+	result, err := d.Type.Apply(d.snapshot, e.Op)
 	if err != nil {
 		return fmt.Errorf("apply to document v%d: %w", d.Version, err)
 	}
-	d.Content = result
+	if _, ok := result.(Rope); ok {
+		d.dirty = true
+	} else {
+		content, err := d.Type.Serialize(result)
+		if err != nil {
+			return fmt.Errorf("apply to document v%d: %w", d.Version, err)
+		}
+		d.content = content
+	}
+	d.snapshot = result
+	if !d.LazyContent {
+		d.Content = d.Serialized()
+	}
 	d.Version++
-	d.History = append(d.History, op)
+	d.History = append(d.History, e)
+	if d.HistoryLimit > 0 && len(d.History) > d.HistoryLimit {
+		drop := len(d.History) - d.HistoryLimit
+		d.History = d.History[drop:]
+		d.Base += drop
+	}
 	return nil
 }
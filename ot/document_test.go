@@ -61,3 +61,34 @@ func TestDocument_ApplyError(t *testing.T) {
 		t.Errorf("document modified after error: %q v%d", doc.Content, doc.Version)
 	}
 }
+
+func TestDocument_LazyContent(t *testing.T) {
+	doc := NewDocument("hello")
+	doc.LazyContent = true
+	if err := doc.Apply(NewInsert(5, "!", 5)); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "hello" {
+		t.Errorf("Content = %q, want it left at %q", doc.Content, "hello")
+	}
+	if got := doc.Serialized(); got != "hello!" {
+		t.Errorf("Serialized() = %q, want %q", got, "hello!")
+	}
+}
+
+func TestDocument_HistoryLimit(t *testing.T) {
+	doc := NewDocument("")
+	doc.HistoryLimit = 3
+	for i := 0; i < 5; i++ {
+		if err := doc.Apply(NewInsert(i, "x", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if doc.Version != 5 || doc.Base != 2 || len(doc.History) != 3 {
+		t.Errorf("version=%d base=%d history=%d, want 5, 2 and 3", doc.Version, doc.Base, len(doc.History))
+	}
+	// The oldest edit kept takes the document from Base to Base+1.
+	if op := doc.History[0].Op.(Operation); op.BaseLen() != 2 {
+		t.Errorf("history[0] base length = %d, want 2", op.BaseLen())
+	}
+}
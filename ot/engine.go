@@ -1,33 +1,158 @@
 package ot
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRevisionTooOld is returned by TransformIncoming for edits made at a
+// revision that is no longer in the history. The client must resync: fetch
+// the current document and redo its edits on that.
+var ErrRevisionTooOld = errors.New("revision too old, resync")
 
 // Engine abstracts the OT collaboration algorithm.
 // Different algorithms (Jupiter, Wave, etc.) implement this interface.
 type Engine interface {
-	// TransformIncoming transforms a client operation (created at the given
-	// revision) against all operations in the history since that revision.
+	// TransformIncoming transforms a client edit on a document of type t
+	// (created at the given revision) against all edits in the history
+	// since that revision. The history holds the edits made since revision
+	// base (see Document.Base); edits made before base fail with
+	// ErrRevisionTooOld. Ties are broken by site, so the result doesn't
+	// depend on the order in which concurrent edits arrive.
 	// Returns the operation transformed to apply at the current server state.
-	TransformIncoming(op Operation, revision int, history []Operation) (Operation, error)
+	TransformIncoming(t Type, e Edit, revision, base int, history []Edit) (Op, error)
 }
 
+// checkpointInterval is the number of history edits composed into each
+// checkpoint.
+const checkpointInterval = 64
+
 // JupiterEngine implements the Jupiter OT algorithm.
 // It sequentially transforms the incoming operation against each
 // server operation the client hasn't seen.
+//
+// For clients far behind, whole checkpoint intervals of history are
+// composed into one operation and transformed against at once. Intervals
+// start at revisions that are multiples of checkpointInterval, and the
+// composition is cached in the history, on the interval's last edit. It is
+// only used when the incoming operation doesn't touch the interval's
+// changes, so the result is always the one sequential transformation
+// gives: ties are broken per edit, by site.
 type JupiterEngine struct{}
 
-func (e *JupiterEngine) TransformIncoming(op Operation, revision int, history []Operation) (Operation, error) {
-	if revision < 0 || revision > len(history) {
-		return Operation{}, fmt.Errorf("invalid revision %d (history len %d)", revision, len(history))
+func (j *JupiterEngine) TransformIncoming(t Type, e Edit, revision, base int, history []Edit) (Op, error) {
+	if err := checkRevision(revision, base, history); err != nil {
+		return nil, err
 	}
 
-	transformed := op
-	for i := revision; i < len(history); i++ {
+	transformed := e.Op
+	for i := revision - base; i < len(history); {
+		if (base+i)%checkpointInterval == 0 && i+checkpointInterval <= len(history) {
+			composed, err := checkpoint(t, history, i)
+			if err == nil && independent(transformed, composed) {
+				if transformed, _, err = t.Transform(transformed, composed); err != nil {
+					return nil, fmt.Errorf("transform against history[%d:%d]: %w", i, i+checkpointInterval, err)
+				}
+				i += checkpointInterval
+				continue
+			}
+		}
+
 		var err error
-		transformed, _, err = Transform(transformed, history[i])
+		transformed, _, err = TransformSide(t, transformed, history[i].Op, SiteSide(e.Site, history[i].Site))
 		if err != nil {
-			return Operation{}, fmt.Errorf("transform against history[%d]: %w", i, err)
+			return nil, fmt.Errorf("transform against history[%d]: %w", i, err)
 		}
+		i++
 	}
 	return transformed, nil
 }
+
+// checkRevision checks that revision is within the history held since base.
+func checkRevision(revision, base int, history []Edit) error {
+	if revision < 0 || revision > base+len(history) {
+		return fmt.Errorf("invalid revision %d (document at %d)", revision, base+len(history))
+	}
+	if revision < base {
+		return fmt.Errorf("revision %d (history starts at %d): %w", revision, base, ErrRevisionTooOld)
+	}
+	return nil
+}
+
+// checkpoint returns the composition of the checkpoint interval of history
+// starting at i, computing and caching it if needed.
+func checkpoint(t Type, history []Edit, i int) (Op, error) {
+	last := &history[i+checkpointInterval-1]
+	if last.checkpoint != nil {
+		return last.checkpoint, nil
+	}
+	composed := history[i].Op
+	for _, e := range history[i+1 : i+checkpointInterval] {
+		var err error
+		if composed, err = t.Compose(composed, e.Op); err != nil {
+			return nil, err
+		}
+	}
+	last.checkpoint = composed
+	return composed, nil
+}
+
+// independent reports whether transforming a against b gives the same
+// result as transforming it against any sequence of operations that
+// composes to b, whatever sides they take. That holds when a breaks no ties
+// with b: none of a's inserts is where b inserts or next to or inside text
+// b deletes, and a doesn't format text that b formats. Only text
+// operations are checked; for other types it reports false.
+func independent(a, b Op) bool {
+	oa, ok := a.(Operation)
+	if !ok {
+		return false
+	}
+	ob, ok := b.(Operation)
+	if !ok {
+		return false
+	}
+	aInserts, _, aFormats := changes(oa)
+	bInserts, bDeletes, bFormats := changes(ob)
+	for _, p := range aInserts {
+		for _, q := range bInserts {
+			if p == q {
+				return false
+			}
+		}
+		for _, d := range bDeletes {
+			if d[0] <= p && p <= d[1] {
+				return false
+			}
+		}
+	}
+	for _, f := range aFormats {
+		for _, g := range bFormats {
+			if f[0] < g[1] && g[0] < f[1] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// changes returns where op inserts, and the ranges it deletes and formats,
+// as offsets into the document it applies to.
+func changes(op Operation) (inserts []int, deletes, formats [][2]int) {
+	pos := 0
+	for _, c := range op.Ops {
+		switch {
+		case c.IsInsert():
+			inserts = append(inserts, pos)
+		case c.IsDelete():
+			deletes = append(deletes, [2]int{pos, pos + c.Delete})
+			pos += c.Delete
+		case c.IsRetain():
+			if len(c.Attributes) > 0 {
+				formats = append(formats, [2]int{pos, pos + c.Retain})
+			}
+			pos += c.Retain
+		}
+	}
+	return inserts, deletes, formats
+}
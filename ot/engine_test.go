@@ -1,30 +1,36 @@
 package ot
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
 
 func TestJupiterEngine_TransformIncoming(t *testing.T) {
 	engine := &JupiterEngine{}
 
 	t.Run("no history to transform against", func(t *testing.T) {
 		op := NewInsert(0, "x", 5)
-		result, err := engine.TransformIncoming(op, 0, nil)
+		result, err := engine.TransformIncoming(Text, Edit{Op: op}, 0, 0, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
 		// Should return unchanged
-		if result.BaseLen() != op.BaseLen() {
-			t.Errorf("BaseLen changed: %d vs %d", result.BaseLen(), op.BaseLen())
+		if result.(Operation).BaseLen() != op.BaseLen() {
+			t.Errorf("BaseLen changed: %d vs %d", result.(Operation).BaseLen(), op.BaseLen())
 		}
 	})
 
 	t.Run("transform against one operation", func(t *testing.T) {
 		// Doc: "hello" (len 5)
 		// Server applied: insert "X" at 0 → "Xhello" (len 6)
-		history := []Operation{NewInsert(0, "X", 5)}
+		history := []Edit{{Op: NewInsert(0, "X", 5)}}
 		// Client sends: insert "Y" at 5 (end of "hello"), at revision 0
 		clientOp := NewInsert(5, "Y", 5)
 
-		result, err := engine.TransformIncoming(clientOp, 0, history)
+		result, err := engine.TransformIncoming(Text, Edit{Op: clientOp}, 0, 0, history)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -32,9 +38,9 @@ func TestJupiterEngine_TransformIncoming(t *testing.T) {
 		// After server applied "X" at 0, doc is "Xhello" (len 6).
 		// Client's insert at 5 should become insert at 6 (shifted by X).
 		doc := "Xhello"
-		got, err := Apply(doc, result)
+		got, err := Apply(doc, result.(Operation))
 		if err != nil {
-			t.Fatalf("Apply error: %v (result=%+v, doc=%q)", err, result.Ops, doc)
+			t.Fatalf("Apply error: %v (result=%+v, doc=%q)", err, result, doc)
 		}
 		if got != "XhelloY" {
 			t.Errorf("got %q, want %q", got, "XhelloY")
@@ -46,14 +52,14 @@ func TestJupiterEngine_TransformIncoming(t *testing.T) {
 		// Server history:
 		//   v0→v1: insert "X" at 0 → "Xabc" (len 4)
 		//   v1→v2: insert "Y" at 4 → "XabcY" (len 5)
-		history := []Operation{
-			NewInsert(0, "X", 3),
-			NewInsert(4, "Y", 4),
+		history := []Edit{
+			{Op: NewInsert(0, "X", 3)},
+			{Op: NewInsert(4, "Y", 4)},
 		}
 		// Client at revision 0 sends: delete 'b' at position 1, doc len 3
 		clientOp := NewDelete(1, 1, 3)
 
-		result, err := engine.TransformIncoming(clientOp, 0, history)
+		result, err := engine.TransformIncoming(Text, Edit{Op: clientOp}, 0, 0, history)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -63,9 +69,9 @@ func TestJupiterEngine_TransformIncoming(t *testing.T) {
 		// After "X" inserted at 0, 'b' is at pos 2.
 		// After "Y" inserted at 4, 'b' is still at pos 2.
 		doc := "XabcY"
-		got, err := Apply(doc, result)
+		got, err := Apply(doc, result.(Operation))
 		if err != nil {
-			t.Fatalf("Apply error: %v (result=%+v, doc=%q)", err, result.Ops, doc)
+			t.Fatalf("Apply error: %v (result=%+v, doc=%q)", err, result, doc)
 		}
 		if got != "XacY" {
 			t.Errorf("got %q, want %q", got, "XacY")
@@ -73,15 +79,68 @@ func TestJupiterEngine_TransformIncoming(t *testing.T) {
 	})
 
 	t.Run("invalid revision", func(t *testing.T) {
-		_, err := engine.TransformIncoming(NewInsert(0, "x", 5), -1, nil)
+		_, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(0, "x", 5)}, -1, 0, nil)
 		if err == nil {
 			t.Error("expected error for negative revision")
 		}
-		_, err = engine.TransformIncoming(NewInsert(0, "x", 5), 5, []Operation{NewInsert(0, "a", 5)})
+		_, err = engine.TransformIncoming(Text, Edit{Op: NewInsert(0, "x", 5)}, 5, 0, []Edit{{Op: NewInsert(0, "a", 5)}})
 		if err == nil {
 			t.Error("expected error for revision > history length")
 		}
 	})
+
+	t.Run("revision before history", func(t *testing.T) {
+		history := []Edit{{Op: NewInsert(0, "a", 5)}}
+		_, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(0, "x", 4)}, 2, 3, history)
+		if !errors.Is(err, ErrRevisionTooOld) {
+			t.Errorf("err = %v, want ErrRevisionTooOld", err)
+		}
+		result, err := engine.TransformIncoming(Text, Edit{Op: NewInsert(5, "x", 5)}, 3, 3, history)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := Apply("aaaaaa", result.(Operation)); got != "aaaaaax" {
+			t.Errorf("got %q, want %q", got, "aaaaaax")
+		}
+	})
+}
+
+// TestJupiterEngine_HistoryLimit checks that transforming against a
+// bounded history, whose checkpoints no longer start at its first edit,
+// gives the same results as against the full history.
+func TestJupiterEngine_HistoryLimit(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	full := NewDocument("")
+	window := NewDocument("")
+	window.HistoryLimit = checkpointInterval*2 + 7
+	texts := []string{""} // texts[v] is the document at revision v
+	for i := 0; i < 500; i++ {
+		revision := max(0, full.Version-r.Intn(window.HistoryLimit+1))
+		e := Edit{Op: randomLocalOp(r, texts[revision], false), Site: fmt.Sprintf("site%d", r.Intn(3))}
+		want, err := (&JupiterEngine{}).TransformIncoming(Text, e, revision, full.Base, full.History)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := (&JupiterEngine{}).TransformIncoming(Text, e, revision, window.Base, window.History)
+		if err != nil {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+		if !opsEqual(got.(Operation).Ops, want.(Operation).Ops) {
+			t.Fatalf("iteration %d: got %+v, want %+v", i, got, want)
+		}
+		if err := full.ApplyEdit(Edit{Op: want, Site: e.Site}); err != nil {
+			t.Fatal(err)
+		}
+		if err := window.ApplyEdit(Edit{Op: got, Site: e.Site}); err != nil {
+			t.Fatal(err)
+		}
+		if full.Version == len(texts) { // not a no-op
+			texts = append(texts, full.Content)
+		}
+	}
+	if window.Base != full.Version-window.HistoryLimit {
+		t.Errorf("base = %d, want %d", window.Base, full.Version-window.HistoryLimit)
+	}
 }
 
 // TestConvergence simulates multiple clients making concurrent edits
@@ -131,7 +190,7 @@ func TestConvergence(t *testing.T) {
 
 			// Apply operations sequentially, transforming each against history
 			for _, op := range tt.ops {
-				transformed, err := engine.TransformIncoming(op, 0, doc.History)
+				transformed, err := engine.TransformIncoming(Text, Edit{Op: op}, 0, 0, doc.History)
 				if err != nil {
 					t.Fatalf("TransformIncoming error: %v", err)
 				}
@@ -146,3 +205,190 @@ func TestConvergence(t *testing.T) {
 		})
 	}
 }
+
+// transformSequentially transforms e against each edit in history since
+// revision, as JupiterEngine does without checkpoints.
+func transformSequentially(t Type, e Edit, revision int, history []Edit) (Op, error) {
+	op := e.Op
+	for _, h := range history[revision:] {
+		var err error
+		if op, _, err = TransformSide(t, op, h.Op, SiteSide(e.Site, h.Site)); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
+
+// randomLocalOp returns a random operation on doc that changes a few
+// units around one position, as typing does. With format set it may also
+// format or unformat them.
+func randomLocalOp(r *rand.Rand, doc string, format bool) Operation {
+	runes := []rune(doc)
+	i := r.Intn(len(runes) + 1)
+	n := min(len(runes)-i, r.Intn(4))
+	before := UTF16.Len(string(runes[:i]))
+	width := UTF16.Len(string(runes[i : i+n]))
+	after := UTF16.Len(string(runes[i+n:]))
+
+	ops := []Component{{Retain: before}}
+	switch k := r.Intn(4); {
+	case k == 0 && n > 0:
+		ops = append(ops, Component{Delete: width})
+	case k == 1 && n > 0 && format:
+		attrs := Attributes{"bold": true}
+		if r.Intn(2) == 0 {
+			attrs = Attributes{"bold": nil}
+		}
+		ops = append(ops, Component{Retain: width, Attributes: attrs})
+	default:
+		ops = append(ops, Component{Insert: randomText(r, 1+r.Intn(3))}, Component{Retain: width})
+	}
+	ops = append(ops, Component{Retain: after})
+	return Normalize(Operation{Ops: ops})
+}
+
+// TestJupiterEngine_Checkpoints checks that transforming against composed
+// checkpoints gives the same results as transforming against each edit,
+// with the cache cold and warm.
+func TestJupiterEngine_Checkpoints(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sites := []string{"a", "b", "c"}
+	for i := 0; i < 50; i++ {
+		typ := Type(Text)
+		if i%2 == 1 {
+			typ = Rich
+		}
+		// random returns a random edit on text, usually a small one.
+		random := func(text string) Operation {
+			if r.Intn(8) == 0 {
+				return randomOp(r, text)
+			}
+			return randomLocalOp(r, text, typ == Rich)
+		}
+
+		doc, err := NewTypedDocument(typ, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts := []string{""} // plain text at each revision
+		for len(doc.History) < 2*checkpointInterval+r.Intn(checkpointInterval) {
+			e := Edit{Op: random(texts[len(texts)-1]), Site: sites[r.Intn(len(sites))]}
+			if err := doc.ApplyEdit(e); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Version < len(texts) {
+				continue // a no-op, which isn't recorded
+			}
+			text := doc.Content
+			if rt, ok := doc.Snapshot().(RichText); ok {
+				text = rt.Text()
+			}
+			texts = append(texts, text)
+		}
+
+		for j := 0; j < 20; j++ {
+			revision := r.Intn(len(texts))
+			if j%2 == 0 {
+				revision = r.Intn(checkpointInterval)
+			}
+			e := Edit{Op: random(texts[revision]), Site: sites[r.Intn(len(sites))]}
+			want, err := transformSequentially(typ, e, revision, doc.History)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantDoc, err := typ.Apply(doc.Snapshot(), want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for pass := 0; pass < 2; pass++ {
+				got, err := (&JupiterEngine{}).TransformIncoming(typ, e, revision, 0, doc.History)
+				if err != nil {
+					t.Fatalf("iteration %d: %v", i, err)
+				}
+				gotDoc, err := typ.Apply(doc.Snapshot(), got)
+				if err != nil {
+					t.Fatalf("iteration %d: apply: %v", i, err)
+				}
+				if !reflect.DeepEqual(gotDoc, wantDoc) {
+					t.Fatalf("iteration %d, edit %d at revision %d: got %v, want %v", i, j, revision, gotDoc, wantDoc)
+				}
+			}
+		}
+	}
+}
+
+func TestJupiterEngine_CheckpointsOtherTypes(t *testing.T) {
+	doc, _ := NewTypedDocument(JSON, `{"n":0}`)
+	for i := 0; i < checkpointInterval; i++ {
+		if err := doc.Apply(jop(NumberAdd(path("n"), 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	op, err := (&JupiterEngine{}).TransformIncoming(JSON, Edit{Op: jop(NumberAdd(path("n"), 1))}, 0, 0, doc.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`{"n":%d}`, checkpointInterval+1); doc.Content != want {
+		t.Errorf("content = %s, want %s", doc.Content, want)
+	}
+}
+
+// benchmarkHistory returns a document with n edits from a few sites, each
+// typing or deleting characters one at a time at its cursor and sometimes
+// moving the cursor elsewhere, as a busy document would get.
+func benchmarkHistory(b *testing.B, n int) *Document {
+	r := rand.New(rand.NewSource(1))
+	doc := NewDocument("")
+	cursors := make([]int, 5)
+	for i := 0; i < n; i++ {
+		site := r.Intn(len(cursors))
+		size := len(doc.Content)
+		pos := min(cursors[site], size)
+		if r.Intn(20) == 0 {
+			pos = r.Intn(size + 1)
+		}
+		op := NewInsert(pos, "a", size)
+		cursors[site] = pos + 1
+		if pos > 0 && r.Intn(5) == 0 {
+			op = NewDelete(pos-1, 1, size)
+			cursors[site] = pos - 1
+		}
+		if err := doc.ApplyEdit(Edit{Op: op, Site: fmt.Sprintf("site%d", site)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return doc
+}
+
+// BenchmarkTransformIncoming transforms an edit made at revision 0 against
+// a 10,000-edit history, one edit at a time and with checkpoints.
+func BenchmarkTransformIncoming(b *testing.B) {
+	doc := benchmarkHistory(b, 10000)
+	// The edit inserts into the initial, empty document, so it ties with
+	// edits at the start of the text, which are transformed one at a time.
+	e := Edit{Op: NewInsert(0, "x", 0), Site: "z"}
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := transformSequentially(Text, e, 0, doc.History); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("checkpoints", func(b *testing.B) {
+		engine := &JupiterEngine{}
+		// Compose the checkpoints once, as earlier edits would have.
+		if _, err := engine.TransformIncoming(Text, e, 0, 0, doc.History); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := engine.TransformIncoming(Text, e, 0, 0, doc.History); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
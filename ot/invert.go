@@ -0,0 +1,45 @@
+package ot
+
+import "fmt"
+
+// Invert returns the operation that reverts op, given the document op was
+// applied to:
+//
+//	Apply(Apply(doc, op), Invert(op, doc)) == doc
+//
+// Offsets are counted in UTF-16 code units.
+func Invert(op Operation, doc string) (Operation, error) {
+	return UTF16.Invert(op, doc)
+}
+
+// Invert is like the package-level Invert but counts offsets in u. Like
+// Apply, it ignores attributes, so formatting changes aren't reverted; use
+// InvertRich for formatted documents.
+func (u Unit) Invert(op Operation, doc string) (Operation, error) {
+	if n := u.Len(doc); n != op.BaseLen() {
+		return Operation{}, fmt.Errorf("document length %d != operation base length %d", n, op.BaseLen())
+	}
+	var ops []Component
+	pos := 0 // byte offset into doc
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			end, err := u.advance(doc, pos, c.Retain)
+			if err != nil {
+				return Operation{}, fmt.Errorf("retain %d: %w", c.Retain, err)
+			}
+			ops = append(ops, Component{Retain: c.Retain})
+			pos = end
+		case c.IsInsert():
+			ops = append(ops, Component{Delete: u.Len(c.Insert)})
+		case c.IsDelete():
+			end, err := u.advance(doc, pos, c.Delete)
+			if err != nil {
+				return Operation{}, fmt.Errorf("delete %d: %w", c.Delete, err)
+			}
+			ops = append(ops, Component{Insert: doc[pos:end]})
+			pos = end
+		}
+	}
+	return Operation{Ops: compact(ops)}, nil
+}
@@ -0,0 +1,67 @@
+package ot
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestInvert(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		op   Operation
+		want []Component
+	}{
+		{"insert", "hello", NewInsert(2, "XY", 5), []Component{{Retain: 2}, {Delete: 2}, {Retain: 3}}},
+		{"delete", "hello", NewDelete(1, 3, 5), []Component{{Retain: 1}, {Insert: "ell"}, {Retain: 1}}},
+		{"replace", "abc", Operation{[]Component{{Retain: 1}, {Delete: 1}, {Insert: "X"}, {Retain: 1}}},
+			[]Component{{Retain: 1}, {Insert: "b"}, {Delete: 1}, {Retain: 1}}},
+		{"unicode delete", "a😀b", NewDelete(1, 2, 4), []Component{{Retain: 1}, {Insert: "😀"}, {Retain: 1}}},
+		{"noop", "ab", Operation{[]Component{{Retain: 2}}}, []Component{{Retain: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := Invert(tt.op, tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !opsEqual(inv.Ops, tt.want) {
+				t.Errorf("Invert() = %+v, want %+v", inv.Ops, tt.want)
+			}
+			after, _ := Apply(tt.doc, tt.op)
+			got, err := Apply(after, inv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.doc {
+				t.Errorf("Apply(Apply(doc, op), inv) = %q, want %q", got, tt.doc)
+			}
+		})
+	}
+}
+
+func TestInvert_ErrorOnLengthMismatch(t *testing.T) {
+	if _, err := Invert(NewInsert(0, "x", 5), "hi"); err == nil {
+		t.Error("expected error for length mismatch")
+	}
+}
+
+func TestInvert_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := randomText(r, r.Intn(10))
+		op := randomOp(r, doc)
+		inv, err := Invert(op, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, _ := Apply(doc, op)
+		got, err := Apply(after, inv)
+		if err != nil {
+			t.Fatalf("Apply inverse: %v (op=%+v inv=%+v)", err, op.Ops, inv.Ops)
+		}
+		if got != doc {
+			t.Fatalf("got %q, want %q (op=%+v inv=%+v)", got, doc, op.Ops, inv.Ops)
+		}
+	}
+}
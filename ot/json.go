@@ -0,0 +1,594 @@
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPath reports a JSON component whose path holds something other
+// than object keys and list indices, or whose last element doesn't suit its
+// action.
+var ErrInvalidPath = errors.New("invalid path")
+
+// JSONComponent is a single edit to a JSON document, modelled on json0.
+// Path leads from the root to the edited value: strings are object keys and
+// ints are list indices. Exactly one action should be set, except that a
+// list or object insert may be paired with a delete to replace a value.
+//
+// For list and object actions, the last path element is the index or key
+// being edited in its parent. For NumberAdd and Text, the path leads to the
+// number or string itself.
+type JSONComponent struct {
+	Path         []any      `json:"p"`
+	NumberAdd    *float64   `json:"na,omitempty"` // add to a number
+	ListInsert   *any       `json:"li,omitempty"` // insert a list element
+	ListDelete   *any       `json:"ld,omitempty"` // delete a list element, whose value this is
+	ListMove     *int       `json:"lm,omitempty"` // move a list element to this index
+	ObjectInsert *any       `json:"oi,omitempty"` // set an object key
+	ObjectDelete *any       `json:"od,omitempty"` // delete an object key, whose value this is
+	Text         *Operation `json:"t,omitempty"`  // edit a string
+}
+
+// JSONOperation is a sequence of components applied in order.
+type JSONOperation struct {
+	Ops []JSONComponent `json:"ops"`
+}
+
+// NumberAdd returns a component that adds n to the number at path.
+func NumberAdd(path []any, n float64) JSONComponent {
+	return JSONComponent{Path: path, NumberAdd: &n}
+}
+
+// ListInsert returns a component that inserts v into a list at path.
+func ListInsert(path []any, v any) JSONComponent {
+	return JSONComponent{Path: path, ListInsert: &v}
+}
+
+// ListDelete returns a component that deletes the list element old at path.
+func ListDelete(path []any, old any) JSONComponent {
+	return JSONComponent{Path: path, ListDelete: &old}
+}
+
+// ListReplace returns a component that replaces the list element old at
+// path with v.
+func ListReplace(path []any, old, v any) JSONComponent {
+	return JSONComponent{Path: path, ListDelete: &old, ListInsert: &v}
+}
+
+// ListMove returns a component that moves the list element at path to
+// index to.
+func ListMove(path []any, to int) JSONComponent {
+	return JSONComponent{Path: path, ListMove: &to}
+}
+
+// ObjectInsert returns a component that sets the object key at path to v.
+func ObjectInsert(path []any, v any) JSONComponent {
+	return JSONComponent{Path: path, ObjectInsert: &v}
+}
+
+// ObjectDelete returns a component that deletes the object key at path,
+// whose value is old.
+func ObjectDelete(path []any, old any) JSONComponent {
+	return JSONComponent{Path: path, ObjectDelete: &old}
+}
+
+// ObjectReplace returns a component that replaces the value old of the
+// object key at path with v.
+func ObjectReplace(path []any, old, v any) JSONComponent {
+	return JSONComponent{Path: path, ObjectDelete: &old, ObjectInsert: &v}
+}
+
+// TextEdit returns a component that applies a text operation to the string
+// at path.
+func TextEdit(path []any, op Operation) JSONComponent {
+	return JSONComponent{Path: path, Text: &op}
+}
+
+func (c JSONComponent) isList() bool   { return c.ListInsert != nil || c.ListDelete != nil }
+func (c JSONComponent) isObject() bool { return c.ObjectInsert != nil || c.ObjectDelete != nil }
+
+// isLeaf reports whether c edits the value at its path rather than an
+// element of its parent.
+func (c JSONComponent) isLeaf() bool { return c.NumberAdd != nil || c.Text != nil }
+
+// UnmarshalJSON decodes a component, keeping JSON null values of li, ld, oi
+// and od (which encoding/json would drop) and turning integral path
+// elements into ints.
+func (c *JSONComponent) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Path         []any           `json:"p"`
+		NumberAdd    *float64        `json:"na"`
+		ListInsert   json.RawMessage `json:"li"`
+		ListDelete   json.RawMessage `json:"ld"`
+		ListMove     *int            `json:"lm"`
+		ObjectInsert json.RawMessage `json:"oi"`
+		ObjectDelete json.RawMessage `json:"od"`
+		Text         *Operation      `json:"t"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = JSONComponent{Path: raw.Path, NumberAdd: raw.NumberAdd, ListMove: raw.ListMove, Text: raw.Text}
+	for i, p := range c.Path {
+		if f, ok := p.(float64); ok && f == float64(int(f)) {
+			c.Path[i] = int(f)
+		}
+	}
+	for _, v := range []struct {
+		raw json.RawMessage
+		dst **any
+	}{
+		{raw.ListInsert, &c.ListInsert},
+		{raw.ListDelete, &c.ListDelete},
+		{raw.ObjectInsert, &c.ObjectInsert},
+		{raw.ObjectDelete, &c.ObjectDelete},
+	} {
+		if v.raw == nil {
+			continue
+		}
+		var val any
+		if err := json.Unmarshal(v.raw, &val); err != nil {
+			return err
+		}
+		*v.dst = &val
+	}
+	return nil
+}
+
+// ValidateJSON checks that every component of op is well formed: its path
+// holds only keys and non-negative indices, it sets one action (or a list
+// or object replace), list actions end in an index, object actions end in
+// a key or have an empty path, and text edits are valid operations.
+// Components with no action are allowed; they do nothing.
+func ValidateJSON(op JSONOperation) error {
+	for i, c := range op.Ops {
+		if err := validateJSONComponent(c); err != nil {
+			return &ValidationError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+func validateJSONComponent(c JSONComponent) error {
+	for _, p := range c.Path {
+		switch p := p.(type) {
+		case string:
+		case int:
+			if p < 0 {
+				return ErrInvalidPath
+			}
+		default:
+			return ErrInvalidPath
+		}
+	}
+	actions := 0
+	for _, set := range []bool{c.NumberAdd != nil, c.isList(), c.ListMove != nil, c.isObject(), c.Text != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return ErrMultipleActions
+	}
+	var last any
+	if len(c.Path) > 0 {
+		last = c.Path[len(c.Path)-1]
+	}
+	switch {
+	case c.isList() || c.ListMove != nil:
+		if _, ok := last.(int); !ok {
+			return ErrInvalidPath
+		}
+		if c.ListMove != nil && *c.ListMove < 0 {
+			return ErrNegativeLength
+		}
+	case c.isObject():
+		if _, ok := last.(string); !ok && len(c.Path) > 0 {
+			return ErrInvalidPath
+		}
+	case c.Text != nil:
+		return Validate(*c.Text)
+	}
+	return nil
+}
+
+// JSONType is structured JSON data edited with JSONOperation. Snapshots are
+// the values encoding/json produces when decoding into an any; the empty
+// document is an empty object. Text edits count offsets in Unit.
+type JSONType struct {
+	Unit Unit
+}
+
+// JSON is the JSON document type with UTF-16 text offsets.
+var JSON = JSONType{Unit: UTF16}
+
+// Name returns "json", suffixed with the unit if it is not UTF16.
+func (t JSONType) Name() string { return typeName("json", t.Unit) }
+
+func (t JSONType) Create(data string) (any, error) {
+	if data == "" {
+		return map[string]any{}, nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.Name(), err)
+	}
+	return v, nil
+}
+
+func (t JSONType) Serialize(snapshot any) (string, error) {
+	b, err := json.Marshal(snapshot)
+	return string(b), err
+}
+
+// DecodeOp parses and validates a JSONOperation, merging adjacent
+// components where possible and dropping ones that do nothing.
+func (t JSONType) DecodeOp(data []byte) (Op, error) {
+	var op JSONOperation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, err
+	}
+	if err := ValidateJSON(op); err != nil {
+		return nil, err
+	}
+	var out []JSONComponent
+	for _, c := range op.Ops {
+		if c.Text != nil {
+			norm := Normalize(*c.Text)
+			c.Text = &norm
+		}
+		var err error
+		if out, err = t.append(out, c); err != nil {
+			return nil, err
+		}
+	}
+	return JSONOperation{Ops: out}, nil
+}
+
+func (t JSONType) Apply(snapshot any, op Op) (any, error) {
+	o, err := asJSONOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range o.Ops {
+		if snapshot, err = t.applyComponent(snapshot, c); err != nil {
+			return nil, fmt.Errorf("component %d: %w", i, err)
+		}
+	}
+	return snapshot, nil
+}
+
+// applyComponent applies c to root without modifying it: containers along
+// c's path are copied, and everything else is shared.
+func (t JSONType) applyComponent(root any, c JSONComponent) (any, error) {
+	if c.isLeaf() || c.isList() || c.ListMove != nil || c.isObject() {
+		// Wrap the root so that a component with an empty path can
+		// replace it like any other object value.
+		wrapped, err := t.applyAt(map[string]any{"data": root}, append([]any{"data"}, c.Path...), c)
+		if err != nil {
+			return nil, err
+		}
+		return wrapped.(map[string]any)["data"], nil
+	}
+	return root, nil
+}
+
+func (t JSONType) applyAt(node any, path []any, c JSONComponent) (any, error) {
+	if len(path) == 0 {
+		return t.applyLeaf(node, c)
+	}
+	if len(path) == 1 && !c.isLeaf() {
+		return applyElement(node, path[0], c)
+	}
+	child, err := lookup(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = t.applyAt(child, path[1:], c); err != nil {
+		return nil, err
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		m := cloneMap(n)
+		m[path[0].(string)] = child
+		return m, nil
+	default:
+		l := append([]any(nil), node.([]any)...)
+		l[path[0].(int)] = child
+		return l, nil
+	}
+}
+
+// applyLeaf applies a number add or text edit to the value it targets.
+func (t JSONType) applyLeaf(node any, c JSONComponent) (any, error) {
+	if c.NumberAdd != nil {
+		switch n := node.(type) {
+		case float64:
+			return n + *c.NumberAdd, nil
+		case int:
+			return float64(n) + *c.NumberAdd, nil
+		}
+		return nil, fmt.Errorf("number add on %T", node)
+	}
+	s, ok := node.(string)
+	if !ok {
+		return nil, fmt.Errorf("text edit on %T", node)
+	}
+	return t.Unit.Apply(s, *c.Text)
+}
+
+// applyElement applies a list or object action to the element key of node.
+func applyElement(node, key any, c JSONComponent) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		k, ok := key.(string)
+		if !ok || !c.isObject() {
+			break
+		}
+		m := cloneMap(n)
+		if c.ObjectInsert != nil {
+			m[k] = *c.ObjectInsert
+		} else if _, ok := m[k]; !ok {
+			return nil, fmt.Errorf("delete of missing key %q", k)
+		} else {
+			delete(m, k)
+		}
+		return m, nil
+	case []any:
+		i, ok := key.(int)
+		if !ok || !(c.isList() || c.ListMove != nil) {
+			break
+		}
+		size := len(n)
+		if c.ListInsert == nil || c.ListDelete != nil {
+			// Replacing, deleting and moving need an existing element.
+			size--
+		}
+		if i > size || (c.ListMove != nil && *c.ListMove > size) {
+			return nil, fmt.Errorf("list index %d out of range", i)
+		}
+		switch {
+		case c.ListMove != nil:
+			return insertAt(removeAt(n, i), *c.ListMove, n[i]), nil
+		case c.ListInsert != nil && c.ListDelete != nil:
+			l := append([]any(nil), n...)
+			l[i] = *c.ListInsert
+			return l, nil
+		case c.ListInsert != nil:
+			return insertAt(n, i, *c.ListInsert), nil
+		default:
+			return removeAt(n, i), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot apply %s at %v in %T", c.action(), key, node)
+}
+
+func (c JSONComponent) action() string {
+	switch {
+	case c.NumberAdd != nil:
+		return "number add"
+	case c.isList():
+		return "list insert/delete"
+	case c.ListMove != nil:
+		return "list move"
+	case c.isObject():
+		return "object insert/delete"
+	case c.Text != nil:
+		return "text edit"
+	}
+	return "no-op"
+}
+
+// lookup returns the element key of a container.
+func lookup(node, key any) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		if k, ok := key.(string); ok {
+			if v, ok := n[k]; ok {
+				return v, nil
+			}
+		}
+	case []any:
+		if i, ok := key.(int); ok && i < len(n) {
+			return n[i], nil
+		}
+	}
+	return nil, fmt.Errorf("path element %v not found in %T", key, node)
+}
+
+// get returns the value at path in root.
+func get(root any, path []any) (any, error) {
+	for _, p := range path {
+		var err error
+		if root, err = lookup(root, p); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	c := make(map[string]any, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func removeAt(l []any, i int) []any {
+	out := make([]any, 0, len(l)-1)
+	out = append(out, l[:i]...)
+	return append(out, l[i+1:]...)
+}
+
+func insertAt(l []any, i int, v any) []any {
+	out := make([]any, 0, len(l)+1)
+	out = append(out, l[:i]...)
+	out = append(out, v)
+	return append(out, l[i:]...)
+}
+
+func (t JSONType) Transform(a, b Op) (Op, Op, error) {
+	oa, err := asJSONOperation(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	ob, err := asJSONOperation(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	ap, bp, err := t.transformX(oa.Ops, ob.Ops)
+	if err != nil {
+		return nil, nil, err
+	}
+	return JSONOperation{Ops: ap}, JSONOperation{Ops: bp}, nil
+}
+
+// Compose appends b's components to a's, merging them where possible.
+func (t JSONType) Compose(a, b Op) (Op, error) {
+	oa, err := asJSONOperation(a)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := asJSONOperation(b)
+	if err != nil {
+		return nil, err
+	}
+	ops := append([]JSONComponent(nil), oa.Ops...)
+	for _, c := range ob.Ops {
+		if ops, err = t.append(ops, c); err != nil {
+			return nil, err
+		}
+	}
+	return JSONOperation{Ops: ops}, nil
+}
+
+func (t JSONType) IsNoop(op Op) bool {
+	o, err := asJSONOperation(op)
+	if err != nil {
+		return false
+	}
+	for _, c := range o.Ops {
+		if !c.isNoop() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c JSONComponent) isNoop() bool {
+	switch {
+	case c.NumberAdd != nil:
+		return *c.NumberAdd == 0
+	case c.ListMove != nil:
+		return c.Path[len(c.Path)-1] == *c.ListMove
+	case c.Text != nil:
+		return c.Text.IsNoop()
+	}
+	return !c.isList() && !c.isObject()
+}
+
+// Invert returns the operation that reverts op on snapshot. Deleted values
+// are read from the snapshot rather than trusted from op.
+func (t JSONType) Invert(op Op, snapshot any) (Op, error) {
+	o, err := asJSONOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	inv := make([]JSONComponent, len(o.Ops))
+	for i, c := range o.Ops {
+		ic := JSONComponent{Path: c.Path}
+		switch {
+		case c.NumberAdd != nil:
+			ic = NumberAdd(c.Path, -*c.NumberAdd)
+		case c.ListMove != nil:
+			n := len(c.Path) - 1
+			ic = ListMove(append(append([]any(nil), c.Path[:n]...), *c.ListMove), c.Path[n].(int))
+		case c.Text != nil:
+			s, err := get(snapshot, c.Path)
+			if err != nil {
+				return nil, err
+			}
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("text edit on %T", s)
+			}
+			textInv, err := t.Unit.Invert(*c.Text, str)
+			if err != nil {
+				return nil, err
+			}
+			ic.Text = &textInv
+		case c.isList() || c.isObject():
+			var old *any
+			if c.ListDelete != nil || c.ObjectDelete != nil {
+				v, err := get(snapshot, c.Path)
+				if err != nil {
+					return nil, err
+				}
+				old = &v
+			}
+			if c.isList() {
+				ic.ListInsert, ic.ListDelete = old, c.ListInsert
+			} else {
+				ic.ObjectInsert, ic.ObjectDelete = old, c.ObjectInsert
+			}
+		}
+		inv[len(o.Ops)-1-i] = ic
+		if snapshot, err = t.applyComponent(snapshot, c); err != nil {
+			return nil, fmt.Errorf("component %d: %w", i, err)
+		}
+	}
+	return JSONOperation{Ops: inv}, nil
+}
+
+// append adds c to the end of ops, merging it into the last component when
+// both act on the same path: number adds are summed and text edits are
+// composed. Components that do nothing are dropped.
+func (t JSONType) append(ops []JSONComponent, c JSONComponent) ([]JSONComponent, error) {
+	if c.isNoop() {
+		return ops, nil
+	}
+	if n := len(ops); n > 0 && pathEqual(ops[n-1].Path, c.Path) {
+		last := ops[n-1]
+		switch {
+		case last.NumberAdd != nil && c.NumberAdd != nil:
+			ops[n-1] = NumberAdd(last.Path, *last.NumberAdd+*c.NumberAdd)
+			if ops[n-1].isNoop() {
+				ops = ops[:n-1]
+			}
+			return ops, nil
+		case last.Text != nil && c.Text != nil:
+			composed, err := t.Unit.Compose(*last.Text, *c.Text)
+			if err != nil {
+				return nil, err
+			}
+			ops[n-1] = TextEdit(last.Path, composed)
+			if ops[n-1].isNoop() {
+				ops = ops[:n-1]
+			}
+			return ops, nil
+		}
+	}
+	return append(ops, c), nil
+}
+
+func pathEqual(a, b []any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func asJSONOperation(op Op) (JSONOperation, error) {
+	o, ok := op.(JSONOperation)
+	if !ok {
+		return JSONOperation{}, fmt.Errorf("operation is %T, not ot.JSONOperation", op)
+	}
+	return o, nil
+}
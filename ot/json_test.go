@@ -0,0 +1,494 @@
+package ot
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func jop(cs ...JSONComponent) JSONOperation { return JSONOperation{Ops: cs} }
+
+func path(p ...any) []any { return p }
+
+// applyJSON applies op to the JSON document doc and returns the result.
+func applyJSON(doc string, op JSONOperation) (string, error) {
+	snap, err := JSON.Create(doc)
+	if err != nil {
+		return "", err
+	}
+	if snap, err = JSON.Apply(snap, op); err != nil {
+		return "", err
+	}
+	return JSON.Serialize(snap)
+}
+
+// verifyJSONTransform checks the OT invariant for JSON operations and
+// returns the converged document.
+func verifyJSONTransform(t *testing.T, doc string, a, b JSONOperation) string {
+	t.Helper()
+
+	aPrime, bPrime, err := JSON.Transform(a, b)
+	if err != nil {
+		t.Fatalf("Transform error: %v", err)
+	}
+
+	afterA, err := applyJSON(doc, a)
+	if err != nil {
+		t.Fatalf("Apply(doc, a) error: %v", err)
+	}
+	path1, err := applyJSON(afterA, bPrime.(JSONOperation))
+	if err != nil {
+		t.Fatalf("Apply(afterA, bPrime) error: %v\nafterA=%s, bPrime=%s", err, afterA, dumpJSONOp(bPrime))
+	}
+
+	afterB, err := applyJSON(doc, b)
+	if err != nil {
+		t.Fatalf("Apply(doc, b) error: %v", err)
+	}
+	path2, err := applyJSON(afterB, aPrime.(JSONOperation))
+	if err != nil {
+		t.Fatalf("Apply(afterB, aPrime) error: %v\nafterB=%s, aPrime=%s", err, afterB, dumpJSONOp(aPrime))
+	}
+
+	if path1 != path2 {
+		t.Errorf("convergence failed:\n  doc=%s\n  a=%s → %s\n  b=%s → %s\n  path1(a,bP)=%s\n  path2(b,aP)=%s\n  aPrime=%s\n  bPrime=%s",
+			doc, dumpJSONOp(a), afterA, dumpJSONOp(b), afterB, path1, path2, dumpJSONOp(aPrime), dumpJSONOp(bPrime))
+	}
+	return path1
+}
+
+func dumpJSONOp(op Op) string {
+	b, _ := JSON.Serialize(op)
+	return b
+}
+
+func TestJSONApply(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		op   JSONOperation
+		want string // empty if Apply should fail
+	}{
+		{"number add", `{"n":1}`, jop(NumberAdd(path("n"), 2.5)), `{"n":3.5}`},
+		{"list insert", `[1,2]`, jop(ListInsert(path(1), "x")), `[1,"x",2]`},
+		{"list insert at end", `[1,2]`, jop(ListInsert(path(2), "x")), `[1,2,"x"]`},
+		{"list insert null", `[]`, jop(ListInsert(path(0), nil)), `[null]`},
+		{"list delete", `[1,2,3]`, jop(ListDelete(path(1), 2.0)), `[1,3]`},
+		{"list replace", `[1,2,3]`, jop(ListReplace(path(1), 2.0, "x")), `[1,"x",3]`},
+		{"list move forward", `[1,2,3]`, jop(ListMove(path(0), 2)), `[2,3,1]`},
+		{"list move back", `[1,2,3]`, jop(ListMove(path(2), 0)), `[3,1,2]`},
+		{"object insert", `{}`, jop(ObjectInsert(path("a"), map[string]any{"b": true})), `{"a":{"b":true}}`},
+		{"object delete", `{"a":1,"b":2}`, jop(ObjectDelete(path("a"), 1.0)), `{"b":2}`},
+		{"object replace", `{"a":1}`, jop(ObjectReplace(path("a"), 1.0, "x")), `{"a":"x"}`},
+		{"text edit", `{"s":"hello"}`, jop(TextEdit(path("s"), NewInsert(5, "!", 5))), `{"s":"hello!"}`},
+		{"nested", `{"cols":[{"cards":["a"]}]}`, jop(ListInsert(path("cols", 0, "cards", 1), "b")), `{"cols":[{"cards":["a","b"]}]}`},
+		{"replace root", `{"a":1}`, jop(ObjectReplace(path(), map[string]any{"a": 1.0}, []any{1.0})), `[1]`},
+		{"several components", `{"l":[]}`, jop(
+			ListInsert(path("l", 0), "a"),
+			ListInsert(path("l", 1), "b"),
+			ListMove(path("l", 1), 0),
+		), `{"l":["b","a"]}`},
+
+		{"delete missing key", `{}`, jop(ObjectDelete(path("a"), 1.0)), ""},
+		{"missing path", `{}`, jop(NumberAdd(path("a", "b"), 1)), ""},
+		{"insert out of range", `[1]`, jop(ListInsert(path(2), 1)), ""},
+		{"delete out of range", `[1]`, jop(ListDelete(path(1), 1)), ""},
+		{"move out of range", `[1,2]`, jop(ListMove(path(0), 2)), ""},
+		{"add to string", `{"s":"x"}`, jop(NumberAdd(path("s"), 1)), ""},
+		{"list action on object", `{"a":1}`, jop(ListInsert(path(0), 1)), ""},
+		{"object action on list", `[1]`, jop(ObjectInsert(path("a"), 1)), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSON(tt.doc, tt.op)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONApply_DoesNotModifySnapshot(t *testing.T) {
+	snap, _ := JSON.Create(`{"l":[{"n":1}]}`)
+	if _, err := JSON.Apply(snap, jop(NumberAdd(path("l", 0, "n"), 1), ListInsert(path("l", 0), 0))); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := JSON.Serialize(snap); got != `{"l":[{"n":1}]}` {
+		t.Errorf("snapshot modified: %s", got)
+	}
+}
+
+func TestJSONTransform_Object(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b JSONOperation
+		want string
+	}{
+		{"insert different keys", `{}`,
+			jop(ObjectInsert(path("x"), 1)), jop(ObjectInsert(path("y"), 2)), `{"x":1,"y":2}`},
+		{"insert same key (a wins)", `{}`,
+			jop(ObjectInsert(path("x"), 1)), jop(ObjectInsert(path("x"), 2)), `{"x":1}`},
+		{"replace same key (a wins)", `{"x":0}`,
+			jop(ObjectReplace(path("x"), 0.0, 1)), jop(ObjectReplace(path("x"), 0.0, 2)), `{"x":1}`},
+		{"delete same key", `{"x":0}`,
+			jop(ObjectDelete(path("x"), 0.0)), jop(ObjectDelete(path("x"), 0.0)), `{}`},
+		{"delete vs replace", `{"x":0}`,
+			jop(ObjectDelete(path("x"), 0.0)), jop(ObjectReplace(path("x"), 0.0, 2)), `{"x":2}`},
+		{"delete parent vs edit inside", `{"o":{"n":1},"k":1}`,
+			jop(ObjectDelete(path("o"), map[string]any{"n": 1.0})), jop(NumberAdd(path("o", "n"), 1)), `{"k":1}`},
+		{"replace parent vs edit inside", `{"o":{"n":1}}`,
+			jop(NumberAdd(path("o", "n"), 1)), jop(ObjectReplace(path("o"), map[string]any{"n": 1.0}, "x")), `{"o":"x"}`},
+		{"number adds", `{"n":1}`,
+			jop(NumberAdd(path("n"), 2)), jop(NumberAdd(path("n"), 3)), `{"n":6}`},
+		{"replace root vs edit", `{"n":1}`,
+			jop(ObjectReplace(path(), map[string]any{"n": 1.0}, []any{})), jop(NumberAdd(path("n"), 1)), `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyJSONTransform(t, tt.doc, tt.a, tt.b); got != tt.want {
+				t.Errorf("converged to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONTransform_List(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b JSONOperation
+		want string
+	}{
+		{"insert different positions", `[1,2,3]`,
+			jop(ListInsert(path(0), "a")), jop(ListInsert(path(3), "b")), `["a",1,2,3,"b"]`},
+		{"insert same position (a wins)", `[1,2,3]`,
+			jop(ListInsert(path(1), "a")), jop(ListInsert(path(1), "b")), `[1,"a","b",2,3]`},
+		{"insert vs delete before", `[1,2,3]`,
+			jop(ListInsert(path(2), "x")), jop(ListDelete(path(0), 1.0)), `[2,"x",3]`},
+		{"insert vs delete at same index", `[1,2,3]`,
+			jop(ListInsert(path(1), "x")), jop(ListDelete(path(1), 2.0)), `[1,"x",3]`},
+		{"delete same element", `[1,2,3]`,
+			jop(ListDelete(path(1), 2.0)), jop(ListDelete(path(1), 2.0)), `[1,3]`},
+		{"replace vs delete", `[1,2,3]`,
+			jop(ListDelete(path(1), 2.0)), jop(ListReplace(path(1), 2.0, "x")), `[1,"x",3]`},
+		{"replace vs replace (a wins)", `[1,2,3]`,
+			jop(ListReplace(path(1), 2.0, "a")), jop(ListReplace(path(1), 2.0, "b")), `[1,"a",3]`},
+		{"delete vs edit inside", `[{"n":1},2]`,
+			jop(ListDelete(path(0), map[string]any{"n": 1.0})), jop(NumberAdd(path(0, "n"), 1)), `[2]`},
+		{"insert shifts edit inside", `[{"n":1}]`,
+			jop(ListInsert(path(0), "x")), jop(NumberAdd(path(0, "n"), 5)), `["x",{"n":6}]`},
+		{"delete shifts edit inside", `[0,{"n":1}]`,
+			jop(ListDelete(path(0), 0.0)), jop(NumberAdd(path(1, "n"), 5)), `[{"n":6}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyJSONTransform(t, tt.doc, tt.a, tt.b); got != tt.want {
+				t.Errorf("converged to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONTransform_Move(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b JSONOperation
+		want string
+	}{
+		{"move vs insert", `[1,2,3]`,
+			jop(ListMove(path(0), 2)), jop(ListInsert(path(1), "x")), `["x",2,3,1]`},
+		{"move vs insert at destination", `[1,2,3]`,
+			jop(ListMove(path(2), 0)), jop(ListInsert(path(0), "x")), `["x",3,1,2]`},
+		{"move vs delete of moved element", `[1,2,3]`,
+			jop(ListMove(path(0), 2)), jop(ListDelete(path(0), 1.0)), `[2,3]`},
+		{"move vs delete of other element", `[1,2,3]`,
+			jop(ListMove(path(0), 2)), jop(ListDelete(path(1), 2.0)), `[3,1]`},
+		{"move vs edit of moved element", `[{"n":1},2,3]`,
+			jop(ListMove(path(0), 2)), jop(NumberAdd(path(0, "n"), 1)), `[2,3,{"n":2}]`},
+		{"move same element (a wins)", `[1,2,3]`,
+			jop(ListMove(path(0), 2)), jop(ListMove(path(0), 1)), `[2,3,1]`},
+		{"move different elements", `[1,2,3,4]`,
+			jop(ListMove(path(0), 3)), jop(ListMove(path(3), 0)), `[4,2,3,1]`},
+		{"move both forward", `[1,2,3,4]`,
+			jop(ListMove(path(0), 2)), jop(ListMove(path(1), 3)), `[3,1,4,2]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyJSONTransform(t, tt.doc, tt.a, tt.b); got != tt.want {
+				t.Errorf("converged to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONTransform_Text(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b JSONOperation
+		want string
+	}{
+		{"edits at different positions", `{"s":"hello"}`,
+			jop(TextEdit(path("s"), NewInsert(0, "A", 5))), jop(TextEdit(path("s"), NewInsert(5, "B", 5))), `{"s":"AhelloB"}`},
+		{"edits at same position (a wins)", `{"s":"hello"}`,
+			jop(TextEdit(path("s"), NewInsert(2, "A", 5))), jop(TextEdit(path("s"), NewInsert(2, "B", 5))), `{"s":"heABllo"}`},
+		{"edit vs replace", `{"s":"hello"}`,
+			jop(TextEdit(path("s"), NewDelete(0, 1, 5))), jop(ObjectReplace(path("s"), "hello", "bye")), `{"s":"bye"}`},
+		{"edit in shifted list element", `["ab"]`,
+			jop(ListInsert(path(0), "x")), jop(TextEdit(path(0), NewInsert(2, "c", 2))), `["x","abc"]`},
+		{"edits on different strings", `{"a":"x","b":"y"}`,
+			jop(TextEdit(path("a"), NewInsert(1, "1", 1))), jop(TextEdit(path("b"), NewInsert(1, "2", 1))), `{"a":"x1","b":"y2"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyJSONTransform(t, tt.doc, tt.a, tt.b); got != tt.want {
+				t.Errorf("converged to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONTransform_MultipleComponents(t *testing.T) {
+	doc := `{"todo":["a","b"],"done":[]}`
+	// a moves "a" to done; b inserts at the front of todo and edits "a".
+	a := jop(ListDelete(path("todo", 0), "a"), ListInsert(path("done", 0), "a"))
+	b := jop(ListInsert(path("todo", 0), "c"), TextEdit(path("todo", 1), NewInsert(1, "!", 1)))
+	if got, want := verifyJSONTransform(t, doc, a, b), `{"done":["a"],"todo":["c","b"]}`; got != want {
+		t.Errorf("converged to %s, want %s", got, want)
+	}
+}
+
+// randomJSONComponent returns a random component that applies to doc, which
+// has the shape {"l": [...], "o": {...}, "n": number, "s": string}.
+func randomJSONComponent(r *rand.Rand, doc map[string]any) JSONComponent {
+	list := doc["l"].([]any)
+	obj := doc["o"].(map[string]any)
+	keys := []string{"a", "b", "c"}
+	for {
+		switch r.Intn(8) {
+		case 0:
+			return ListInsert(path("l", r.Intn(len(list)+1)), randomText(r, 2))
+		case 1:
+			if len(list) > 0 {
+				i := r.Intn(len(list))
+				return ListDelete(path("l", i), list[i])
+			}
+		case 2:
+			if len(list) > 0 {
+				i := r.Intn(len(list))
+				return ListReplace(path("l", i), list[i], randomText(r, 2))
+			}
+		case 3:
+			if len(list) > 0 {
+				i := r.Intn(len(list))
+				s := list[i].(string)
+				return TextEdit(path("l", i), randomOp(r, s))
+			}
+		case 4:
+			k := keys[r.Intn(len(keys))]
+			if old, ok := obj[k]; ok {
+				if r.Intn(2) == 0 {
+					return ObjectDelete(path("o", k), old)
+				}
+				return ObjectReplace(path("o", k), old, float64(r.Intn(10)))
+			}
+			return ObjectInsert(path("o", k), float64(r.Intn(10)))
+		case 5:
+			return NumberAdd(path("n"), float64(r.Intn(5)))
+		case 6:
+			return TextEdit(path("s"), randomOp(r, doc["s"].(string)))
+		case 7:
+			if len(list) > 0 {
+				return ListMove(path("l", r.Intn(len(list))), r.Intn(len(list)))
+			}
+		}
+	}
+}
+
+// randomJSONOp returns an operation of one to three random components that
+// applies to doc.
+func randomJSONOp(t *testing.T, r *rand.Rand, doc any) JSONOperation {
+	var op JSONOperation
+	for n := 1 + r.Intn(3); n > 0; n-- {
+		c := randomJSONComponent(r, doc.(map[string]any))
+		op.Ops = append(op.Ops, c)
+		var err error
+		if doc, err = JSON.Apply(doc, jop(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return op
+}
+
+func TestJSONTransform_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		doc := fmt.Sprintf(`{"l":[%q,%q,%q],"o":{"a":1},"n":0,"s":%q}`,
+			randomText(r, 3), randomText(r, 3), randomText(r, 3), randomText(r, 8))
+		snap, _ := JSON.Create(doc)
+		a := randomJSONOp(t, r, snap)
+		b := randomJSONOp(t, r, snap)
+		verifyJSONTransform(t, doc, a, b)
+		if t.Failed() {
+			t.Fatalf("iteration %d", i)
+		}
+	}
+}
+
+func TestJSONCompose(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 500; i++ {
+		doc := fmt.Sprintf(`{"l":[%q],"o":{},"n":0,"s":%q}`, randomText(r, 3), randomText(r, 5))
+		snap, _ := JSON.Create(doc)
+		a := randomJSONOp(t, r, snap)
+		mid, _ := JSON.Apply(snap, a)
+		b := randomJSONOp(t, r, mid)
+
+		ab, err := JSON.Compose(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		afterA, _ := applyJSON(doc, a)
+		sequential, _ := applyJSON(afterA, b)
+		composed, err := applyJSON(doc, ab.(JSONOperation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sequential != composed {
+			t.Fatalf("compose mismatch:\n  doc=%s\n  a=%s\n  b=%s\n  sequential=%s\n  composed=%s",
+				doc, dumpJSONOp(a), dumpJSONOp(b), sequential, composed)
+		}
+	}
+}
+
+func TestJSONCompose_Merges(t *testing.T) {
+	ab, err := JSON.Compose(
+		jop(NumberAdd(path("n"), 1), TextEdit(path("s"), NewInsert(0, "a", 0))),
+		jop(TextEdit(path("s"), NewInsert(1, "b", 1))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dumpJSONOp(ab), `{"ops":[{"p":["n"],"na":1},{"p":["s"],"t":{"ops":[{"insert":"ab"}]}}]}`; got != want {
+		t.Errorf("Compose() = %s, want %s", got, want)
+	}
+}
+
+func TestJSONInvert(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 500; i++ {
+		doc := fmt.Sprintf(`{"l":[%q,%q],"o":{"a":1},"n":0,"s":%q}`, randomText(r, 3), randomText(r, 3), randomText(r, 5))
+		snap, _ := JSON.Create(doc)
+		op := randomJSONOp(t, r, snap)
+		inv, err := JSON.Invert(op, snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, _ := applyJSON(doc, op)
+		got, err := applyJSON(after, inv.(JSONOperation))
+		if err != nil {
+			t.Fatalf("Apply(after, inv) error: %v\nop=%s\ninv=%s", err, dumpJSONOp(op), dumpJSONOp(inv))
+		}
+		want, _ := applyJSON(doc, jop())
+		if got != want {
+			t.Fatalf("Apply(Apply(doc, op), inv) = %s, want %s\nop=%s\ninv=%s", got, want, dumpJSONOp(op), dumpJSONOp(inv))
+		}
+	}
+}
+
+func TestJSONInvert_ReadsDeletedValues(t *testing.T) {
+	snap, _ := JSON.Create(`{"a":"real"}`)
+	inv, err := JSON.Invert(jop(ObjectDelete(path("a"), "stale")), snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dumpJSONOp(inv), `{"ops":[{"p":["a"],"oi":"real"}]}`; got != want {
+		t.Errorf("Invert() = %s, want %s", got, want)
+	}
+}
+
+func TestJSONDecodeOp(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string // normalized operation
+		wantErr error
+	}{
+		{"valid", `{"ops":[{"p":["l",0],"li":{"x":1}}]}`, `{"ops":[{"p":["l",0],"li":{"x":1}}]}`, nil},
+		{"null insert", `{"ops":[{"p":["a"],"oi":null}]}`, `{"ops":[{"p":["a"],"oi":null}]}`, nil},
+		{"replace", `{"ops":[{"p":[0],"ld":1,"li":2}]}`, `{"ops":[{"p":[0],"li":2,"ld":1}]}`, nil},
+		{"merges adds", `{"ops":[{"p":["n"],"na":1},{"p":["n"],"na":2}]}`, `{"ops":[{"p":["n"],"na":3}]}`, nil},
+		{"drops no-ops", `{"ops":[{"p":["n"],"na":0},{"p":["l",1],"lm":1},{"p":[]}]}`, `{"ops":null}`, nil},
+		{"normalizes text", `{"ops":[{"p":["s"],"t":{"ops":[{"retain":1},{"retain":1}]}}]}`, `{"ops":null}`, nil},
+		{"multiple actions", `{"ops":[{"p":["n"],"na":1,"oi":2}]}`, "", ErrMultipleActions},
+		{"list and object", `{"ops":[{"p":[0],"li":1,"od":2}]}`, "", ErrMultipleActions},
+		{"fractional index", `{"ops":[{"p":[1.5],"li":1}]}`, "", ErrInvalidPath},
+		{"negative index", `{"ops":[{"p":["l",-1],"li":1}]}`, "", ErrInvalidPath},
+		{"list action with key", `{"ops":[{"p":["a"],"li":1}]}`, "", ErrInvalidPath},
+		{"object action with index", `{"ops":[{"p":[0],"oi":1}]}`, "", ErrInvalidPath},
+		{"path element type", `{"ops":[{"p":[true],"na":1}]}`, "", ErrInvalidPath},
+		{"negative move", `{"ops":[{"p":[0],"lm":-1}]}`, "", ErrNegativeLength},
+		{"invalid text", `{"ops":[{"p":["s"],"t":{"ops":[{"retain":-1}]}}]}`, "", ErrNegativeLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := JSON.DecodeOp([]byte(tt.data))
+			if tt.wantErr != nil {
+				var verr *ValidationError
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &verr) {
+					t.Errorf("DecodeOp() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dumpJSONOp(op); got != tt.want {
+				t.Errorf("DecodeOp() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONType_Document(t *testing.T) {
+	doc, err := NewTypedDocument(JSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "{}" {
+		t.Errorf("empty content = %s, want {}", doc.Content)
+	}
+	engine := &JupiterEngine{}
+	ops := []JSONOperation{
+		jop(ObjectInsert(path("cards"), []any{})),
+		jop(ListInsert(path("cards", 0), "first")),
+	}
+	for _, op := range ops {
+		if err := doc.Apply(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A concurrent insert made at revision 1 wins the tie with "first".
+	op, err := engine.TransformIncoming(JSON, Edit{Op: jop(ListInsert(path("cards", 0), "second"))}, 1, 0, doc.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"cards":["second","first"]}`; doc.Content != want {
+		t.Errorf("content = %s, want %s", doc.Content, want)
+	}
+}
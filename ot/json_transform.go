@@ -0,0 +1,328 @@
+package ot
+
+import "fmt"
+
+// transformX transforms two concurrent JSON operations against each other,
+// giving left priority on ties. It follows json0: each component of right
+// is transformed through every component of left in turn, and a component
+// that splits in two is handled by recursing on the rest of left.
+func (t JSONType) transformX(left, right []JSONComponent) ([]JSONComponent, []JSONComponent, error) {
+	var newRight []JSONComponent
+	for _, rc := range right {
+		var newLeft []JSONComponent
+		consumed := false
+		for k, lc := range left {
+			var err error
+			if newLeft, err = t.transformComponent(newLeft, lc, rc, true); err != nil {
+				return nil, nil, err
+			}
+			next, err := t.transformComponent(nil, rc, lc, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(next) == 1 {
+				rc = next[0]
+				continue
+			}
+			rest := left[k+1:]
+			if len(next) > 1 {
+				l, r, err := t.transformX(rest, next)
+				if err != nil {
+					return nil, nil, err
+				}
+				rest = l
+				newRight = append(newRight, r...)
+			}
+			for _, c := range rest {
+				if newLeft, err = t.append(newLeft, c); err != nil {
+					return nil, nil, err
+				}
+			}
+			consumed = true
+			break
+		}
+		if !consumed {
+			var err error
+			if newRight, err = t.append(newRight, rc); err != nil {
+				return nil, nil, err
+			}
+		}
+		left = newLeft
+	}
+	return left, newRight, nil
+}
+
+// transformComponent transforms c so that it applies after other, and
+// appends the result (if any) to dest. left reports whether c wins ties.
+func (t JSONType) transformComponent(dest []JSONComponent, c, other JSONComponent, left bool) ([]JSONComponent, error) {
+	c.Path = append([]any(nil), c.Path...)
+	cLen, oLen := operandLen(c), operandLen(other)
+
+	// If c deletes a value that other edits inside, keep c's record of the
+	// deleted value up to date. Apply doesn't rely on it, so a stale value
+	// is left alone.
+	if common, ok := commonLength(c, other); ok && oLen > cLen && at(c.Path, common) == at(other.Path, common) {
+		inner := other
+		inner.Path = other.Path[cLen:]
+		if c.ListDelete != nil {
+			if v, err := t.applyComponent(*c.ListDelete, inner); err == nil {
+				c.ListDelete = &v
+			}
+		} else if c.ObjectDelete != nil {
+			if v, err := t.applyComponent(*c.ObjectDelete, inner); err == nil {
+				c.ObjectDelete = &v
+			}
+		}
+	}
+
+	common, ok := commonLength(other, c)
+	if !ok {
+		// other edits somewhere that doesn't contain c.
+		return t.append(dest, c)
+	}
+	sameOperand := cLen == oLen
+	sameKey := at(c.Path, common) == at(other.Path, common)
+
+	switch {
+	case other.Text != nil:
+		if c.Text != nil {
+			var text Operation
+			var err error
+			if left {
+				text, _, err = t.Unit.Transform(*c.Text, *other.Text)
+			} else {
+				_, text, err = t.Unit.Transform(*other.Text, *c.Text)
+			}
+			if err != nil {
+				return nil, err
+			}
+			c.Text = &text
+		}
+
+	case other.NumberAdd != nil:
+		// Number adds commute with everything.
+
+	case other.ListInsert != nil && other.ListDelete != nil:
+		if sameKey {
+			if !sameOperand {
+				// c edits inside the replaced element.
+				return dest, nil
+			}
+			if c.ListDelete != nil {
+				if c.ListInsert == nil || !left {
+					return dest, nil
+				}
+				// Both replace the element; c wins and replaces other's.
+				c.ListDelete = other.ListInsert
+			}
+		}
+
+	case other.ListInsert != nil:
+		oi, ci, err := listIndices(other, c, common)
+		if err != nil {
+			return nil, err
+		}
+		if c.ListInsert != nil && c.ListDelete == nil && sameOperand && oi == ci {
+			if !left {
+				c.Path[common] = ci + 1
+			}
+		} else if oi <= ci {
+			c.Path[common] = ci + 1
+		}
+		if c.ListMove != nil && sameOperand && oi <= *c.ListMove {
+			c.ListMove = intPtr(*c.ListMove + 1)
+		}
+
+	case other.ListDelete != nil:
+		oi, ci, err := listIndices(other, c, common)
+		if err != nil {
+			return nil, err
+		}
+		if c.ListMove != nil && sameOperand {
+			if oi == ci {
+				// They deleted the element we're moving.
+				return dest, nil
+			}
+			if to := *c.ListMove; oi < to || (oi == to && ci < to) {
+				c.ListMove = intPtr(to - 1)
+			}
+		}
+		if oi < ci {
+			c.Path[common] = ci - 1
+		} else if oi == ci {
+			if oLen < cLen {
+				// c edits inside the deleted element.
+				return dest, nil
+			}
+			if c.ListDelete != nil {
+				if c.ListInsert == nil {
+					// Both delete the same element.
+					return dest, nil
+				}
+				// We replace what they deleted, so we insert instead.
+				c.ListDelete = nil
+			}
+		}
+
+	case other.ListMove != nil:
+		oi, ci, err := listIndices(other, c, common)
+		if err != nil {
+			return nil, err
+		}
+		otherFrom, otherTo := oi, *other.ListMove
+		switch {
+		case c.ListMove != nil && sameOperand:
+			if otherFrom == otherTo {
+				break
+			}
+			from, to := ci, *c.ListMove
+			if from == otherFrom {
+				// They moved the element we're moving; tie break.
+				if !left {
+					return dest, nil
+				}
+				c.Path[common] = otherTo
+				if from == to {
+					c.ListMove = intPtr(otherTo)
+				}
+				break
+			}
+			// Where did our element go?
+			newFrom, newTo := from, to
+			if from > otherFrom {
+				newFrom--
+			}
+			if from > otherTo {
+				newFrom++
+			} else if from == otherTo && otherFrom > otherTo {
+				newFrom++
+				if from == to {
+					newTo++
+				}
+			}
+			// Where do we put it?
+			if to > otherFrom {
+				newTo--
+			} else if to == otherFrom && to > from {
+				newTo--
+			}
+			if to > otherTo {
+				newTo++
+			} else if to == otherTo {
+				if (otherTo > otherFrom && to > from) || (otherTo < otherFrom && to < from) {
+					if !left {
+						newTo++
+					}
+				} else if to > from {
+					newTo++
+				} else if to == otherFrom {
+					newTo--
+				}
+			}
+			c.Path[common] = newFrom
+			c.ListMove = intPtr(newTo)
+
+		case c.ListInsert != nil && c.ListDelete == nil && sameOperand:
+			p := ci
+			if ci > otherFrom {
+				p--
+			}
+			if ci > otherTo {
+				p++
+			}
+			c.Path[common] = p
+
+		default:
+			// c cares about where its element ended up.
+			p := ci
+			if ci == otherFrom {
+				p = otherTo
+			} else {
+				if ci > otherFrom {
+					p--
+				}
+				if ci > otherTo || (ci == otherTo && otherFrom > otherTo) {
+					p++
+				}
+			}
+			c.Path[common] = p
+		}
+
+	case other.ObjectInsert != nil && other.ObjectDelete != nil:
+		if sameKey {
+			if c.ObjectInsert == nil || !sameOperand || !left {
+				return dest, nil
+			}
+			// Both set the key; c wins and replaces other's value.
+			c.ObjectDelete = other.ObjectInsert
+		}
+
+	case other.ObjectInsert != nil:
+		if sameKey && c.ObjectInsert != nil && sameOperand {
+			if !left {
+				return dest, nil
+			}
+			c.ObjectDelete = other.ObjectInsert
+		}
+
+	case other.ObjectDelete != nil:
+		if sameKey {
+			if c.ObjectInsert == nil || !sameOperand {
+				return dest, nil
+			}
+			// We set a key they deleted, so we insert instead.
+			c.ObjectDelete = nil
+		}
+	}
+
+	return t.append(dest, c)
+}
+
+// operandLen returns the depth of the value c acts on, counting a leaf
+// edit's path as one deeper so it lines up with list and object actions.
+func operandLen(c JSONComponent) int {
+	if c.isLeaf() {
+		return len(c.Path) + 1
+	}
+	return len(c.Path)
+}
+
+// commonLength reports whether the container that a acts in contains b's
+// operand, and if so returns the depth of a's operand in both paths. The
+// depth is -1 if a acts on the root.
+func commonLength(a, b JSONComponent) (int, bool) {
+	aLen, bLen := operandLen(a)-1, operandLen(b)-1
+	if aLen < 0 {
+		return -1, true
+	}
+	if bLen < 0 {
+		return 0, false
+	}
+	for i := 0; i < aLen; i++ {
+		if i >= bLen || a.Path[i] != b.Path[i] {
+			return 0, false
+		}
+	}
+	return aLen, true
+}
+
+// at returns p[i], or nil if i is out of range.
+func at(p []any, i int) any {
+	if i < 0 || i >= len(p) {
+		return nil
+	}
+	return p[i]
+}
+
+// listIndices returns the list indices at depth common of other, a list
+// action, and of c.
+func listIndices(other, c JSONComponent, common int) (int, int, error) {
+	oi, _ := at(other.Path, common).(int)
+	ci, ok := at(c.Path, common).(int)
+	if !ok {
+		return 0, 0, fmt.Errorf("path %v indexes a list with %v", c.Path, at(c.Path, common))
+	}
+	return oi, ci, nil
+}
+
+func intPtr(n int) *int { return &n }
@@ -6,11 +6,13 @@ import (
 )
 
 // Component is a single step in an OT operation.
-// Exactly one field should be set.
+// Exactly one of Retain, Insert or Delete should be set. Counts are in the
+// operation's Unit. Attributes may accompany a Retain or an Insert.
 type Component struct {
-	Retain int    `json:"retain,omitempty"` // keep N chars unchanged
-	Insert string `json:"insert,omitempty"` // insert text at cursor
-	Delete int    `json:"delete,omitempty"` // remove N chars at cursor
+	Retain     int        `json:"retain,omitempty"`     // keep N chars unchanged
+	Insert     string     `json:"insert,omitempty"`     // insert text at cursor
+	Delete     int        `json:"delete,omitempty"`     // remove N chars at cursor
+	Attributes Attributes `json:"attributes,omitempty"` // formatting to set or change
 }
 
 func (c Component) IsRetain() bool { return c.Retain > 0 && c.Insert == "" && c.Delete == 0 }
@@ -36,14 +38,20 @@ func (op Operation) BaseLen() int {
 	return n
 }
 
-// TargetLen returns the document length after the operation is applied.
+// TargetLen returns the document length after the operation is applied,
+// counted in UTF-16 code units.
 func (op Operation) TargetLen() int {
+	return UTF16.TargetLen(op)
+}
+
+// TargetLen returns the document length after op is applied, counted in u.
+func (u Unit) TargetLen(op Operation) int {
 	n := 0
 	for _, c := range op.Ops {
 		if c.IsRetain() {
 			n += c.Retain
 		} else if c.IsInsert() {
-			n += len(c.Insert)
+			n += u.Len(c.Insert)
 		}
 	}
 	return n
@@ -52,29 +60,44 @@ func (op Operation) TargetLen() int {
 // IsNoop returns true if the operation makes no changes.
 func (op Operation) IsNoop() bool {
 	for _, c := range op.Ops {
-		if c.IsInsert() || c.IsDelete() {
+		if c.IsInsert() || c.IsDelete() || len(c.Attributes) > 0 {
 			return false
 		}
 	}
 	return true
 }
 
-// Apply applies the operation to a document string.
+// Apply applies the operation to a document string, counting offsets in
+// UTF-16 code units. Attributes are ignored; use ApplyRich for formatted
+// documents.
 func Apply(doc string, op Operation) (string, error) {
-	if len(doc) != op.BaseLen() {
-		return "", fmt.Errorf("document length %d != operation base length %d", len(doc), op.BaseLen())
+	return UTF16.Apply(doc, op)
+}
+
+// Apply applies the operation to a document string, counting offsets in u.
+func (u Unit) Apply(doc string, op Operation) (string, error) {
+	if n := u.Len(doc); n != op.BaseLen() {
+		return "", fmt.Errorf("document length %d != operation base length %d", n, op.BaseLen())
 	}
 	var b strings.Builder
-	pos := 0
+	pos := 0 // byte offset into doc
 	for _, c := range op.Ops {
 		switch {
 		case c.IsRetain():
-			b.WriteString(doc[pos : pos+c.Retain])
-			pos += c.Retain
+			end, err := u.advance(doc, pos, c.Retain)
+			if err != nil {
+				return "", fmt.Errorf("retain %d: %w", c.Retain, err)
+			}
+			b.WriteString(doc[pos:end])
+			pos = end
 		case c.IsInsert():
 			b.WriteString(c.Insert)
 		case c.IsDelete():
-			pos += c.Delete
+			end, err := u.advance(doc, pos, c.Delete)
+			if err != nil {
+				return "", fmt.Errorf("delete %d: %w", c.Delete, err)
+			}
+			pos = end
 		}
 	}
 	return b.String(), nil
@@ -52,6 +52,7 @@ func TestIsNoop(t *testing.T) {
 		{"retain only", Operation{[]Component{{Retain: 5}}}, true},
 		{"has insert", Operation{[]Component{{Retain: 2}, {Insert: "x"}}}, false},
 		{"has delete", Operation{[]Component{{Delete: 1}}}, false},
+		{"formatting only", Operation{[]Component{{Retain: 2, Attributes: Attributes{"bold": true}}}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
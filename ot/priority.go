@@ -0,0 +1,35 @@
+package ot
+
+// Side says which of two concurrent operations wins ties: inserts at the
+// same position, or conflicting changes to the same value.
+type Side int
+
+const (
+	// SideLeft lets the operation go first: its inserts are placed before
+	// the other operation's and it wins conflicts.
+	SideLeft Side = iota
+	// SideRight lets the other operation go first.
+	SideRight
+)
+
+// SiteSide returns the side of an operation made by site when transformed
+// against one made by other. Sites are ordered by their IDs, so the lower
+// ID goes left no matter which operation arrives first. Operations from the
+// same site, or from unknown ("") sites, go left as with Transform.
+func SiteSide(site, other string) Side {
+	if site <= other {
+		return SideLeft
+	}
+	return SideRight
+}
+
+// TransformSide transforms two concurrent operations of type t like
+// t.Transform, but lets a win ties only if side is SideLeft. Otherwise b
+// wins them.
+func TransformSide(t Type, a, b Op, side Side) (aPrime, bPrime Op, err error) {
+	if side == SideLeft {
+		return t.Transform(a, b)
+	}
+	bPrime, aPrime, err = t.Transform(b, a)
+	return aPrime, bPrime, err
+}
@@ -0,0 +1,105 @@
+package ot
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSiteSide(t *testing.T) {
+	tests := []struct {
+		site, other string
+		want        Side
+	}{
+		{"a", "b", SideLeft},
+		{"b", "a", SideRight},
+		{"a", "a", SideLeft},
+		{"", "a", SideLeft},
+		{"", "", SideLeft},
+	}
+	for _, tt := range tests {
+		if got := SiteSide(tt.site, tt.other); got != tt.want {
+			t.Errorf("SiteSide(%q, %q) = %v, want %v", tt.site, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestTransformSide(t *testing.T) {
+	tests := []struct {
+		name string
+		side Side
+		want string
+	}{
+		{"left", SideLeft, "heABllo"},
+		{"right", SideRight, "heBAllo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := NewInsert(2, "A", 5), NewInsert(2, "B", 5)
+			aPrime, bPrime, err := TransformSide(Text, a, b, tt.side)
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterA, _ := Apply("hello", a)
+			path1, _ := Apply(afterA, bPrime.(Operation))
+			afterB, _ := Apply("hello", b)
+			path2, _ := Apply(afterB, aPrime.(Operation))
+			if path1 != tt.want || path2 != tt.want {
+				t.Errorf("got %q and %q, want %q", path1, path2, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformSide_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	for i := 0; i < 1000; i++ {
+		doc := randomText(r, 10)
+		a, b := randomOp(r, doc), randomOp(r, doc)
+		for _, side := range []Side{SideLeft, SideRight} {
+			aPrime, bPrime, err := TransformSide(Text, a, b, side)
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterA, _ := Apply(doc, a)
+			path1, err := Apply(afterA, bPrime.(Operation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterB, _ := Apply(doc, b)
+			path2, err := Apply(afterB, aPrime.(Operation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path1 != path2 {
+				t.Fatalf("side %v: convergence failed for doc=%q a=%+v b=%+v: %q vs %q", side, doc, a.Ops, b.Ops, path1, path2)
+			}
+		}
+	}
+}
+
+// TestJupiterEngine_ArrivalOrder checks that concurrent inserts at the same
+// position end up ordered by site, whatever order they reach the server in.
+func TestJupiterEngine_ArrivalOrder(t *testing.T) {
+	edits := []Edit{
+		{Op: NewInsert(1, "A", 2), Site: "site-a"},
+		{Op: NewInsert(1, "B", 2), Site: "site-b"},
+		{Op: NewInsert(1, "C", 2), Site: "site-c"},
+	}
+	orders := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	engine := &JupiterEngine{}
+	for _, order := range orders {
+		doc := NewDocument("xy")
+		for _, i := range order {
+			op, err := engine.TransformIncoming(Text, edits[i], 0, 0, doc.History)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.ApplyEdit(Edit{Op: op, Site: edits[i].Site}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if doc.Content != "xABCy" {
+			t.Errorf("arrival order %v: got %q, want %q", order, doc.Content, "xABCy")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package ot
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Attributes are formatting attributes such as "bold", "italic", "link" or
+// "header". On an insert they format the inserted text; on a retain they
+// change the formatting of the retained text, where a nil value removes
+// the attribute. Values must be JSON-compatible.
+type Attributes map[string]any
+
+// equal reports whether a and b hold the same attributes. A nil map and
+// an empty map are equal.
+func (a Attributes) equal(b Attributes) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// composeAttributes returns a with b applied on top. A nil value in b
+// removes the key; if keepNil is set the nil is kept instead, so that the
+// result still removes the attribute when applied to a retain.
+func composeAttributes(a, b Attributes, keepNil bool) Attributes {
+	out := make(Attributes, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v == nil && !keepNil {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// transformAttributes returns b's attributes transformed against a
+// concurrent formatting change a that wins conflicts: keys set by a are
+// dropped from b.
+func transformAttributes(a, b Attributes) Attributes {
+	if len(a) == 0 {
+		return b
+	}
+	out := make(Attributes, len(b))
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// RichText is a formatted document, represented as an insert-only
+// operation: each insert is a run of text sharing the same attributes.
+type RichText Operation
+
+// NewRichText creates a document of text formatted with attrs.
+func NewRichText(text string, attrs Attributes) RichText {
+	if text == "" {
+		return RichText{}
+	}
+	return RichText{Ops: []Component{{Insert: text, Attributes: attrs}}}
+}
+
+// Text returns the document's plain text.
+func (rt RichText) Text() string {
+	var b strings.Builder
+	for _, c := range rt.Ops {
+		b.WriteString(c.Insert)
+	}
+	return b.String()
+}
+
+// ApplyRich applies op to a rich-text document, updating both its text and
+// its formatting. Offsets are counted in UTF-16 code units.
+func ApplyRich(doc RichText, op Operation) (RichText, error) {
+	return UTF16.ApplyRich(doc, op)
+}
+
+// ApplyRich is like the package-level ApplyRich but counts offsets in u.
+func (u Unit) ApplyRich(doc RichText, op Operation) (RichText, error) {
+	for i, c := range doc.Ops {
+		if !c.IsInsert() {
+			return RichText{}, fmt.Errorf("rich text component %d is not an insert", i)
+		}
+	}
+	result, err := u.Compose(Operation(doc), op)
+	if err != nil {
+		return RichText{}, err
+	}
+	return RichText(result), nil
+}
+
+// InvertRich returns the operation that reverts op on the rich-text
+// document doc. Unlike Invert, it restores formatting: deleted text comes
+// back with its attributes, and each formatted retain sets the attributes
+// it changed back to their values in doc. Offsets are counted in UTF-16
+// code units.
+func InvertRich(op Operation, doc RichText) (Operation, error) {
+	return UTF16.InvertRich(op, doc)
+}
+
+// InvertRich is like the package-level InvertRich but counts offsets in u.
+func (u Unit) InvertRich(op Operation, doc RichText) (Operation, error) {
+	if n := u.TargetLen(Operation(doc)); n != op.BaseLen() {
+		return Operation{}, fmt.Errorf("document length %d != operation base length %d", n, op.BaseLen())
+	}
+	var ops []Component
+	it := newIter(doc.Ops, u)
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			// A retain may span runs with different formatting.
+			for n := c.Retain; n > 0; {
+				m := min(n, it.peekLen())
+				d := it.take(m)
+				ops = append(ops, Component{Retain: m, Attributes: revertAttributes(c.Attributes, d.Attributes)})
+				n -= m
+			}
+		case c.IsInsert():
+			ops = append(ops, Component{Delete: u.Len(c.Insert)})
+		case c.IsDelete():
+			for n := c.Delete; n > 0; {
+				m := min(n, it.peekLen())
+				ops = append(ops, it.take(m))
+				n -= m
+			}
+		}
+		if it.err != nil {
+			return Operation{}, fmt.Errorf("invert: %w", it.err)
+		}
+	}
+	return Operation{Ops: compact(ops)}, nil
+}
+
+// revertAttributes returns the formatting change that undoes change on
+// text formatted with old: each key change sets goes back to its old
+// value, or is removed if old didn't have it.
+func revertAttributes(change, old Attributes) Attributes {
+	if len(change) == 0 {
+		return nil
+	}
+	out := make(Attributes, len(change))
+	for k := range change {
+		out[k] = old[k]
+	}
+	return out
+}
@@ -0,0 +1,306 @@
+package ot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var (
+	bold   = Attributes{"bold": true}
+	italic = Attributes{"italic": true}
+)
+
+// verifyRichTransform checks that both transform paths converge on a
+// rich-text document, including formatting, and returns the result.
+func verifyRichTransform(t *testing.T, doc RichText, a, b Operation) RichText {
+	t.Helper()
+
+	aPrime, bPrime, err := Transform(a, b)
+	if err != nil {
+		t.Fatalf("Transform error: %v", err)
+	}
+	afterA, err := ApplyRich(doc, a)
+	if err != nil {
+		t.Fatalf("ApplyRich(doc, a) error: %v", err)
+	}
+	path1, err := ApplyRich(afterA, bPrime)
+	if err != nil {
+		t.Fatalf("ApplyRich(afterA, bPrime) error: %v", err)
+	}
+	afterB, err := ApplyRich(doc, b)
+	if err != nil {
+		t.Fatalf("ApplyRich(doc, b) error: %v", err)
+	}
+	path2, err := ApplyRich(afterB, aPrime)
+	if err != nil {
+		t.Fatalf("ApplyRich(afterB, aPrime) error: %v", err)
+	}
+	if !opsEqual(path1.Ops, path2.Ops) {
+		t.Errorf("convergence failed:\n  path1(a,bP)=%+v\n  path2(b,aP)=%+v", path1.Ops, path2.Ops)
+	}
+	return path1
+}
+
+func TestApplyRich(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  RichText
+		op   Operation
+		want []Component
+	}{
+		{
+			"bold a range",
+			NewRichText("hello", nil),
+			Operation{[]Component{{Retain: 1}, {Retain: 3, Attributes: bold}, {Retain: 1}}},
+			[]Component{{Insert: "h"}, {Insert: "ell", Attributes: bold}, {Insert: "o"}},
+		},
+		{
+			"insert formatted text",
+			NewRichText("ab", nil),
+			Operation{[]Component{{Retain: 1}, {Insert: "X", Attributes: italic}, {Retain: 1}}},
+			[]Component{{Insert: "a"}, {Insert: "X", Attributes: italic}, {Insert: "b"}},
+		},
+		{
+			"remove an attribute",
+			NewRichText("abc", Attributes{"bold": true, "italic": true}),
+			Operation{[]Component{{Retain: 3, Attributes: Attributes{"bold": nil}}}},
+			[]Component{{Insert: "abc", Attributes: italic}},
+		},
+		{
+			"add to existing attributes",
+			NewRichText("abc", bold),
+			Operation{[]Component{{Retain: 2, Attributes: italic}, {Retain: 1}}},
+			[]Component{{Insert: "ab", Attributes: Attributes{"bold": true, "italic": true}}, {Insert: "c", Attributes: bold}},
+		},
+		{
+			"delete formatted text",
+			RichText{[]Component{{Insert: "ab", Attributes: bold}, {Insert: "cd"}}},
+			NewDelete(1, 2, 4),
+			[]Component{{Insert: "a", Attributes: bold}, {Insert: "d"}},
+		},
+		{
+			"formatting merges adjacent runs",
+			RichText{[]Component{{Insert: "ab", Attributes: bold}, {Insert: "cd"}}},
+			Operation{[]Component{{Retain: 2}, {Retain: 2, Attributes: bold}}},
+			[]Component{{Insert: "abcd", Attributes: bold}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyRich(tt.doc, tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !opsEqual(got.Ops, tt.want) {
+				t.Errorf("ApplyRich() = %+v, want %+v", got.Ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRich_Errors(t *testing.T) {
+	if _, err := ApplyRich(NewRichText("ab", nil), NewInsert(0, "x", 5)); err == nil {
+		t.Error("expected error for length mismatch")
+	}
+	notRich := RichText{[]Component{{Retain: 2}}}
+	if _, err := ApplyRich(notRich, Operation{[]Component{{Retain: 2}}}); err == nil {
+		t.Error("expected error for non-insert document component")
+	}
+}
+
+func TestInvertRich(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  RichText
+		op   Operation
+		want []Component
+	}{
+		{
+			"bold",
+			NewRichText("hello", nil),
+			Operation{[]Component{{Retain: 1}, {Retain: 3, Attributes: bold}, {Retain: 1}}},
+			[]Component{{Retain: 1}, {Retain: 3, Attributes: Attributes{"bold": nil}}, {Retain: 1}},
+		},
+		{
+			"unbold",
+			NewRichText("abc", Attributes{"bold": true, "italic": true}),
+			Operation{[]Component{{Retain: 3, Attributes: Attributes{"bold": nil}}}},
+			[]Component{{Retain: 3, Attributes: bold}},
+		},
+		{
+			"format across runs",
+			RichText{[]Component{{Insert: "ab", Attributes: Attributes{"header": 1.0}}, {Insert: "cd"}}},
+			Operation{[]Component{{Retain: 4, Attributes: Attributes{"header": 2.0}}}},
+			[]Component{{Retain: 2, Attributes: Attributes{"header": 1.0}}, {Retain: 2, Attributes: Attributes{"header": nil}}},
+		},
+		{
+			"delete formatted text",
+			RichText{[]Component{{Insert: "ab", Attributes: bold}, {Insert: "cd"}}},
+			NewDelete(1, 2, 4),
+			[]Component{{Retain: 1}, {Insert: "b", Attributes: bold}, {Insert: "c"}, {Retain: 1}},
+		},
+		{
+			"insert",
+			NewRichText("ab", italic),
+			Operation{[]Component{{Retain: 1}, {Insert: "X", Attributes: bold}, {Retain: 1}}},
+			[]Component{{Retain: 1}, {Delete: 1}, {Retain: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := InvertRich(tt.op, tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !opsEqual(inv.Ops, tt.want) {
+				t.Errorf("InvertRich() = %+v, want %+v", inv.Ops, tt.want)
+			}
+			after, err := ApplyRich(tt.doc, tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ApplyRich(after, inv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !opsEqual(got.Ops, tt.doc.Ops) {
+				t.Errorf("ApplyRich(ApplyRich(doc, op), inv) = %+v, want %+v", got.Ops, tt.doc.Ops)
+			}
+		})
+	}
+
+	if _, err := InvertRich(NewInsert(0, "x", 5), NewRichText("hi", nil)); err == nil {
+		t.Error("expected error for length mismatch")
+	}
+}
+
+func TestTransform_Attributes(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  RichText
+		a, b Operation
+		want []Component
+	}{
+		{
+			"different attributes on overlapping ranges",
+			NewRichText("abcd", nil),
+			Operation{[]Component{{Retain: 3, Attributes: bold}, {Retain: 1}}},
+			Operation{[]Component{{Retain: 1}, {Retain: 3, Attributes: italic}}},
+			[]Component{
+				{Insert: "a", Attributes: bold},
+				{Insert: "bc", Attributes: Attributes{"bold": true, "italic": true}},
+				{Insert: "d", Attributes: italic},
+			},
+		},
+		{
+			"same attribute, conflicting values (a wins)",
+			NewRichText("abc", nil),
+			Operation{[]Component{{Retain: 3, Attributes: Attributes{"color": "red"}}}},
+			Operation{[]Component{{Retain: 3, Attributes: Attributes{"color": "blue"}}}},
+			[]Component{{Insert: "abc", Attributes: Attributes{"color": "red"}}},
+		},
+		{
+			"set versus remove (a wins)",
+			NewRichText("abc", bold),
+			Operation{[]Component{{Retain: 3, Attributes: Attributes{"bold": nil}}}},
+			Operation{[]Component{{Retain: 3, Attributes: Attributes{"bold": true, "italic": true}}}},
+			[]Component{{Insert: "abc", Attributes: italic}},
+		},
+		{
+			"format versus delete",
+			NewRichText("abcd", nil),
+			Operation{[]Component{{Retain: 4, Attributes: bold}}},
+			NewDelete(1, 2, 4),
+			[]Component{{Insert: "ad", Attributes: bold}},
+		},
+		{
+			"format versus formatted insert",
+			NewRichText("ab", nil),
+			Operation{[]Component{{Retain: 2, Attributes: bold}}},
+			Operation{[]Component{{Retain: 1}, {Insert: "X", Attributes: italic}, {Retain: 1}}},
+			[]Component{{Insert: "a", Attributes: bold}, {Insert: "X", Attributes: italic}, {Insert: "b", Attributes: bold}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifyRichTransform(t, tt.doc, tt.a, tt.b)
+			if !opsEqual(got.Ops, tt.want) {
+				t.Errorf("got %+v, want %+v", got.Ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompose_Attributes(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Operation
+		want []Component
+	}{
+		{
+			"format then unformat keeps removal",
+			Operation{[]Component{{Retain: 2, Attributes: bold}}},
+			Operation{[]Component{{Retain: 2, Attributes: Attributes{"bold": nil}}}},
+			[]Component{{Retain: 2, Attributes: Attributes{"bold": nil}}},
+		},
+		{
+			"insert then format",
+			Operation{[]Component{{Insert: "ab", Attributes: bold}}},
+			Operation{[]Component{{Retain: 1, Attributes: Attributes{"bold": nil}}, {Retain: 1, Attributes: italic}}},
+			[]Component{{Insert: "a"}, {Insert: "b", Attributes: Attributes{"bold": true, "italic": true}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compose(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !opsEqual(got.Ops, tt.want) {
+				t.Errorf("Compose() = %+v, want %+v", got.Ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompact_KeepsDifferentAttributesApart(t *testing.T) {
+	ops := compact([]Component{
+		{Retain: 1, Attributes: bold},
+		{Retain: 1, Attributes: Attributes{"bold": true}},
+		{Retain: 1},
+		{Insert: "a", Attributes: italic},
+		{Insert: "b"},
+	})
+	want := []Component{
+		{Retain: 2, Attributes: bold},
+		{Retain: 1},
+		{Insert: "a", Attributes: italic},
+		{Insert: "b"},
+	}
+	if !opsEqual(ops, want) {
+		t.Errorf("compact() = %+v, want %+v", ops, want)
+	}
+}
+
+func TestAttributes_JSON(t *testing.T) {
+	data := `{"ops":[{"retain":2,"attributes":{"bold":null,"link":"https://example.com"}},{"insert":"x","attributes":{"header":1}}]}`
+	var op Operation
+	if err := json.Unmarshal([]byte(data), &op); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := op.Ops[0].Attributes["bold"]; !ok || v != nil {
+		t.Errorf("bold = %v, %v; want explicit null", v, ok)
+	}
+	out, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("round trip:\n  got  %s\n  want %s", out, data)
+	}
+
+	plain, _ := json.Marshal(NewInsert(1, "a", 2))
+	if string(plain) != `{"ops":[{"retain":1},{"insert":"a"},{"retain":1}]}` {
+		t.Errorf("plain operation encoding changed: %s", plain)
+	}
+}
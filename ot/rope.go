@@ -0,0 +1,288 @@
+package ot
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLeaf is the most bytes of text a rope leaf holds.
+const maxLeaf = 1024
+
+// Rope is an immutable string stored as a balanced tree of chunks. Applying
+// an operation to a rope takes O(k log n) time for an operation of k
+// components on n units, where applying it to a string copies the whole
+// string. Documents of TextType keep their snapshots as ropes. The zero
+// value is the empty string.
+type Rope struct {
+	root *ropeNode
+}
+
+// ropeNode is a leaf holding text, or an inner node with both children
+// set. Trees are AVL-balanced and never modified once built; edits copy the
+// path to the nodes they change.
+type ropeNode struct {
+	left, right *ropeNode
+	text        string
+	height      int
+	bytes       int
+	utf16       int // length in UTF-16 code units
+	runes       int // length in code points
+}
+
+// NewRope returns a rope holding s.
+func NewRope(s string) Rope {
+	return Rope{build(s)}
+}
+
+// String returns the rope's text.
+func (r Rope) String() string {
+	if r.root == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.Grow(r.root.bytes)
+	r.root.write(&b)
+	return b.String()
+}
+
+// Len returns the length of the rope's text in units.
+func (r Rope) Len(u Unit) int { return r.root.len(u) }
+
+// ApplyRope applies the operation to a rope, counting offsets in UTF-16
+// code units. Attributes are ignored.
+func ApplyRope(doc Rope, op Operation) (Rope, error) {
+	return UTF16.ApplyRope(doc, op)
+}
+
+// ApplyRope is like the package-level ApplyRope but counts offsets in u.
+func (u Unit) ApplyRope(doc Rope, op Operation) (Rope, error) {
+	if n := doc.Len(u); n != op.BaseLen() {
+		return Rope{}, fmt.Errorf("document length %d != operation base length %d", n, op.BaseLen())
+	}
+	var out *ropeNode
+	rest := doc.root
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			kept, r, err := split(rest, c.Retain, u)
+			if err != nil {
+				return Rope{}, fmt.Errorf("retain %d: %w", c.Retain, err)
+			}
+			out, rest = concat(out, kept), r
+		case c.IsInsert():
+			out = concat(out, build(c.Insert))
+		case c.IsDelete():
+			_, r, err := split(rest, c.Delete, u)
+			if err != nil {
+				return Rope{}, fmt.Errorf("delete %d: %w", c.Delete, err)
+			}
+			rest = r
+		}
+	}
+	return Rope{out}, nil
+}
+
+// invertRope is Invert for a rope.
+func (u Unit) invertRope(op Operation, doc Rope) (Operation, error) {
+	if n := doc.Len(u); n != op.BaseLen() {
+		return Operation{}, fmt.Errorf("document length %d != operation base length %d", n, op.BaseLen())
+	}
+	var ops []Component
+	rest := doc.root
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			_, r, err := split(rest, c.Retain, u)
+			if err != nil {
+				return Operation{}, fmt.Errorf("retain %d: %w", c.Retain, err)
+			}
+			ops = append(ops, Component{Retain: c.Retain})
+			rest = r
+		case c.IsInsert():
+			ops = append(ops, Component{Delete: u.Len(c.Insert)})
+		case c.IsDelete():
+			deleted, r, err := split(rest, c.Delete, u)
+			if err != nil {
+				return Operation{}, fmt.Errorf("delete %d: %w", c.Delete, err)
+			}
+			ops = append(ops, Component{Insert: Rope{deleted}.String()})
+			rest = r
+		}
+	}
+	return Operation{Ops: compact(ops)}, nil
+}
+
+func (n *ropeNode) len(u Unit) int {
+	if n == nil {
+		return 0
+	}
+	if u == CodePoint {
+		return n.runes
+	}
+	return n.utf16
+}
+
+func (n *ropeNode) leaf() bool { return n.left == nil }
+
+func (n *ropeNode) write(b *strings.Builder) {
+	if n.leaf() {
+		b.WriteString(n.text)
+		return
+	}
+	n.left.write(b)
+	n.right.write(b)
+}
+
+func height(n *ropeNode) int {
+	if n == nil {
+		return -1
+	}
+	return n.height
+}
+
+func newLeaf(text string) *ropeNode {
+	if text == "" {
+		return nil
+	}
+	return &ropeNode{
+		text:  text,
+		bytes: len(text),
+		utf16: UTF16.Len(text),
+		runes: utf8.RuneCountInString(text),
+	}
+}
+
+func newNode(l, r *ropeNode) *ropeNode {
+	return &ropeNode{
+		left:   l,
+		right:  r,
+		height: max(l.height, r.height) + 1,
+		bytes:  l.bytes + r.bytes,
+		utf16:  l.utf16 + r.utf16,
+		runes:  l.runes + r.runes,
+	}
+}
+
+// build returns a balanced tree holding s in leaves of up to maxLeaf bytes,
+// split between runes.
+func build(s string) *ropeNode {
+	if len(s) <= maxLeaf {
+		return newLeaf(s)
+	}
+	var leaves []*ropeNode
+	for s != "" {
+		n := min(len(s), maxLeaf)
+		for n < len(s) && n > maxLeaf-utf8.UTFMax && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		leaves = append(leaves, newLeaf(s[:n]))
+		s = s[n:]
+	}
+	return buildNodes(leaves)
+}
+
+func buildNodes(leaves []*ropeNode) *ropeNode {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	mid := len(leaves) / 2
+	return newNode(buildNodes(leaves[:mid]), buildNodes(leaves[mid:]))
+}
+
+// balance returns a node joining l and r, whose heights differ by at most
+// two, rotating to keep it balanced.
+func balance(l, r *ropeNode) *ropeNode {
+	switch {
+	case height(l) > height(r)+1:
+		if height(l.left) >= height(l.right) {
+			return newNode(l.left, newNode(l.right, r))
+		}
+		return newNode(newNode(l.left, l.right.left), newNode(l.right.right, r))
+	case height(r) > height(l)+1:
+		if height(r.right) >= height(r.left) {
+			return newNode(newNode(l, r.left), r.right)
+		}
+		return newNode(newNode(l, r.left.left), newNode(r.left.right, r.right))
+	}
+	return newNode(l, r)
+}
+
+// join returns a balanced tree holding l followed by r.
+func join(l, r *ropeNode) *ropeNode {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case height(l) > height(r)+1:
+		return balance(l.left, join(l.right, r))
+	case height(r) > height(l)+1:
+		return balance(join(l, r.left), r.right)
+	}
+	return newNode(l, r)
+}
+
+// concat is join, merging the leaves where l and r meet if they are small
+// enough, so that edits don't break the text into ever smaller leaves.
+func concat(l, r *ropeNode) *ropeNode {
+	if l == nil || r == nil {
+		return join(l, r)
+	}
+	a, b := l, r
+	for !a.leaf() {
+		a = a.right
+	}
+	for !b.leaf() {
+		b = b.left
+	}
+	if a.bytes+b.bytes > maxLeaf {
+		return join(l, r)
+	}
+	return join(withLast(l, a.text+b.text), withoutFirst(r))
+}
+
+// withLast returns n with its last leaf's text replaced by text.
+func withLast(n *ropeNode, text string) *ropeNode {
+	if n.leaf() {
+		return newLeaf(text)
+	}
+	return newNode(n.left, withLast(n.right, text))
+}
+
+// withoutFirst returns n without its first leaf.
+func withoutFirst(n *ropeNode) *ropeNode {
+	if n.leaf() {
+		return nil
+	}
+	l := withoutFirst(n.left)
+	if l == nil {
+		return n.right
+	}
+	return balance(l, n.right)
+}
+
+// split divides n after its first i units.
+func split(n *ropeNode, i int, u Unit) (*ropeNode, *ropeNode, error) {
+	switch {
+	case i == 0:
+		return nil, n, nil
+	case i == n.len(u):
+		return n, nil, nil
+	case n == nil || i > n.len(u):
+		return nil, nil, fmt.Errorf("offset past end of text")
+	case n.leaf():
+		pos, err := u.advance(n.text, 0, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newLeaf(n.text[:pos]), newLeaf(n.text[pos:]), nil
+	}
+	left := n.left.len(u)
+	if i <= left {
+		l, r, err := split(n.left, i, u)
+		return l, join(r, n.right), err
+	}
+	l, r, err := split(n.right, i-left, u)
+	return join(n.left, l), r, err
+}
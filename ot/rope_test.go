@@ -0,0 +1,146 @@
+package ot
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRope_NewRope(t *testing.T) {
+	for _, s := range []string{"", "hello", strings.Repeat("a😀é", 2000)} {
+		r := NewRope(s)
+		if got := r.String(); got != s {
+			t.Errorf("NewRope(%.20q...).String() = %.20q...", s, got)
+		}
+		if got, want := r.Len(UTF16), UTF16.Len(s); got != want {
+			t.Errorf("Len(UTF16) = %d, want %d", got, want)
+		}
+		if got, want := r.Len(CodePoint), CodePoint.Len(s); got != want {
+			t.Errorf("Len(CodePoint) = %d, want %d", got, want)
+		}
+	}
+	var zero Rope
+	if zero.String() != "" || zero.Len(UTF16) != 0 {
+		t.Errorf("zero Rope = %q, want empty", zero.String())
+	}
+}
+
+func TestRope_ApplyErrors(t *testing.T) {
+	r := NewRope("😀")
+	if _, err := ApplyRope(r, NewInsert(0, "x", 5)); err == nil {
+		t.Error("expected error for length mismatch")
+	}
+	// Retaining one unit would split the surrogate pair.
+	op := Operation{Ops: []Component{{Retain: 1}, {Insert: "x"}, {Retain: 1}}}
+	if _, err := ApplyRope(r, op); err == nil {
+		t.Error("expected error for split surrogate pair")
+	}
+	if got, err := CodePoint.ApplyRope(r, Operation{Ops: []Component{{Retain: 1}, {Insert: "x"}}}); err != nil || got.String() != "😀x" {
+		t.Errorf("CodePoint.ApplyRope = %q, %v; want %q", got.String(), err, "😀x")
+	}
+}
+
+// TestRope_Random applies random edits to a multi-leaf rope and checks it
+// against applying them to a string, and that the tree stays balanced.
+func TestRope_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	doc := randomText(r, 5000)
+	rope := NewRope(doc)
+	for i := 0; i < 2000; i++ {
+		op := randomLocalOp(r, doc, false)
+		inv, err := Text.Invert(op, rope)
+		if err != nil {
+			t.Fatalf("iteration %d: invert: %v", i, err)
+		}
+		if want, _ := Invert(op, doc); !opsEqual(inv.(Operation).Ops, want.Ops) {
+			t.Fatalf("iteration %d: inverse %+v, want %+v", i, inv.(Operation).Ops, want.Ops)
+		}
+		if doc, err = Apply(doc, op); err != nil {
+			t.Fatal(err)
+		}
+		if rope, err = ApplyRope(rope, op); err != nil {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+		if rope.String() != doc {
+			t.Fatalf("iteration %d: rope and string differ", i)
+		}
+	}
+	checkBalanced(t, rope.root)
+}
+
+func checkBalanced(t *testing.T, n *ropeNode) {
+	t.Helper()
+	if n == nil || n.leaf() {
+		return
+	}
+	if d := height(n.left) - height(n.right); d < -1 || d > 1 {
+		t.Fatalf("unbalanced node: heights %d and %d", height(n.left), height(n.right))
+	}
+	checkBalanced(t, n.left)
+	checkBalanced(t, n.right)
+}
+
+func TestDocument_RopeSnapshot(t *testing.T) {
+	doc := NewDocument("hello")
+	if err := doc.Apply(NewInsert(5, " world", 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc.Snapshot().(Rope); !ok {
+		t.Fatalf("snapshot is %T, want Rope", doc.Snapshot())
+	}
+	if doc.Content != "hello world" {
+		t.Errorf("content = %q, want %q", doc.Content, "hello world")
+	}
+	if s, err := Text.Serialize(doc.Snapshot()); err != nil || s != "hello world" {
+		t.Errorf("Serialize = %q, %v", s, err)
+	}
+}
+
+// benchmarkEdits returns a document of about size bytes and single-character
+// edits at random positions in it, as typing would produce.
+func benchmarkEdits(size int) (string, []Operation) {
+	r := rand.New(rand.NewSource(1))
+	doc := strings.Repeat("lorem ipsum dolor sit amet\n", size/27)
+	n := UTF16.Len(doc)
+	ops := make([]Operation, 100)
+	for i := range ops {
+		ops[i] = NewInsert(r.Intn(n+1), "x", n)
+		n++
+	}
+	return doc, ops
+}
+
+// BenchmarkApply applies single-character inserts to multi-megabyte
+// documents held as strings and as ropes.
+func BenchmarkApply(b *testing.B) {
+	for _, size := range []struct {
+		name  string
+		bytes int
+	}{{"1MB", 1 << 20}, {"8MB", 8 << 20}} {
+		doc, ops := benchmarkEdits(size.bytes)
+		b.Run(size.name+"/string", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s := doc
+				for _, op := range ops {
+					var err error
+					if s, err = Apply(s, op); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(size.name+"/rope", func(b *testing.B) {
+			rope := NewRope(doc)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r := rope
+				for _, op := range ops {
+					var err error
+					if r, err = ApplyRope(r, op); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
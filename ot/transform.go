@@ -6,36 +6,48 @@ import "fmt"
 // document state) and returns aPrime and bPrime such that:
 //
 //	Apply(Apply(doc, a), bPrime) == Apply(Apply(doc, b), aPrime)
+//
+// Offsets are counted in UTF-16 code units. a and b are transformed in
+// their Normalize form, so operations with the same effect transform the
+// same way whichever order their adjacent inserts and deletes come in.
 func Transform(a, b Operation) (aPrime, bPrime Operation, err error) {
+	return UTF16.Transform(a, b)
+}
+
+// Transform is like the package-level Transform but counts offsets in u.
+func (u Unit) Transform(a, b Operation) (aPrime, bPrime Operation, err error) {
 	if a.BaseLen() != b.BaseLen() {
 		return Operation{}, Operation{}, fmt.Errorf(
 			"base lengths differ: a=%d, b=%d", a.BaseLen(), b.BaseLen())
 	}
+	// Whether an insert wins a tie depends on whether it comes before or
+	// after a delete at the same position.
+	a, b = Normalize(a), Normalize(b)
 
 	var ap, bp []Component
-	ia := newIter(a.Ops)
-	ib := newIter(b.Ops)
+	ia := newIter(a.Ops, u)
+	ib := newIter(b.Ops, u)
 
 	for ia.hasNext() || ib.hasNext() {
 		// Both insert: a goes first (tie-break).
 		if ia.peekType() == compInsert && ib.peekType() == compInsert {
 			c := ia.take(0)
-			ap = append(ap, Component{Insert: c.Insert})
-			bp = append(bp, Component{Retain: len(c.Insert)})
+			ap = append(ap, c)
+			bp = append(bp, Component{Retain: u.Len(c.Insert)})
 			continue
 		}
 		// Only a inserts.
 		if ia.peekType() == compInsert {
 			c := ia.take(0)
-			ap = append(ap, Component{Insert: c.Insert})
-			bp = append(bp, Component{Retain: len(c.Insert)})
+			ap = append(ap, c)
+			bp = append(bp, Component{Retain: u.Len(c.Insert)})
 			continue
 		}
 		// Only b inserts.
 		if ib.peekType() == compInsert {
 			c := ib.take(0)
-			bp = append(bp, Component{Insert: c.Insert})
-			ap = append(ap, Component{Retain: len(c.Insert)})
+			bp = append(bp, c)
+			ap = append(ap, Component{Retain: u.Len(c.Insert)})
 			continue
 		}
 
@@ -50,8 +62,9 @@ func Transform(a, b Operation) (aPrime, bPrime Operation, err error) {
 
 		switch {
 		case ca.IsRetain() && cb.IsRetain():
-			ap = append(ap, Component{Retain: n})
-			bp = append(bp, Component{Retain: n})
+			// Conflicting formatting: a wins, like the insert tie-break.
+			ap = append(ap, Component{Retain: n, Attributes: ca.Attributes})
+			bp = append(bp, Component{Retain: n, Attributes: transformAttributes(ca.Attributes, cb.Attributes)})
 		case ca.IsDelete() && cb.IsRetain():
 			ap = append(ap, Component{Delete: n})
 		case ca.IsRetain() && cb.IsDelete():
@@ -61,10 +74,16 @@ func Transform(a, b Operation) (aPrime, bPrime Operation, err error) {
 		}
 	}
 
+	if ia.err != nil {
+		return Operation{}, Operation{}, fmt.Errorf("transform: %w", ia.err)
+	}
+	if ib.err != nil {
+		return Operation{}, Operation{}, fmt.Errorf("transform: %w", ib.err)
+	}
 	return Operation{Ops: compact(ap)}, Operation{Ops: compact(bp)}, nil
 }
 
-// compact merges adjacent components of the same type.
+// compact merges adjacent components of the same type and attributes.
 func compact(ops []Component) []Component {
 	if len(ops) == 0 {
 		return ops
@@ -76,11 +95,12 @@ func compact(ops []Component) []Component {
 			continue
 		}
 		last := &result[len(result)-1]
-		if c.IsRetain() && last.IsRetain() {
+		sameAttrs := last.Attributes.equal(c.Attributes)
+		if c.IsRetain() && last.IsRetain() && sameAttrs {
 			last.Retain += c.Retain
 		} else if c.IsDelete() && last.IsDelete() {
 			last.Delete += c.Delete
-		} else if c.IsInsert() && last.IsInsert() {
+		} else if c.IsInsert() && last.IsInsert() && sameAttrs {
 			last.Insert += c.Insert
 		} else {
 			result = append(result, c)
@@ -100,14 +120,17 @@ const (
 )
 
 // iter walks through operation components, allowing partial consumption.
+// Offsets within a component are counted in unit.
 type iter struct {
 	ops    []Component
+	unit   Unit
 	index  int
 	offset int
+	err    error // set if an insert could not be split at a unit boundary
 }
 
-func newIter(ops []Component) *iter {
-	return &iter{ops: ops}
+func newIter(ops []Component, u Unit) *iter {
+	return &iter{ops: ops, unit: u}
 }
 
 func (it *iter) hasNext() bool {
@@ -138,7 +161,7 @@ func (it *iter) peekLen() int {
 	case c.IsRetain():
 		return c.Retain - it.offset
 	case c.IsInsert():
-		return len(c.Insert) - it.offset
+		return it.unit.Len(c.Insert) - it.offset
 	case c.IsDelete():
 		return c.Delete - it.offset
 	}
@@ -155,21 +178,24 @@ func (it *iter) take(n int) Component {
 		if n >= remaining {
 			it.index++
 			it.offset = 0
-			return Component{Retain: remaining}
+			return Component{Retain: remaining, Attributes: c.Attributes}
 		}
 		it.offset += n
-		return Component{Retain: n}
+		return Component{Retain: n, Attributes: c.Attributes}
 
 	case c.IsInsert():
 		if n == 0 || n >= remaining {
-			s := c.Insert[it.offset:]
+			s := c.Insert
+			if it.offset > 0 {
+				s = it.sliceInsert(c.Insert, it.offset, it.offset+remaining)
+			}
 			it.index++
 			it.offset = 0
-			return Component{Insert: s}
+			return Component{Insert: s, Attributes: c.Attributes}
 		}
-		s := c.Insert[it.offset : it.offset+n]
+		s := it.sliceInsert(c.Insert, it.offset, it.offset+n)
 		it.offset += n
-		return Component{Insert: s}
+		return Component{Insert: s, Attributes: c.Attributes}
 
 	case c.IsDelete():
 		if n >= remaining {
@@ -185,6 +211,15 @@ func (it *iter) take(n int) Component {
 	return Component{}
 }
 
+// sliceInsert returns units [i, j) of s, recording the first split error.
+func (it *iter) sliceInsert(s string, i, j int) string {
+	out, err := it.unit.slice(s, i, j)
+	if err != nil && it.err == nil {
+		it.err = err
+	}
+	return out
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
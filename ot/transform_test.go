@@ -278,6 +278,24 @@ func TestTransform_ErrorOnMismatchedBaseLens(t *testing.T) {
 	}
 }
 
+func TestTransform_InsertDeleteOrder(t *testing.T) {
+	// Replacing "b" with "x" has the same effect in either order, and must
+	// win or lose the tie with a concurrent insert at "b" the same way.
+	doc := "abc"
+	b := NewInsert(1, "y", 3)
+	for _, a := range []Operation{
+		{[]Component{{Retain: 1}, {Insert: "x"}, {Delete: 1}, {Retain: 1}}},
+		{[]Component{{Retain: 1}, {Delete: 1}, {Insert: "x"}, {Retain: 1}}},
+	} {
+		verifyTransform(t, doc, a, b)
+		_, bPrime, _ := Transform(a, b)
+		afterA, _ := Apply(doc, a)
+		if got, _ := Apply(afterA, bPrime); got != "axyc" {
+			t.Errorf("a=%+v: got %q, want %q", a.Ops, got, "axyc")
+		}
+	}
+}
+
 func TestTransform_Noop(t *testing.T) {
 	doc := "hello"
 	a := Operation{[]Component{{Retain: 5}}}
@@ -286,16 +304,16 @@ func TestTransform_Noop(t *testing.T) {
 }
 
 func TestTransform_Unicode(t *testing.T) {
-	// Note: Go strings are byte-indexed, so we work with byte positions.
-	doc := "hello"
-	a := NewInsert(5, " world", 5)
-	b := NewInsert(0, ">>> ", 5)
+	// Offsets are UTF-16 code units: "héllo😀" has length 7.
+	doc := "héllo😀"
+	a := NewInsert(7, " wörld", 7)
+	b := NewInsert(0, "🎉 ", 7)
 	verifyTransform(t, doc, a, b)
 
 	_, bPrime, _ := Transform(a, b)
 	afterA, _ := Apply(doc, a)
 	result, _ := Apply(afterA, bPrime)
-	if result != ">>> hello world" {
-		t.Errorf("got %q, want %q", result, ">>> hello world")
+	if result != "🎉 héllo😀 wörld" {
+		t.Errorf("got %q, want %q", result, "🎉 héllo😀 wörld")
 	}
 }
@@ -0,0 +1,349 @@
+package ot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Op is an operation of some Type. Plain-text and rich-text operations are
+// Operation values.
+type Op = any
+
+// Type is a kind of collaboratively edited document: plain text, rich text,
+// JSON and so on. Documents, sessions and stores handle snapshots and
+// operations only through their Type, so new types can be added with
+// Register.
+type Type interface {
+	// Name identifies the type in stores and on the wire.
+	Name() string
+	// Create parses a serialized snapshot. The empty string is the empty
+	// document.
+	Create(data string) (any, error)
+	// Serialize encodes a snapshot as produced by Create or Apply.
+	Serialize(snapshot any) (string, error)
+	// DecodeOp parses, validates and normalizes a JSON-encoded operation.
+	// Operations are encoded with encoding/json.
+	DecodeOp(data []byte) (Op, error)
+	// Apply applies op to snapshot and returns the new snapshot.
+	Apply(snapshot any, op Op) (any, error)
+	// Transform transforms two concurrent operations against each other.
+	// On conflicts a wins.
+	Transform(a, b Op) (aPrime, bPrime Op, err error)
+	// Compose merges two sequential operations into one.
+	Compose(a, b Op) (Op, error)
+	// IsNoop reports whether op leaves every snapshot unchanged.
+	IsNoop(op Op) bool
+}
+
+// Inverter is implemented by types whose operations can be reverted, which
+// server-side undo requires.
+type Inverter interface {
+	// Invert returns the operation that reverts op on snapshot.
+	Invert(op Op, snapshot any) (Op, error)
+}
+
+var (
+	typesMu sync.RWMutex
+	types   = make(map[string]Type)
+)
+
+// Register makes a document type available by name. It panics if a type
+// with the same name is already registered.
+func Register(t Type) {
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	if _, dup := types[t.Name()]; dup {
+		panic("ot: Register called twice for type " + t.Name())
+	}
+	types[t.Name()] = t
+}
+
+// LookupType returns the registered type with the given name.
+func LookupType(name string) (Type, error) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	t, ok := types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown document type %q", name)
+	}
+	return t, nil
+}
+
+// Types returns the names of all registered types, sorted.
+func Types() []string {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(Text)
+	Register(TextType{Unit: CodePoint})
+	Register(Rich)
+	Register(RichTextType{Unit: CodePoint})
+	Register(JSON)
+	Register(JSONType{Unit: CodePoint})
+}
+
+// Text is the default document type: plain text in UTF-16 units.
+var Text = TextType{Unit: UTF16}
+
+// Rich is rich text in UTF-16 units.
+var Rich = RichTextType{Unit: UTF16}
+
+// TextType is plain text edited with Operation. Snapshots are strings, or
+// Ropes, which Document uses so that edits to large documents don't copy
+// them; Apply returns a snapshot of the kind it was given.
+type TextType struct {
+	Unit Unit
+}
+
+// Name returns "text", suffixed with the unit if it is not UTF16.
+func (t TextType) Name() string { return typeName("text", t.Unit) }
+
+func (t TextType) Create(data string) (any, error) { return data, nil }
+
+func (t TextType) Serialize(snapshot any) (string, error) {
+	switch s := snapshot.(type) {
+	case string:
+		return s, nil
+	case Rope:
+		return s.String(), nil
+	}
+	return "", fmt.Errorf("%s: snapshot is %T, not string", t.Name(), snapshot)
+}
+
+func (t TextType) DecodeOp(data []byte) (Op, error) { return decodeOperation(data) }
+
+func (t TextType) Apply(snapshot any, op Op) (any, error) {
+	o, err := asOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := snapshot.(Rope); ok {
+		return t.Unit.ApplyRope(r, o)
+	}
+	s, err := t.Serialize(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return t.Unit.Apply(s, o)
+}
+
+func (t TextType) Transform(a, b Op) (Op, Op, error) { return transformOperations(t.Unit, a, b) }
+
+func (t TextType) Compose(a, b Op) (Op, error) { return composeOperations(t.Unit, a, b) }
+
+func (t TextType) IsNoop(op Op) bool {
+	o, err := asOperation(op)
+	return err == nil && o.IsNoop()
+}
+
+func (t TextType) Invert(op Op, snapshot any) (Op, error) {
+	o, err := asOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := snapshot.(Rope); ok {
+		return t.Unit.invertRope(o, r)
+	}
+	s, err := t.Serialize(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return t.Unit.Invert(o, s)
+}
+
+func (t TextType) Blame(snapshot any) (Blame, error) {
+	if r, ok := snapshot.(Rope); ok {
+		return NewBlame(r.Len(t.Unit)), nil
+	}
+	s, err := t.Serialize(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlame(t.Unit.Len(s)), nil
+}
+
+func (t TextType) ApplyBlame(b Blame, op Op, author string) (Blame, error) {
+	return applyBlame(t.Unit, b, op, author)
+}
+
+func (t TextType) Len(snapshot any) (int, error) {
+	if r, ok := snapshot.(Rope); ok {
+		return r.Len(t.Unit), nil
+	}
+	s, err := t.Serialize(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unit.Len(s), nil
+}
+
+func (t TextType) TransformIndex(index int, op Op, bias Bias) (int, error) {
+	o, err := asOperation(op)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unit.TransformIndex(index, o, bias), nil
+}
+
+// RichTextType is formatted text edited with Operation, using attributes.
+// Snapshots are RichText values, serialized as JSON.
+type RichTextType struct {
+	Unit Unit
+}
+
+// Name returns "rich-text", suffixed with the unit if it is not UTF16.
+func (t RichTextType) Name() string { return typeName("rich-text", t.Unit) }
+
+func (t RichTextType) Create(data string) (any, error) {
+	var rt RichText
+	if data == "" {
+		return rt, nil
+	}
+	if err := json.Unmarshal([]byte(data), &rt); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.Name(), err)
+	}
+	return rt, nil
+}
+
+func (t RichTextType) Serialize(snapshot any) (string, error) {
+	rt, ok := snapshot.(RichText)
+	if !ok {
+		return "", fmt.Errorf("%s: snapshot is %T, not RichText", t.Name(), snapshot)
+	}
+	if rt.Ops == nil {
+		rt.Ops = []Component{}
+	}
+	b, err := json.Marshal(rt)
+	return string(b), err
+}
+
+func (t RichTextType) DecodeOp(data []byte) (Op, error) { return decodeOperation(data) }
+
+func (t RichTextType) Apply(snapshot any, op Op) (any, error) {
+	rt, ok := snapshot.(RichText)
+	if !ok {
+		return nil, fmt.Errorf("%s: snapshot is %T, not RichText", t.Name(), snapshot)
+	}
+	o, err := asOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	return t.Unit.ApplyRich(rt, o)
+}
+
+func (t RichTextType) Transform(a, b Op) (Op, Op, error) { return transformOperations(t.Unit, a, b) }
+
+func (t RichTextType) Compose(a, b Op) (Op, error) { return composeOperations(t.Unit, a, b) }
+
+func (t RichTextType) IsNoop(op Op) bool {
+	o, err := asOperation(op)
+	return err == nil && o.IsNoop()
+}
+
+func (t RichTextType) Invert(op Op, snapshot any) (Op, error) {
+	rt, ok := snapshot.(RichText)
+	if !ok {
+		return nil, fmt.Errorf("%s: snapshot is %T, not RichText", t.Name(), snapshot)
+	}
+	o, err := asOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	return t.Unit.InvertRich(o, rt)
+}
+
+func (t RichTextType) Blame(snapshot any) (Blame, error) {
+	rt, ok := snapshot.(RichText)
+	if !ok {
+		return nil, fmt.Errorf("%s: snapshot is %T, not RichText", t.Name(), snapshot)
+	}
+	return NewBlame(t.Unit.TargetLen(Operation(rt))), nil
+}
+
+func (t RichTextType) ApplyBlame(b Blame, op Op, author string) (Blame, error) {
+	return applyBlame(t.Unit, b, op, author)
+}
+
+func (t RichTextType) Len(snapshot any) (int, error) {
+	rt, ok := snapshot.(RichText)
+	if !ok {
+		return 0, fmt.Errorf("%s: snapshot is %T, not RichText", t.Name(), snapshot)
+	}
+	return t.Unit.TargetLen(Operation(rt)), nil
+}
+
+func (t RichTextType) TransformIndex(index int, op Op, bias Bias) (int, error) {
+	o, err := asOperation(op)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unit.TransformIndex(index, o, bias), nil
+}
+
+func typeName(base string, u Unit) string {
+	if u == UTF16 {
+		return base
+	}
+	return base + ":" + u.String()
+}
+
+func asOperation(op Op) (Operation, error) {
+	o, ok := op.(Operation)
+	if !ok {
+		return Operation{}, fmt.Errorf("operation is %T, not ot.Operation", op)
+	}
+	return o, nil
+}
+
+func decodeOperation(data []byte) (Op, error) {
+	var op Operation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, err
+	}
+	if err := Validate(op); err != nil {
+		return nil, err
+	}
+	return Normalize(op), nil
+}
+
+func transformOperations(u Unit, a, b Op) (Op, Op, error) {
+	oa, err := asOperation(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	ob, err := asOperation(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u.Transform(oa, ob)
+}
+
+func composeOperations(u Unit, a, b Op) (Op, error) {
+	oa, err := asOperation(a)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := asOperation(b)
+	if err != nil {
+		return nil, err
+	}
+	return u.Compose(oa, ob)
+}
+
+func applyBlame(u Unit, b Blame, op Op, author string) (Blame, error) {
+	o, err := asOperation(op)
+	if err != nil {
+		return nil, err
+	}
+	return u.ApplyBlame(b, o, author)
+}
@@ -0,0 +1,172 @@
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestLookupType(t *testing.T) {
+	for _, name := range []string{"text", "text:codepoint", "rich-text", "rich-text:codepoint", "json", "json:codepoint"} {
+		typ, err := LookupType(name)
+		if err != nil {
+			t.Errorf("LookupType(%q): %v", name, err)
+			continue
+		}
+		if typ.Name() != name {
+			t.Errorf("LookupType(%q).Name() = %q", name, typ.Name())
+		}
+	}
+	if _, err := LookupType("nope"); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for duplicate registration")
+		}
+	}()
+	Register(Text)
+}
+
+func TestTextType_DecodeOp(t *testing.T) {
+	op, err := Text.DecodeOp([]byte(`{"ops":[{"retain":1},{"retain":2},{"insert":"x"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opsEqual(op.(Operation).Ops, []Component{{Retain: 3}, {Insert: "x"}}) {
+		t.Errorf("DecodeOp() = %+v, want normalized operation", op)
+	}
+
+	_, err = Text.DecodeOp([]byte(`{"ops":[{"retain":1,"delete":1}]}`))
+	if !errors.Is(err, ErrMultipleActions) {
+		t.Errorf("DecodeOp() error = %v, want %v", err, ErrMultipleActions)
+	}
+	if _, err := Text.DecodeOp([]byte(`not json`)); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestTextType_Units(t *testing.T) {
+	doc, err := NewTypedDocument(TextType{Unit: CodePoint}, "😀")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(NewInsert(1, "!", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "😀!" {
+		t.Errorf("content = %q, want %q", doc.Content, "😀!")
+	}
+}
+
+func TestRichTextType_Document(t *testing.T) {
+	doc, err := NewTypedDocument(Rich, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(Operation{[]Component{{Insert: "hello"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(Operation{[]Component{{Retain: 2, Attributes: Attributes{"bold": true}}, {Retain: 3}}}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"ops":[{"insert":"he","attributes":{"bold":true}},{"insert":"llo"}]}`
+	if doc.Content != want {
+		t.Errorf("content = %s, want %s", doc.Content, want)
+	}
+	if doc.Version != 2 {
+		t.Errorf("version = %d, want 2", doc.Version)
+	}
+
+	// The serialized content round-trips through Create.
+	reloaded, err := NewTypedDocument(Rich, doc.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := reloaded.Snapshot().(RichText).Text(); text != "hello" {
+		t.Errorf("reloaded text = %q, want %q", text, "hello")
+	}
+}
+
+func TestRichTextType_EmptySnapshot(t *testing.T) {
+	doc, err := NewTypedDocument(Rich, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Rich.Serialize(doc.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != `{"ops":[]}` {
+		t.Errorf("Serialize(empty) = %s", s)
+	}
+}
+
+func TestTypes_WrongValues(t *testing.T) {
+	if _, err := Text.Apply(42, NewInsert(0, "x", 0)); err == nil {
+		t.Error("expected error for non-string snapshot")
+	}
+	if _, err := Text.Apply("", "not an op"); err == nil {
+		t.Error("expected error for non-Operation op")
+	}
+	if _, _, err := Rich.Transform(Operation{}, 1); err == nil {
+		t.Error("expected error for non-Operation op")
+	}
+}
+
+// counterType is a minimal custom type: the snapshot is an integer and
+// operations add to it.
+type counterType struct{}
+
+func (counterType) Name() string { return "test-counter" }
+func (counterType) Create(data string) (any, error) {
+	if data == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(data)
+}
+func (counterType) Serialize(snapshot any) (string, error) { return strconv.Itoa(snapshot.(int)), nil }
+func (counterType) DecodeOp(data []byte) (Op, error) {
+	var n int
+	err := json.Unmarshal(data, &n)
+	return n, err
+}
+func (counterType) Apply(snapshot any, op Op) (any, error) { return snapshot.(int) + op.(int), nil }
+func (counterType) Transform(a, b Op) (Op, Op, error)      { return a, b, nil }
+func (counterType) Compose(a, b Op) (Op, error)            { return a.(int) + b.(int), nil }
+func (counterType) IsNoop(op Op) bool                      { return op.(int) == 0 }
+
+func TestCustomType(t *testing.T) {
+	Register(counterType{})
+	typ, err := LookupType("test-counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := NewTypedDocument(typ, "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &JupiterEngine{}
+	// Two concurrent increments at revision 0.
+	for _, n := range []int{5, 7} {
+		op, err := engine.TransformIncoming(typ, Edit{Op: n}, 0, 0, doc.History)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := doc.Apply(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if doc.Content != "22" || doc.Version != 2 {
+		t.Errorf("got content=%s version=%d, want 22 and 2", doc.Content, doc.Version)
+	}
+	if fmt.Sprint(doc.Snapshot()) != "22" {
+		t.Errorf("snapshot = %v", doc.Snapshot())
+	}
+}
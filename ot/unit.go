@@ -0,0 +1,90 @@
+package ot
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Unit is the unit in which operation offsets and lengths are counted.
+// Retain and Delete counts, and the length of Insert text, are all
+// measured in the same unit.
+//
+// Operations don't record their unit, and the package-level helpers
+// (Transform, Compose, Apply, FromDiff and so on) always count in UTF16,
+// so they misread an operation counted in another unit without an error.
+// Use the Unit's methods of the same names for such operations, or go
+// through the document's Type.
+type Unit int
+
+const (
+	// UTF16 counts UTF-16 code units. This matches JavaScript's
+	// String.length and CodeMirror's indices, so it is the default.
+	UTF16 Unit = iota
+	// CodePoint counts Unicode code points (Go runes).
+	CodePoint
+)
+
+func (u Unit) String() string {
+	switch u {
+	case UTF16:
+		return "utf16"
+	case CodePoint:
+		return "codepoint"
+	}
+	return fmt.Sprintf("Unit(%d)", int(u))
+}
+
+// Len returns the length of s in units.
+func (u Unit) Len(s string) int {
+	if u == CodePoint {
+		return utf8.RuneCountInString(s)
+	}
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+// runeWidth returns the number of UTF-16 code units needed to encode r.
+func runeWidth(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// advance returns the byte offset reached by moving n units forward from
+// byte offset pos in s. It fails if s is too short or if the target falls
+// inside a surrogate pair.
+func (u Unit) advance(s string, pos, n int) (int, error) {
+	for n > 0 {
+		if pos >= len(s) {
+			return 0, fmt.Errorf("offset past end of text")
+		}
+		r, size := utf8.DecodeRuneInString(s[pos:])
+		w := 1
+		if u == UTF16 {
+			w = runeWidth(r)
+		}
+		if w > n {
+			return 0, fmt.Errorf("offset splits surrogate pair of %U", r)
+		}
+		n -= w
+		pos += size
+	}
+	return pos, nil
+}
+
+// slice returns the units [i, j) of s.
+func (u Unit) slice(s string, i, j int) (string, error) {
+	start, err := u.advance(s, 0, i)
+	if err != nil {
+		return "", err
+	}
+	end, err := u.advance(s, start, j-i)
+	if err != nil {
+		return "", err
+	}
+	return s[start:end], nil
+}
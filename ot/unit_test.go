@@ -0,0 +1,141 @@
+package ot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestUnit_LenMatchesJavaScript cross-checks UTF16.Len against the values
+// JavaScript's String.length returns for the same strings.
+func TestUnit_LenMatchesJavaScript(t *testing.T) {
+	tests := []struct {
+		s         string
+		jsLength  int // "s".length in JavaScript
+		codePoint int
+	}{
+		{"", 0, 0},
+		{"hello", 5, 5},
+		{"café", 4, 4},
+		{"cafe\u0301", 5, 5}, // combining acute accent
+		{"日本語", 3, 3},
+		{"😀", 2, 1},
+		{"a😀b", 4, 3},
+		{"👍🏽", 4, 2}, // emoji + skin tone modifier
+		{"\U0001F468\u200D\U0001F469\u200D\U0001F467", 8, 5}, // ZWJ family sequence
+		{"𝄞", 2, 1}, // musical symbol G clef
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := UTF16.Len(tt.s); got != tt.jsLength {
+				t.Errorf("UTF16.Len(%q) = %d, want %d", tt.s, got, tt.jsLength)
+			}
+			if got := CodePoint.Len(tt.s); got != tt.codePoint {
+				t.Errorf("CodePoint.Len(%q) = %d, want %d", tt.s, got, tt.codePoint)
+			}
+		})
+	}
+}
+
+func TestUnit_Apply(t *testing.T) {
+	tests := []struct {
+		name string
+		unit Unit
+		doc  string
+		op   Operation
+		want string
+	}{
+		{"utf16 insert after emoji", UTF16, "😀x", NewInsert(2, "é", 3), "😀éx"},
+		{"utf16 delete emoji", UTF16, "a😀b", NewDelete(1, 2, 4), "ab"},
+		{"codepoint insert after emoji", CodePoint, "😀x", NewInsert(1, "é", 2), "😀éx"},
+		{"codepoint delete emoji", CodePoint, "a😀b", NewDelete(1, 1, 3), "ab"},
+		{"codepoint accented", CodePoint, "café", NewInsert(4, "!", 4), "café!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.unit.Apply(tt.doc, tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnit_ApplySplitSurrogatePair(t *testing.T) {
+	// Retaining 1 UTF-16 unit of "😀" would split its surrogate pair.
+	_, err := UTF16.Apply("😀", Operation{[]Component{{Retain: 1}, {Delete: 1}}})
+	if err == nil {
+		t.Error("expected error for offset inside surrogate pair")
+	}
+}
+
+func TestUnit_TargetLen(t *testing.T) {
+	op := Operation{[]Component{{Retain: 2}, {Insert: "😀é"}}}
+	if got := UTF16.TargetLen(op); got != 5 {
+		t.Errorf("UTF16.TargetLen() = %d, want 5", got)
+	}
+	if got := CodePoint.TargetLen(op); got != 4 {
+		t.Errorf("CodePoint.TargetLen() = %d, want 4", got)
+	}
+}
+
+func TestUnit_Transform(t *testing.T) {
+	for _, u := range []Unit{UTF16, CodePoint} {
+		t.Run(u.String(), func(t *testing.T) {
+			doc := "a😀b"
+			n := u.Len(doc)
+			a := NewInsert(0, "🎉", n)
+			b := NewInsert(n, "ü", n)
+
+			aPrime, bPrime, err := u.Transform(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterA, _ := u.Apply(doc, a)
+			path1, err := u.Apply(afterA, bPrime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterB, _ := u.Apply(doc, b)
+			path2, err := u.Apply(afterB, aPrime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path1 != "🎉a😀bü" || path2 != path1 {
+				t.Errorf("path1=%q path2=%q, want %q", path1, path2, "🎉a😀bü")
+			}
+		})
+	}
+}
+
+// TestUnit_JSONFromBrowser applies an operation as encoded by the browser
+// client, whose counts are JavaScript string lengths.
+func TestUnit_JSONFromBrowser(t *testing.T) {
+	// The client typed "é" after "😀" in "😀x": retain "😀".length == 2.
+	data := `{"ops":[{"retain":2},{"insert":"é"},{"retain":1}]}`
+	var op Operation
+	if err := json.Unmarshal([]byte(data), &op); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Apply("😀x", op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "😀éx" {
+		t.Errorf("got %q, want %q", got, "😀éx")
+	}
+
+	out, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Operation
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.TargetLen() != op.TargetLen() || back.Ops[1].Insert != "é" {
+		t.Errorf("round trip mismatch: %s", out)
+	}
+}
@@ -0,0 +1,87 @@
+package ot
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Reasons a component can be malformed. Validate wraps them in a
+// *ValidationError; test for them with errors.Is.
+var (
+	ErrMultipleActions     = errors.New("component sets more than one of retain, insert and delete")
+	ErrNegativeLength      = errors.New("negative retain or delete")
+	ErrMisplacedAttributes = errors.New("attributes on a component that is not a retain or insert")
+	ErrInvalidText         = errors.New("insert is not valid UTF-8")
+)
+
+// ValidationError reports a malformed component in an operation.
+type ValidationError struct {
+	Index int   // index of the offending component in Operation.Ops
+	Err   error // one of the Err* reasons above
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("component %d: %v", e.Index, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Validate checks that every component of op is well formed: it sets at
+// most one of Retain, Insert and Delete, its counts are not negative, and
+// attributes only accompany a retain or insert. Empty components are
+// allowed; Normalize drops them.
+func Validate(op Operation) error {
+	for i, c := range op.Ops {
+		var err error
+		actions := 0
+		if c.Retain != 0 {
+			actions++
+		}
+		if c.Insert != "" {
+			actions++
+		}
+		if c.Delete != 0 {
+			actions++
+		}
+		switch {
+		case c.Retain < 0 || c.Delete < 0:
+			err = ErrNegativeLength
+		case actions > 1:
+			err = ErrMultipleActions
+		case len(c.Attributes) > 0 && c.Retain == 0 && c.Insert == "":
+			err = ErrMisplacedAttributes
+		case !utf8.ValidString(c.Insert):
+			err = ErrInvalidText
+		}
+		if err != nil {
+			return &ValidationError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// Normalize returns the canonical form of a valid operation: empty
+// components are dropped, inserts are moved ahead of adjacent deletes, and
+// adjacent components of the same type and attributes are merged. The
+// result has the same effect as op.
+func Normalize(op Operation) Operation {
+	var ops, deletes []Component
+	flush := func() {
+		ops = append(ops, deletes...)
+		deletes = deletes[:0]
+	}
+	for _, c := range op.Ops {
+		switch {
+		case c.IsRetain():
+			flush()
+			ops = append(ops, c)
+		case c.IsInsert():
+			ops = append(ops, c)
+		case c.IsDelete():
+			deletes = append(deletes, c)
+		}
+	}
+	flush()
+	return Operation{Ops: compact(ops)}
+}
@@ -0,0 +1,114 @@
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		op        Operation
+		wantErr   error
+		wantIndex int
+	}{
+		{"valid", NewInsert(1, "x", 3), nil, 0},
+		{"empty operation", Operation{}, nil, 0},
+		{"empty component", Operation{[]Component{{}, {Retain: 2}}}, nil, 0},
+		{"formatted retain", Operation{[]Component{{Retain: 2, Attributes: Attributes{"bold": true}}}}, nil, 0},
+		{"retain and insert", Operation{[]Component{{Retain: 1}, {Retain: 2, Insert: "x"}}}, ErrMultipleActions, 1},
+		{"insert and delete", Operation{[]Component{{Insert: "x", Delete: 1}}}, ErrMultipleActions, 0},
+		{"negative retain", Operation{[]Component{{Retain: -1}}}, ErrNegativeLength, 0},
+		{"negative delete", Operation{[]Component{{Retain: 1}, {Delete: -3}}}, ErrNegativeLength, 1},
+		{"attributes on delete", Operation{[]Component{{Delete: 1, Attributes: Attributes{"bold": true}}}}, ErrMisplacedAttributes, 0},
+		{"attributes alone", Operation{[]Component{{Attributes: Attributes{"bold": true}}}}, ErrMisplacedAttributes, 0},
+		{"invalid UTF-8", Operation{[]Component{{Insert: "a\xffb"}}}, ErrInvalidText, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.op)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() = %v, want %v", err, tt.wantErr)
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) || ve.Index != tt.wantIndex {
+				t.Errorf("Validate() = %#v, want index %d", err, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestValidate_DecodedJSON(t *testing.T) {
+	var op Operation
+	if err := json.Unmarshal([]byte(`{"ops":[{"retain":2,"insert":"x"}]}`), &op); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(op); !errors.Is(err, ErrMultipleActions) {
+		t.Errorf("Validate() = %v, want %v", err, ErrMultipleActions)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		op   Operation
+		want []Component
+	}{
+		{
+			"already canonical",
+			NewInsert(1, "x", 3),
+			[]Component{{Retain: 1}, {Insert: "x"}, {Retain: 2}},
+		},
+		{
+			"merge adjacent",
+			Operation{[]Component{{Retain: 1}, {Retain: 2}, {Insert: "a"}, {Insert: "b"}, {Delete: 1}, {Delete: 1}}},
+			[]Component{{Retain: 3}, {Insert: "ab"}, {Delete: 2}},
+		},
+		{
+			"drop empty components",
+			Operation{[]Component{{}, {Retain: 1}, {}, {Retain: 1}, {}}},
+			[]Component{{Retain: 2}},
+		},
+		{
+			"insert before delete",
+			Operation{[]Component{{Retain: 1}, {Delete: 1}, {Insert: "x"}, {Delete: 1}, {Retain: 1}}},
+			[]Component{{Retain: 1}, {Insert: "x"}, {Delete: 2}, {Retain: 1}},
+		},
+		{
+			"different attributes stay apart",
+			Operation{[]Component{{Retain: 1, Attributes: Attributes{"bold": true}}, {Retain: 1}}},
+			[]Component{{Retain: 1, Attributes: Attributes{"bold": true}}, {Retain: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Normalize(tt.op)
+			if !opsEqual(got.Ops, tt.want) {
+				t.Errorf("Normalize() = %+v, want %+v", got.Ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize_PreservesEffect(t *testing.T) {
+	doc := "abcde"
+	op := Operation{[]Component{{}, {Delete: 1}, {Insert: "X"}, {Retain: 1}, {Delete: 1}, {Insert: "Y"}, {Delete: 1}, {Retain: 1}}}
+	want, err := Apply(doc, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Apply(doc, Normalize(op))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("normalized result %q, want %q", got, want)
+	}
+}
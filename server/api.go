@@ -0,0 +1,510 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alimasry/go-collab-editor/ot"
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+// BlameResponse is the body of GET /api/docs/{id}/blame.
+type BlameResponse struct {
+	Revision int      `json:"revision"`
+	Blame    ot.Blame `json:"blame"`
+}
+
+// RevisionResponse is the body of GET /api/docs/{id}/revisions/{n}.
+type RevisionResponse struct {
+	Revision int    `json:"revision"`
+	DocType  string `json:"docType"`
+	Content  string `json:"content"`
+}
+
+// DiffResponse is the body of GET /api/docs/{id}/diff. Op takes the
+// document from revision From to revision To; it is null if they are the
+// same.
+type DiffResponse struct {
+	From int   `json:"from"`
+	To   int   `json:"to"`
+	Op   ot.Op `json:"op"`
+}
+
+// Checkpoint is a named revision of a document, as served by the
+// checkpoint API.
+type Checkpoint struct {
+	Name      string    `json:"name"`
+	Revision  int       `json:"revision"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CheckpointsResponse is the body of GET /api/docs/{id}/checkpoints.
+type CheckpointsResponse struct {
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+// CheckpointRequest is the body of POST /api/docs/{id}/checkpoints.
+// Revision defaults to the document's current revision. The checkpoint's
+// author is the authenticated user.
+type CheckpointRequest struct {
+	Name     string `json:"name"`
+	Revision *int   `json:"revision,omitempty"`
+}
+
+// RestoreResponse is the body of a successful restore: the revision at
+// which the document has the checkpoint's content again.
+type RestoreResponse struct {
+	Revision int `json:"revision"`
+}
+
+// ForkRequest is the body of POST /api/docs/{id}/forks. ID defaults to a
+// random one and Revision to the document's current revision.
+type ForkRequest struct {
+	ID       string `json:"id,omitempty"`
+	Revision *int   `json:"revision,omitempty"`
+}
+
+// Fork describes a forked document, as served by the fork API.
+// MergedRevision is the parent's revision once the fork is merged.
+type Fork struct {
+	ID             string `json:"id"`
+	Parent         string `json:"parent"`
+	Revision       int    `json:"revision"`
+	Merged         bool   `json:"merged"`
+	MergedRevision int    `json:"mergedRevision,omitempty"`
+}
+
+// MergeResponse is the body of a successful merge: the parent's revision
+// with the fork's edits.
+type MergeResponse struct {
+	Parent   string `json:"parent"`
+	Revision int    `json:"revision"`
+}
+
+// maxCheckpointName bounds the length of checkpoint names, in bytes.
+const maxCheckpointName = 200
+
+// registerAPI adds the document API's routes to mux. Documents are read
+// from the hub's store, where sessions record every edit. If the hub has
+// an Auth, requests are authenticated like WebSocket connections.
+func registerAPI(mux *http.ServeMux, hub *Hub) {
+	api := &api{hub: hub, store: hub.store}
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, authenticated(hub, h))
+	}
+	handle("GET /api/docs/{id}/blame", api.blame)
+	handle("GET /api/docs/{id}/revisions/{n}", api.revision)
+	handle("GET /api/docs/{id}/diff", api.diff)
+	handle("GET /api/docs/{id}/checkpoints", api.listCheckpoints)
+	handle("POST /api/docs/{id}/checkpoints", api.createCheckpoint)
+	handle("DELETE /api/docs/{id}/checkpoints/{name}", api.deleteCheckpoint)
+	handle("POST /api/docs/{id}/checkpoints/{name}/restore", api.restoreCheckpoint)
+	handle("POST /api/docs/{id}/forks", api.createFork)
+	handle("GET /api/docs/{id}/fork", api.fork)
+	handle("POST /api/docs/{id}/merge", api.merge)
+}
+
+type api struct {
+	hub   *Hub
+	store store.DocumentStore
+}
+
+// blame serves who wrote each part of a text document. It comes from the
+// document's session, which loads it from the store once and then keeps it
+// up to date as edits are applied.
+func (a *api) blame(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, ok := t.(ot.Blamer); !ok {
+		http.Error(w, "blame is not supported for "+t.Name()+" documents", http.StatusUnprocessableEntity)
+		return
+	}
+	s, err := a.hub.session(info.ID, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, revision, err := s.Blame()
+	if err != nil {
+		log.Printf("api: blame %q: %v", info.ID, err)
+		http.Error(w, "failed to load blame", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, BlameResponse{Revision: revision, Blame: b})
+}
+
+// revision serves the document as it was at revision n.
+func (a *api) revision(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	n, ok := revisionParam(w, r.PathValue("n"), info.Version)
+	if !ok {
+		return
+	}
+	doc, err := loadRevision(r.Context(), a.store, info.ID, n)
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		http.Error(w, "revision "+strconv.Itoa(n)+" can no longer be rebuilt", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("api: rebuild %q at revision %d: %v", info.ID, n, err)
+		http.Error(w, "failed to rebuild revision", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, RevisionResponse{Revision: n, DocType: info.Type, Content: doc.Serialized()})
+}
+
+// diff serves the changes between revisions from and to (by default, the
+// current one).
+func (a *api) diff(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	from, ok := revisionParam(w, r.URL.Query().Get("from"), info.Version)
+	if !ok {
+		return
+	}
+	to := info.Version
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, ok = revisionParam(w, s, info.Version); !ok {
+			return
+		}
+	}
+	if from > to {
+		http.Error(w, "from is after to", http.StatusBadRequest)
+		return
+	}
+	op, err := loadDiff(r.Context(), a.store, info.ID, from, to)
+	if err != nil {
+		log.Printf("api: diff %q from %d to %d: %v", info.ID, from, to, err)
+		http.Error(w, "failed to compute diff", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, DiffResponse{From: from, To: to, Op: op})
+}
+
+// listCheckpoints serves a document's checkpoints, oldest first.
+func (a *api) listCheckpoints(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	cps, err := a.store.ListCheckpoints(r.Context(), info.ID)
+	if err != nil {
+		log.Printf("api: list checkpoints of %q: %v", info.ID, err)
+		http.Error(w, "failed to list checkpoints", http.StatusInternalServerError)
+		return
+	}
+	resp := CheckpointsResponse{Checkpoints: make([]Checkpoint, len(cps))}
+	for i, cp := range cps {
+		resp.Checkpoints[i] = Checkpoint(cp)
+	}
+	writeJSON(w, resp)
+}
+
+// createCheckpoint names a revision of a document.
+func (a *api) createCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if !canEdit(w, r) {
+		return
+	}
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	var req CheckpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxCheckpointName {
+		http.Error(w, "checkpoint name must be 1 to "+strconv.Itoa(maxCheckpointName)+" bytes", http.StatusBadRequest)
+		return
+	}
+	cp := store.Checkpoint{
+		Name:      req.Name,
+		Revision:  info.Version,
+		Author:    author(r),
+		CreatedAt: time.Now(),
+	}
+	if req.Revision != nil {
+		if *req.Revision < 0 || *req.Revision > info.Version {
+			http.Error(w, "no revision "+strconv.Itoa(*req.Revision), http.StatusBadRequest)
+			return
+		}
+		cp.Revision = *req.Revision
+	}
+	err := a.store.SaveCheckpoint(r.Context(), info.ID, cp)
+	if errors.Is(err, store.ErrCheckpointExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("api: save checkpoint of %q: %v", info.ID, err)
+		http.Error(w, "failed to save checkpoint", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, Checkpoint(cp))
+}
+
+// deleteCheckpoint removes a checkpoint. The revision it named is kept.
+func (a *api) deleteCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if !canEdit(w, r) {
+		return
+	}
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	err := a.store.DeleteCheckpoint(r.Context(), info.ID, r.PathValue("name"))
+	if errors.Is(err, store.ErrCheckpointNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("api: delete checkpoint of %q: %v", info.ID, err)
+		http.Error(w, "failed to delete checkpoint", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreCheckpoint brings a document back to a checkpoint's content. The
+// document's session applies the change like any other edit, so connected
+// clients see it live.
+func (a *api) restoreCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if !canEdit(w, r) {
+		return
+	}
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, ok := t.(ot.Inverter); !ok {
+		http.Error(w, "restore is not supported for "+t.Name()+" documents", http.StatusUnprocessableEntity)
+		return
+	}
+	cps, err := a.store.ListCheckpoints(r.Context(), info.ID)
+	if err != nil {
+		log.Printf("api: list checkpoints of %q: %v", info.ID, err)
+		http.Error(w, "failed to list checkpoints", http.StatusInternalServerError)
+		return
+	}
+	name := r.PathValue("name")
+	i := slices.IndexFunc(cps, func(cp store.Checkpoint) bool { return cp.Name == name })
+	if i < 0 {
+		http.Error(w, "no checkpoint "+strconv.Quote(name), http.StatusNotFound)
+		return
+	}
+
+	s, err := a.hub.session(info.ID, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revision, err := s.Restore(cps[i].Revision, author(r))
+	if err != nil {
+		log.Printf("api: restore %q to %q: %v", info.ID, name, err)
+		http.Error(w, "failed to restore checkpoint", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, RestoreResponse{Revision: revision})
+}
+
+// createFork copies a revision of a document into a new document that can
+// be edited independently and later merged back.
+func (a *api) createFork(w http.ResponseWriter, r *http.Request) {
+	if !canEdit(w, r) {
+		return
+	}
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	var req ForkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		req.ID = generateID()
+	}
+	if strings.ContainsRune(req.ID, '/') {
+		http.Error(w, "invalid document ID "+strconv.Quote(req.ID), http.StatusBadRequest)
+		return
+	}
+	revision := info.Version
+	if req.Revision != nil {
+		if *req.Revision < 0 || *req.Revision > info.Version {
+			http.Error(w, "no revision "+strconv.Itoa(*req.Revision), http.StatusBadRequest)
+			return
+		}
+		revision = *req.Revision
+	}
+	err := forkDocument(r.Context(), a.store, info.ID, req.ID, revision)
+	if errors.Is(err, store.ErrDocumentExists) {
+		http.Error(w, "document "+strconv.Quote(req.ID)+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("api: fork %q at %d: %v", info.ID, revision, err)
+		http.Error(w, "failed to fork document", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, Fork{ID: req.ID, Parent: info.ID, Revision: revision})
+}
+
+// fork serves where a forked document came from.
+func (a *api) fork(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	f, ok := a.forkRecord(w, r, info.ID)
+	if !ok {
+		return
+	}
+	writeJSON(w, Fork{
+		ID:             info.ID,
+		Parent:         f.Parent,
+		Revision:       f.Revision,
+		Merged:         f.Merged,
+		MergedRevision: f.MergedRevision,
+	})
+}
+
+// merge applies a fork's edits to its parent, through the parent's session
+// so connected clients see them live.
+func (a *api) merge(w http.ResponseWriter, r *http.Request) {
+	if !canEdit(w, r) {
+		return
+	}
+	info, ok := a.document(w, r)
+	if !ok {
+		return
+	}
+	f, ok := a.forkRecord(w, r, info.ID)
+	if !ok {
+		return
+	}
+	if f.Merged {
+		http.Error(w, errForkMerged.Error(), http.StatusConflict)
+		return
+	}
+	s, err := a.hub.session(f.Parent, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revision, err := s.Merge(info.ID)
+	if errors.Is(err, errForkMerged) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("api: merge %q into %q: %v", info.ID, f.Parent, err)
+		http.Error(w, "failed to merge fork", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, MergeResponse{Parent: f.Parent, Revision: revision})
+}
+
+// forkRecord looks up how document id was forked, replying 404 Not Found
+// if it isn't a fork.
+func (a *api) forkRecord(w http.ResponseWriter, r *http.Request, id string) (*store.Fork, bool) {
+	f, err := a.store.GetFork(r.Context(), id)
+	if err != nil {
+		log.Printf("api: get fork record of %q: %v", id, err)
+		http.Error(w, "failed to load fork", http.StatusInternalServerError)
+		return nil, false
+	}
+	if f == nil {
+		http.Error(w, "document "+strconv.Quote(id)+" is not a fork", http.StatusNotFound)
+		return nil, false
+	}
+	return f, true
+}
+
+// document looks up the document named in the request's path, replying
+// 404 Not Found if there is none. Its Version is its current revision; its
+// Content may be older.
+func (a *api) document(w http.ResponseWriter, r *http.Request) (*store.DocumentInfo, bool) {
+	info, err := a.store.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if info.Version, err = currentVersion(r.Context(), a.store, info); err != nil {
+		log.Printf("api: load history of %q: %v", info.ID, err)
+		http.Error(w, "failed to load document", http.StatusInternalServerError)
+		return nil, false
+	}
+	return info, true
+}
+
+// author returns the user ID to record as the author of r's changes, or ""
+// if the hub has no Auth.
+func author(r *http.Request) string {
+	if user := requestUser(r); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// canEdit reports whether r's user may edit documents, replying 403
+// Forbidden if they may only review them.
+func canEdit(w http.ResponseWriter, r *http.Request) bool {
+	if user := requestUser(r); user != nil && user.Role == RoleReviewer {
+		http.Error(w, "reviewers can't edit documents", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// revisionParam parses a revision of a document at version, replying 400
+// Bad Request if it isn't a number and 404 Not Found if there is no such
+// revision.
+func revisionParam(w http.ResponseWriter, s string, version int) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		http.Error(w, "invalid revision "+strconv.Quote(s), http.StatusBadRequest)
+		return 0, false
+	}
+	if n < 0 || n > version {
+		http.Error(w, "no revision "+s, http.StatusNotFound)
+		return 0, false
+	}
+	return n, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: write response: %v", err)
+	}
+}
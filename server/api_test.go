@@ -0,0 +1,493 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alimasry/go-collab-editor/ot"
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+// getJSON fetches url and decodes its JSON body into v, returning the
+// status code.
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// typeHistory stores a document with one edit per character of text,
+// typed at the end, saving snapshots as a session would.
+func typeHistory(t *testing.T, hub *Hub, id, text string) {
+	t.Helper()
+	hub.store.Create(ctx(), id, "")
+	for i, r := range []rune(text) {
+		op := ot.NewInsert(i, string(r), i)
+		hub.store.AppendOperation(ctx(), id, ot.Edit{Op: op, Author: "alice"}, i+1)
+		if (i+1)%snapshotInterval == 0 {
+			hub.store.SaveSnapshot(ctx(), id, store.Snapshot{Version: i + 1, Content: text[:i+1]})
+			hub.store.UpdateContent(ctx(), id, text[:i+1], i+1)
+		}
+	}
+}
+
+func TestAPI_Revision(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+	text := strings.Repeat("abcdefghij", 25)
+	typeHistory(t, hub, "doc", text)
+
+	for _, n := range []int{0, 1, 99, 100, 101, 250} {
+		var got RevisionResponse
+		if code := getJSON(t, fmt.Sprintf("%s/api/docs/doc/revisions/%d", server.URL, n), &got); code != http.StatusOK {
+			t.Fatalf("revision %d: status %d", n, code)
+		}
+		if got.Revision != n || got.Content != text[:n] || got.DocType != "text" {
+			t.Errorf("revision %d: got %+v, want content %q", n, got, text[:n])
+		}
+	}
+	for path, want := range map[string]int{
+		"/api/docs/doc/revisions/251":      http.StatusNotFound,
+		"/api/docs/doc/revisions/x":        http.StatusBadRequest,
+		"/api/docs/missing/revisions/0":    http.StatusNotFound,
+		"/api/docs/doc/diff?from=5&to=4":   http.StatusBadRequest,
+		"/api/docs/doc/diff?from=5&to=300": http.StatusNotFound,
+	} {
+		if code := getJSON(t, server.URL+path, nil); code != want {
+			t.Errorf("%s: status %d, want %d", path, code, want)
+		}
+	}
+}
+
+// legacyStore is a store written before snapshots were kept: it has only
+// the snapshots saved since.
+type legacyStore struct {
+	*store.MemoryStore
+	snapshots map[string][]store.Snapshot
+}
+
+func (s *legacyStore) SaveSnapshot(_ context.Context, id string, snap store.Snapshot) error {
+	s.snapshots[id] = append(s.snapshots[id], snap)
+	return nil
+}
+
+func (s *legacyStore) GetSnapshot(_ context.Context, id string, version int) (*store.Snapshot, error) {
+	var latest *store.Snapshot
+	for i, snap := range s.snapshots[id] {
+		if snap.Version <= version && (latest == nil || snap.Version > latest.Version) {
+			latest = &s.snapshots[id][i]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%w: %q", store.ErrSnapshotNotFound, id)
+	}
+	return latest, nil
+}
+
+func TestAPI_RevisionWithoutSnapshots(t *testing.T) {
+	st := &legacyStore{MemoryStore: store.NewMemoryStore(), snapshots: make(map[string][]store.Snapshot)}
+	hub := NewHub(st, &ot.JupiterEngine{})
+	go hub.Run()
+	server := httptest.NewServer(NewHandler(hub))
+	defer server.Close()
+
+	// Content was saved with every edit. One document was created empty,
+	// the other with content.
+	for id, initial := range map[string]string{"empty": "", "seeded": "he"} {
+		st.Create(ctx(), id, initial)
+		for i := len(initial); i < 5; i++ {
+			op := ot.NewInsert(i, "hello"[i:i+1], i)
+			st.AppendOperation(ctx(), id, ot.Edit{Op: op}, i-len(initial)+1)
+			st.UpdateContent(ctx(), id, "hello"[:i+1], i-len(initial)+1)
+		}
+	}
+
+	for path, want := range map[string]string{
+		"/api/docs/empty/revisions/0":  "",
+		"/api/docs/empty/revisions/2":  "he",
+		"/api/docs/empty/revisions/5":  "hello",
+		"/api/docs/seeded/revisions/3": "hello",
+	} {
+		var got RevisionResponse
+		if code := getJSON(t, server.URL+path, &got); code != http.StatusOK || got.Content != want {
+			t.Errorf("%s: status %d, content %q, want %q", path, code, got.Content, want)
+		}
+	}
+	// The seeded document's edits don't say what it was created with.
+	if code := getJSON(t, server.URL+"/api/docs/seeded/revisions/0", nil); code != http.StatusNotFound {
+		t.Errorf("seeded revision 0: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAPI_Diff(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+	typeHistory(t, hub, "doc", "hello")
+
+	var got struct {
+		From, To int
+		Op       ot.Operation
+	}
+	if code := getJSON(t, server.URL+"/api/docs/doc/diff?from=2", &got); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	want := ot.Operation{Ops: []ot.Component{{Retain: 2}, {Insert: "llo"}}}
+	if got.From != 2 || got.To != 5 || !reflect.DeepEqual(got.Op, want) {
+		t.Errorf("got %+v, want 2 to 5 with %+v", got, want)
+	}
+	if code := getJSON(t, server.URL+"/api/docs/doc/diff?from=3&to=3", &got); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+}
+
+func TestAPI_Blame(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+
+	hub.store.Create(ctx(), "doc", "")
+	hub.store.AppendOperation(ctx(), "doc", ot.Edit{Op: ot.NewInsert(0, "hi", 0), Author: "alice"}, 1)
+	hub.store.AppendOperation(ctx(), "doc", ot.Edit{Op: ot.NewInsert(2, "!", 2), Author: "bob"}, 2)
+
+	resp, err := http.Get(server.URL + "/api/docs/doc/blame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got BlameResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := BlameResponse{Revision: 2, Blame: ot.Blame{{Author: "alice", Len: 2}, {Author: "bob", Len: 1}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// The document's session keeps the blame up to date with live edits.
+	conn := wsConnect(t, server)
+	defer conn.Close()
+	conn.WriteJSON(ClientMessage{Type: MsgJoin, DocID: "doc"})
+	doc := readWsMsg(t, conn)
+	conn.WriteJSON(ClientMessage{Type: MsgOp, DocID: "doc", Revision: 2, Op: rawOp(ot.NewInsert(3, "?", 3))})
+	readWsMsg(t, conn) // ack
+	if code := getJSON(t, server.URL+"/api/docs/doc/blame", &got); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	want = BlameResponse{Revision: 3, Blame: append(want.Blame, ot.Span{Author: doc.UserID, Len: 1})}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after edit: got %+v, want %+v", got, want)
+	}
+
+	hub.store.CreateTyped(ctx(), "data", ot.JSON.Name(), "{}")
+	if code := getJSON(t, server.URL+"/api/docs/data/blame", nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("JSON doc: status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	resp, err = http.Get(server.URL + "/api/docs/missing/blame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing doc: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+// doJSON sends a request with body encoded as JSON, unless it is nil, and
+// decodes the response into v if v is not nil. It returns the status code.
+func doJSON(t *testing.T, method, url string, body, v any) int {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPI_Checkpoints(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+	typeHistory(t, hub, "doc", "hello")
+	url := server.URL + "/api/docs/doc/checkpoints"
+
+	two := 2
+	var cp Checkpoint
+	if code := doJSON(t, "POST", url, CheckpointRequest{Name: " draft ", Revision: &two}, &cp); code != http.StatusCreated {
+		t.Fatalf("create: status %d", code)
+	}
+	if cp.Name != "draft" || cp.Revision != 2 || cp.Author != "" || cp.CreatedAt.IsZero() {
+		t.Errorf("created %+v", cp)
+	}
+	if code := doJSON(t, "POST", url, CheckpointRequest{Name: "final"}, &cp); code != http.StatusCreated {
+		t.Fatalf("create: status %d", code)
+	}
+	if cp.Revision != 5 {
+		t.Errorf("revision = %d, want the current revision 5", cp.Revision)
+	}
+
+	six := 6
+	for name, req := range map[string]CheckpointRequest{
+		"duplicate":       {Name: "draft"},
+		"empty name":      {Name: "  "},
+		"future revision": {Name: "next", Revision: &six},
+	} {
+		want := http.StatusBadRequest
+		if name == "duplicate" {
+			want = http.StatusConflict
+		}
+		if code := doJSON(t, "POST", url, req, nil); code != want {
+			t.Errorf("%s: status %d, want %d", name, code, want)
+		}
+	}
+
+	if code := doJSON(t, "DELETE", url+"/draft", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", code)
+	}
+	if code := doJSON(t, "DELETE", url+"/draft", nil, nil); code != http.StatusNotFound {
+		t.Errorf("delete again: status %d, want 404", code)
+	}
+
+	var list CheckpointsResponse
+	if code := doJSON(t, "GET", url, nil, &list); code != http.StatusOK {
+		t.Fatalf("list: status %d", code)
+	}
+	if len(list.Checkpoints) != 1 || list.Checkpoints[0].Name != "final" {
+		t.Errorf("checkpoints = %+v, want just final", list.Checkpoints)
+	}
+
+	// Restoring starts a session if none is active. The document already
+	// has the checkpoint's content, so nothing changes.
+	var restored RestoreResponse
+	if code := doJSON(t, "POST", url+"/final/restore", nil, &restored); code != http.StatusOK || restored.Revision != 5 {
+		t.Errorf("restore: status %d, revision %d, want 200 at 5", code, restored.Revision)
+	}
+}
+
+func TestAPI_Auth(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+	typeHistory(t, hub, "doc", "hello")
+	auth := TokenAuth{Secret: []byte("secret")}
+	hub.Auth = auth
+	url := server.URL + "/api/docs/doc"
+
+	// Requests without a valid token are turned away.
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/blame"},
+		{"GET", "/revisions/2"},
+		{"GET", "/checkpoints"},
+		{"POST", "/checkpoints"},
+		{"POST", "/forks"},
+		{"POST", "/merge"},
+	} {
+		if code := doJSON(t, req.method, url+req.path, nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: status %d, want 401", req.method, req.path, code)
+		}
+	}
+	if code := doJSON(t, "GET", url+"/blame?token=forged", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("forged token: status %d, want 401", code)
+	}
+
+	sign := func(user Identity) string {
+		token, err := auth.Sign(user, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "?token=" + token
+	}
+	alice := sign(Identity{ID: "alice"})
+	bob := sign(Identity{ID: "bob", Role: RoleReviewer})
+
+	// Checkpoints and restores are by the token's user.
+	var cp Checkpoint
+	if code := doJSON(t, "POST", url+"/checkpoints"+alice, CheckpointRequest{Name: "draft", Revision: new(int)}, &cp); code != http.StatusCreated {
+		t.Fatalf("create: status %d", code)
+	}
+	if cp.Author != "alice" {
+		t.Errorf("author = %q, want alice", cp.Author)
+	}
+	if code := doJSON(t, "POST", url+"/checkpoints/draft/restore"+alice, nil, nil); code != http.StatusOK {
+		t.Fatalf("restore: status %d", code)
+	}
+	edits, err := hub.store.GetOperations(ctx(), "doc", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].Author != "alice" {
+		t.Errorf("restore edits = %+v, want one by alice", edits)
+	}
+
+	// Reviewers can read documents but not change them.
+	if code := doJSON(t, "GET", url+"/blame"+bob, nil, nil); code != http.StatusOK {
+		t.Errorf("blame as reviewer: status %d, want 200", code)
+	}
+	if code := doJSON(t, "POST", url+"/checkpoints"+bob, CheckpointRequest{Name: "bob's"}, nil); code != http.StatusForbidden {
+		t.Errorf("create checkpoint as reviewer: status %d, want 403", code)
+	}
+	if code := doJSON(t, "DELETE", url+"/checkpoints/draft"+bob, nil, nil); code != http.StatusForbidden {
+		t.Errorf("delete checkpoint as reviewer: status %d, want 403", code)
+	}
+	if code := doJSON(t, "POST", url+"/forks"+bob, ForkRequest{}, nil); code != http.StatusForbidden {
+		t.Errorf("fork as reviewer: status %d, want 403", code)
+	}
+	if code := doJSON(t, "POST", url+"/checkpoints/draft/restore"+bob, nil, nil); code != http.StatusForbidden {
+		t.Errorf("restore as reviewer: status %d, want 403", code)
+	}
+	if code := doJSON(t, "POST", url+"/merge"+bob, nil, nil); code != http.StatusForbidden {
+		t.Errorf("merge as reviewer: status %d, want 403", code)
+	}
+}
+
+func TestAPI_RestoreCheckpoint(t *testing.T) {
+	server, _ := setupTestServer(t)
+	defer server.Close()
+	url := server.URL + "/api/docs/doc/checkpoints"
+
+	conn := wsConnect(t, server)
+	defer conn.Close()
+	conn.WriteJSON(ClientMessage{Type: MsgJoin, DocID: "doc"})
+	readWsMsg(t, conn) // doc
+	conn.WriteJSON(ClientMessage{Type: MsgOp, DocID: "doc", Revision: 0, Op: rawOp(ot.NewInsert(0, "v1", 0))})
+	readWsMsg(t, conn) // ack
+
+	if code := doJSON(t, "POST", url, CheckpointRequest{Name: "v1 sent to legal"}, nil); code != http.StatusCreated {
+		t.Fatalf("create: status %d", code)
+	}
+	conn.WriteJSON(ClientMessage{Type: MsgOp, DocID: "doc", Revision: 1, Op: rawOp(ot.NewInsert(0, "v2 ", 2))})
+	readWsMsg(t, conn) // ack
+
+	var got RestoreResponse
+	if code := doJSON(t, "POST", url+"/v1%20sent%20to%20legal/restore", nil, &got); code != http.StatusOK {
+		t.Fatalf("restore: status %d", code)
+	}
+	if got.Revision != 3 {
+		t.Errorf("revision = %d, want 3", got.Revision)
+	}
+
+	// The connected client sees the restore live.
+	msg := readWsMsg(t, conn)
+	if msg.Type != MsgOp || msg.Revision != 3 {
+		t.Fatalf("got type=%q revision=%d, want op at 3", msg.Type, msg.Revision)
+	}
+	var rev RevisionResponse
+	getJSON(t, server.URL+"/api/docs/doc/revisions/3", &rev)
+	if rev.Content != "v1" {
+		t.Errorf("content = %q, want %q", rev.Content, "v1")
+	}
+
+	if code := doJSON(t, "POST", url+"/missing/restore", nil, nil); code != http.StatusNotFound {
+		t.Errorf("missing checkpoint: status %d, want 404", code)
+	}
+}
+
+func TestAPI_ForkAndMerge(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+	typeHistory(t, hub, "doc", "hello")
+
+	three := 3
+	var fork Fork
+	if code := doJSON(t, "POST", server.URL+"/api/docs/doc/forks", ForkRequest{ID: "draft", Revision: &three}, &fork); code != http.StatusCreated {
+		t.Fatalf("fork: status %d", code)
+	}
+	if fork != (Fork{ID: "draft", Parent: "doc", Revision: 3}) {
+		t.Errorf("fork = %+v", fork)
+	}
+	var rev RevisionResponse
+	getJSON(t, server.URL+"/api/docs/draft/revisions/0", &rev)
+	if rev.Content != "hel" {
+		t.Errorf("fork content = %q, want %q", rev.Content, "hel")
+	}
+	for name, req := range map[string]ForkRequest{
+		"existing ID":     {ID: "draft"},
+		"future revision": {ID: "other", Revision: new(int)},
+	} {
+		if name == "future revision" {
+			*req.Revision = 6
+		}
+		want := http.StatusBadRequest
+		if name == "existing ID" {
+			want = http.StatusConflict
+		}
+		if code := doJSON(t, "POST", server.URL+"/api/docs/doc/forks", req, nil); code != want {
+			t.Errorf("%s: status %d, want %d", name, code, want)
+		}
+	}
+	if code := getJSON(t, server.URL+"/api/docs/doc/fork", nil); code != http.StatusNotFound {
+		t.Errorf("fork record of a non-fork: status %d, want 404", code)
+	}
+
+	// Edit the fork, with a client watching the parent.
+	conn := wsConnect(t, server)
+	defer conn.Close()
+	conn.WriteJSON(ClientMessage{Type: MsgJoin, DocID: "draft"})
+	readWsMsg(t, conn) // doc
+	conn.WriteJSON(ClientMessage{Type: MsgOp, DocID: "draft", Revision: 0, Op: rawOp(ot.NewInsert(3, "p", 3))})
+	readWsMsg(t, conn) // ack
+	watcher := wsConnect(t, server)
+	defer watcher.Close()
+	watcher.WriteJSON(ClientMessage{Type: MsgJoin, DocID: "doc"})
+	readWsMsg(t, watcher) // doc
+
+	var merged MergeResponse
+	if code := doJSON(t, "POST", server.URL+"/api/docs/draft/merge", nil, &merged); code != http.StatusOK {
+		t.Fatalf("merge: status %d", code)
+	}
+	if merged != (MergeResponse{Parent: "doc", Revision: 6}) {
+		t.Errorf("merge = %+v", merged)
+	}
+	if msg := readWsMsg(t, watcher); msg.Type != MsgOp || msg.Revision != 6 {
+		t.Errorf("watcher got type=%q revision=%d, want op at 6", msg.Type, msg.Revision)
+	}
+	getJSON(t, server.URL+"/api/docs/doc/revisions/6", &rev)
+	// The parent's "lo" was typed where the fork's "p" went; the parent's
+	// text goes first.
+	if rev.Content != "hellop" {
+		t.Errorf("merged content = %q, want %q", rev.Content, "hellop")
+	}
+
+	if code := getJSON(t, server.URL+"/api/docs/draft/fork", &fork); code != http.StatusOK {
+		t.Fatalf("fork record: status %d", code)
+	}
+	if !fork.Merged || fork.MergedRevision != 6 {
+		t.Errorf("fork = %+v, want merged at 6", fork)
+	}
+	if code := doJSON(t, "POST", server.URL+"/api/docs/draft/merge", nil, nil); code != http.StatusConflict {
+		t.Errorf("second merge: status %d, want 409", code)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Identity is an authenticated user.
+type Identity struct {
+	ID    string // stable across connections
+	Name  string // display name; empty means a random one
+	Color string // hex color for presence indicators; empty means a random one
+	// Role is the most privileged role the user may join documents with.
+	// Empty means RoleEditor.
+	Role string
+}
+
+// Authenticator verifies the credentials of a WebSocket handshake before
+// the connection is upgraded.
+type Authenticator interface {
+	// Authenticate returns the user who made r, or an error if r's
+	// credentials are missing or invalid.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// TokenAuth authenticates requests carrying a JSON Web Token signed with
+// HMAC-SHA256 ("HS256") using Secret. The token is sent as an
+// "Authorization: Bearer" header or, since browsers can't set headers on
+// WebSocket handshakes, a "token" query parameter.
+//
+// The token's "sub" claim is the user's ID, and its optional "name" and
+// "color" claims their display name and color. Its optional "role" claim
+// is the most privileged role the user may join documents with, editor if
+// missing. A token with an "exp" claim is rejected once that time has
+// passed.
+type TokenAuth struct {
+	Secret []byte
+}
+
+// tokenHeader is the only JOSE header TokenAuth signs and accepts.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Name      string `json:"name,omitempty"`
+	Color     string `json:"color,omitempty"`
+	Role      string `json:"role,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+func (a TokenAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, t, ok := strings.Cut(h, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, errors.New("authorization is not a bearer token")
+		}
+		token = t
+	}
+	if token == "" {
+		return nil, errors.New("no token")
+	}
+	return a.Verify(token)
+}
+
+// Verify checks token's signature and expiry and returns the user it
+// identifies.
+func (a TokenAuth) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	if h.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, a.sign(parts[0]+"."+parts[1])) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	switch claims.Role {
+	case "", RoleEditor, RoleReviewer:
+	default:
+		return nil, fmt.Errorf("unknown role %q", claims.Role)
+	}
+	return &Identity{ID: claims.Subject, Name: claims.Name, Color: claims.Color, Role: claims.Role}, nil
+}
+
+// Sign returns a token identifying user that expires after ttl, or never if
+// ttl is zero.
+func (a TokenAuth) Sign(user Identity, ttl time.Duration) (string, error) {
+	claims := tokenClaims{Subject: user.ID, Name: user.Name, Color: user.Color, Role: user.Role}
+	if ttl != 0 {
+		claims.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(a.sign(unsigned)), nil
+}
+
+func (a TokenAuth) sign(unsigned string) []byte {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
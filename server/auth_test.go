@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenAuth(t *testing.T) {
+	auth := TokenAuth{Secret: []byte("secret")}
+	token, err := auth.Sign(Identity{ID: "alice", Name: "Alice", Color: "#ff0000", Role: RoleReviewer}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token can be sent as a header or in the query.
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	user, err := auth.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *user != (Identity{ID: "alice", Name: "Alice", Color: "#ff0000", Role: RoleReviewer}) {
+		t.Errorf("user = %+v", *user)
+	}
+	if _, err := auth.Authenticate(httptest.NewRequest("GET", "/ws?token="+token, nil)); err != nil {
+		t.Errorf("query token: %v", err)
+	}
+
+	expired, _ := auth.Sign(Identity{ID: "alice"}, -time.Minute)
+	forever, _ := auth.Sign(Identity{ID: "alice"}, 0)
+	other, _ := TokenAuth{Secret: []byte("other")}.Sign(Identity{ID: "alice"}, time.Hour)
+	noSubject, _ := auth.Sign(Identity{}, time.Hour)
+	badRole, _ := auth.Sign(Identity{ID: "alice", Role: "owner"}, time.Hour)
+	parts := strings.Split(token, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	if _, err := auth.Verify(forever); err != nil {
+		t.Errorf("token without expiry: %v", err)
+	}
+	for name, token := range map[string]string{
+		"expired":    expired,
+		"wrong key":  other,
+		"no subject": noSubject,
+		"bad role":   badRole,
+		"alg none":   unsigned,
+		"tampered":   parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2],
+		"malformed":  "not-a-token",
+		"empty":      "",
+	} {
+		if _, err := auth.Verify(token); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	r = httptest.NewRequest("GET", "/ws", nil)
+	if _, err := auth.Authenticate(r); err == nil {
+		t.Error("expected error for a request without a token")
+	}
+	r.Header.Set("Authorization", "Basic "+token)
+	if _, err := auth.Authenticate(r); err == nil {
+		t.Error("expected error for a non-bearer authorization")
+	}
+}
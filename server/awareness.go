@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// awarenessTimeout is how long a client's awareness state lasts
+	// without being sent again.
+	awarenessTimeout = 30 * time.Second
+	// maxAwarenessKeys bounds the keys in a client's awareness state, and
+	// maxAwarenessSize the size of each value, in bytes.
+	maxAwarenessKeys = 32
+	maxAwarenessSize = 4096
+)
+
+// AwarenessState is a client's ephemeral state: whatever its frontend
+// shares with collaborators, such as its viewport or whether its user is
+// typing. The server only stores and relays it, and never persists it.
+type AwarenessState struct {
+	ClientID string                     `json:"clientId"`
+	State    map[string]json.RawMessage `json:"state"`
+}
+
+// awareness is a client's awareness state and when it last sent any.
+type awareness struct {
+	state   map[string]json.RawMessage
+	updated time.Time
+}
+
+// handleAwareness merges the keys a client sent into its awareness state,
+// removing those whose value is null, and sends the result to every other
+// client if it changed. Sending renews the state even if nothing changed.
+func (s *Session) handleAwareness(c *Client, update map[string]json.RawMessage) {
+	a := s.awareness[c.ID]
+	if a == nil {
+		a = &awareness{state: make(map[string]json.RawMessage)}
+	}
+	state := make(map[string]json.RawMessage, len(a.state))
+	for k, v := range a.state {
+		state[k] = v
+	}
+	changed := false
+	for k, v := range update {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, v); err != nil {
+			c.sendError(fmt.Sprintf("invalid awareness value for %q", k))
+			return
+		}
+		if compact.Len() > maxAwarenessSize {
+			c.sendError(fmt.Sprintf("awareness value for %q is larger than %d bytes", k, maxAwarenessSize))
+			return
+		}
+		old, ok := state[k]
+		if compact.String() == "null" {
+			delete(state, k)
+			changed = changed || ok
+			continue
+		}
+		state[k] = compact.Bytes()
+		changed = changed || !bytes.Equal(old, compact.Bytes())
+	}
+	if len(state) > maxAwarenessKeys {
+		c.sendError(fmt.Sprintf("awareness state has more than %d keys", maxAwarenessKeys))
+		return
+	}
+
+	a.state, a.updated = state, time.Now()
+	if len(state) == 0 {
+		delete(s.awareness, c.ID)
+	} else {
+		s.awareness[c.ID] = a
+	}
+	if changed {
+		s.broadcastAwareness(c.ID, state)
+	}
+}
+
+// expireAwareness clears the state of clients that haven't sent any since
+// awarenessTimeout before now, as if they had cleared it themselves.
+func (s *Session) expireAwareness(now time.Time) {
+	for id, a := range s.awareness {
+		if now.Sub(a.updated) >= s.awarenessTimeout {
+			delete(s.awareness, id)
+			s.broadcastAwareness(id, nil)
+		}
+	}
+}
+
+// broadcastAwareness sends client id's awareness state to every other
+// client.
+func (s *Session) broadcastAwareness(id string, state map[string]json.RawMessage) {
+	for c := range s.clients {
+		if c.ID != id {
+			c.sendMsg(ServerMessage{
+				Type:     MsgAwareness,
+				DocID:    s.docID,
+				ClientID: id,
+				State:    state,
+			})
+		}
+	}
+}
+
+// awarenessList returns every client's awareness state except skip's,
+// ordered by client ID.
+func (s *Session) awarenessList(skip *Client) []AwarenessState {
+	var states []AwarenessState
+	for id, a := range s.awareness {
+		if id != skip.ID {
+			states = append(states, AwarenessState{ClientID: id, State: a.state})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ClientID < states[j].ClientID })
+	return states
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alimasry/go-collab-editor/ot"
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+// loadBlame computes a document's blame from its stored history, returning
+// it with the revision it is for. Text in the document before its first
+// stored edit has no known author.
+func loadBlame(ctx context.Context, st store.DocumentStore, id string) (ot.Blame, int, error) {
+	info, err := st.Get(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		return nil, 0, err
+	}
+	bt, ok := t.(ot.Blamer)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s documents have no blame", t.Name())
+	}
+	edits, err := st.GetOperations(ctx, id, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(edits) == 0 {
+		snapshot, err := t.Create(info.Content)
+		if err != nil {
+			return nil, 0, err
+		}
+		b, err := bt.Blame(snapshot)
+		return b, info.Version, err
+	}
+	b, err := ot.BlameHistory(t, edits)
+	return b, len(edits), err
+}
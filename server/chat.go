@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+const (
+	// chatHistorySize is how many recent chat messages a session keeps to
+	// send to clients that join.
+	chatHistorySize = store.ChatHistorySize
+	// maxChatLength bounds the length of a chat message, in bytes.
+	maxChatLength = 4000
+)
+
+// ChatMessage is a message in a document's chat.
+type ChatMessage struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author"` // user ID of the client that sent it
+	Name      string    `json:"name"`   // its display name
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// handleChat sends a client's chat message to every client, including its
+// author, and records it. Any client may chat, including reviewers.
+func (s *Session) handleChat(c *Client, body string) {
+	switch {
+	case body == "":
+		c.sendError("chat message is empty")
+		return
+	case len(body) > maxChatLength:
+		c.sendError(fmt.Sprintf("chat message is longer than %d bytes", maxChatLength))
+		return
+	}
+
+	m := ChatMessage{ID: generateID(), Author: c.UserID, Name: c.Name, Body: body, CreatedAt: time.Now()}
+	s.chat = append(s.chat, m)
+	if len(s.chat) > chatHistorySize {
+		s.chat = s.chat[len(s.chat)-chatHistorySize:]
+	}
+	if cs, ok := s.store.(store.ChatStore); ok {
+		err := cs.AppendChat(context.Background(), s.docID, store.ChatMessage{
+			ID:        m.ID,
+			Author:    m.Author,
+			Name:      m.Name,
+			Body:      m.Body,
+			CreatedAt: m.CreatedAt,
+		})
+		if err != nil {
+			log.Printf("session %s: failed to save chat message: %v", s.docID, err)
+		}
+	}
+	for other := range s.clients {
+		other.sendMsg(ServerMessage{
+			Type:  MsgChat,
+			DocID: s.docID,
+			Chat:  &m,
+		})
+	}
+}
+
+// loadChat returns a document's recent chat history, if its store keeps
+// any.
+func loadChat(ctx context.Context, st store.DocumentStore, docID string) ([]ChatMessage, error) {
+	cs, ok := st.(store.ChatStore)
+	if !ok {
+		return nil, nil
+	}
+	stored, err := cs.RecentChat(ctx, docID, chatHistorySize)
+	if err != nil {
+		return nil, err
+	}
+	chat := make([]ChatMessage, len(stored))
+	for i, m := range stored {
+		chat[i] = ChatMessage{ID: m.ID, Author: m.Author, Name: m.Name, Body: m.Body, CreatedAt: m.CreatedAt}
+	}
+	return chat, nil
+}
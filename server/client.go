@@ -1,6 +1,7 @@
 package server
 
 import (
+	crand "crypto/rand"
 	"encoding/json"
 	"log"
 	"math/rand"
@@ -19,17 +20,29 @@ const (
 
 // Client represents a single WebSocket connection.
 type Client struct {
-	ID    string
-	Name  string
-	Color string
+	// ID identifies the connection; it is the site of the client's edits.
+	ID string
+	// UserID identifies the user, and is the author of the client's edits,
+	// comments and chat. It is the user's authenticated ID, or ID if the
+	// hub has no Auth.
+	UserID string
+	Name   string
+	Color  string
+
+	// grant is the most privileged role the client may join a document
+	// with, its user's Role. Empty means RoleEditor.
+	grant string
 
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
 
-	// The session this client is currently in (nil if not joined).
+	// The session this client is currently in (nil if not joined), its
+	// role there, and the revision it asked for the edits since, if any.
 	mu      sync.Mutex
 	session *Session
+	role    string
+	since   *int
 }
 
 var (
@@ -38,9 +51,11 @@ var (
 	colors     = []string{"#e74c3c", "#3498db", "#2ecc71", "#f39c12", "#9b59b6", "#1abc9c", "#e67e22", "#00bcd4", "#ff5722", "#8bc34a"}
 )
 
-func newClient(hub *Hub, conn *websocket.Conn) *Client {
+// newClient creates a client for a connection made by user, or by an
+// anonymous user with a random name if user is nil.
+func newClient(hub *Hub, conn *websocket.Conn, user *Identity) *Client {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return &Client{
+	c := &Client{
 		ID:    generateID(),
 		Name:  adjectives[r.Intn(len(adjectives))] + " " + animals[r.Intn(len(animals))],
 		Color: colors[r.Intn(len(colors))],
@@ -48,14 +63,37 @@ func newClient(hub *Hub, conn *websocket.Conn) *Client {
 		conn:  conn,
 		send:  make(chan []byte, 256),
 	}
+	c.UserID = c.ID
+	if user != nil {
+		c.UserID = user.ID
+		c.grant = user.Role
+		if user.Name != "" {
+			c.Name = user.Name
+		}
+		if user.Color != "" {
+			c.Color = user.Color
+		}
+	}
+	return c
 }
 
+// generateID returns a random 8-character ID for a client, or for a
+// suggestion, thread, chat message or fork. IDs are read from crypto/rand,
+// so that ones made at the same moment don't collide and overwrite each
+// other when stored.
 func generateID() string {
 	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	b := make([]byte, 8)
-	for i := range b {
-		b[i] = chars[r.Intn(len(chars))]
+	// Bytes from 252 up are skipped so that every character is as likely.
+	const limit = 256 - 256%len(chars)
+	b := make([]byte, 0, 8)
+	var buf [16]byte
+	for len(b) < cap(b) {
+		crand.Read(buf[:])
+		for _, x := range buf {
+			if int(x) < limit && len(b) < cap(b) {
+				b = append(b, chars[int(x)%len(chars)])
+			}
+		}
 	}
 	return string(b)
 }
@@ -96,8 +134,8 @@ func (c *Client) ReadPump() {
 
 		switch msg.Type {
 		case MsgJoin:
-			c.hub.joinDoc <- joinRequest{client: c, docID: msg.DocID}
-		case MsgOp:
+			c.hub.joinDoc <- joinRequest{client: c, docID: msg.DocID, docType: msg.DocType, role: msg.Role, since: msg.Since}
+		case MsgOp, MsgUndo, MsgRedo, MsgBlame, MsgSuggest, MsgAccept, MsgReject, MsgComment, MsgReply, MsgResolve, MsgCursor, MsgAwareness, MsgChat:
 			c.mu.Lock()
 			s := c.session
 			c.mu.Unlock()
@@ -152,6 +190,22 @@ func (c *Client) sendError(message string) {
 	c.sendMsg(ServerMessage{Type: MsgError, Message: message})
 }
 
+func (c *Client) sendErrorCode(code, message string) {
+	c.sendMsg(ServerMessage{Type: MsgError, Message: message, Code: code})
+}
+
 func (c *Client) Info() ClientInfo {
-	return ClientInfo{ID: c.ID, Name: c.Name, Color: c.Color}
+	return ClientInfo{ID: c.ID, UserID: c.UserID, Name: c.Name, Color: c.Color, Role: c.Role()}
+}
+
+// Role returns the client's role in the document it joined.
+func (c *Client) Role() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.role
+}
+
+// canEdit reports whether the client may edit the document directly.
+func (c *Client) canEdit() bool {
+	return c.Role() != RoleReviewer
 }
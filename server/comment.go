@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alimasry/go-collab-editor/ot"
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+const (
+	// maxThreads bounds how many comment threads a document can have.
+	maxThreads = 1000
+	// maxCommentLength bounds the length of a comment, in bytes.
+	maxCommentLength = 10000
+)
+
+// Thread is a comment thread attached to a range of the document's text.
+// Its Anchor applies to the document at the revision of the message that
+// carries it.
+type Thread struct {
+	ID        string    `json:"id"`
+	Anchor    ot.Range  `json:"anchor"`
+	Resolved  bool      `json:"resolved"`
+	Comments  []Comment `json:"comments"` // oldest first
+	CreatedAt time.Time `json:"createdAt"`
+
+	saved int // revision at which the anchor was last stored
+}
+
+// Comment is one message in a Thread.
+type Comment struct {
+	Author    string    `json:"author"` // user ID of the client that wrote it
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// handleComment starts a thread on the range a client sent, made at the
+// revision it sent. Any client may comment, including reviewers.
+func (s *Session) handleComment(om opMessage) {
+	p, ok := s.doc.Type.(ot.Positioner)
+	if !ok {
+		om.client.sendError("comments are not supported for " + s.doc.Type.Name() + " documents")
+		return
+	}
+	if len(s.threads) >= maxThreads {
+		om.client.sendError("too many comment threads")
+		return
+	}
+	if !s.validComment(om.client, om.msg.Body) {
+		return
+	}
+	if om.msg.Range == nil {
+		om.client.sendError("comment has no range")
+		return
+	}
+	if om.msg.Revision < s.doc.Base {
+		om.client.sendErrorCode(CodeResync, ot.ErrRevisionTooOld.Error())
+		return
+	}
+	if om.msg.Revision > s.doc.Version {
+		om.client.sendError(fmt.Sprintf("no revision %d (document at %d)", om.msg.Revision, s.doc.Version))
+		return
+	}
+
+	// A backwards selection anchors the same text as a forwards one.
+	r := *om.msg.Range
+	if r.Start > r.End {
+		r.Start, r.End = r.End, r.Start
+	}
+	n, err := s.lenAt(p, om.msg.Revision)
+	if err != nil {
+		log.Printf("session %s: comment error: %v", s.docID, err)
+		om.client.sendError("failed to read document")
+		return
+	}
+	if r.Start < 0 || r.End > n {
+		om.client.sendError(fmt.Sprintf("range [%d, %d) is outside the document", r.Start, r.End))
+		return
+	}
+	for _, e := range s.doc.History[om.msg.Revision-s.doc.Base:] {
+		if r, err = transformAnchor(p, r, e.Op); err != nil {
+			log.Printf("session %s: comment transform error: %v", s.docID, err)
+			om.client.sendError("transform error: " + err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	th := Thread{
+		ID:        generateID(),
+		Anchor:    r,
+		Comments:  []Comment{{Author: om.client.UserID, Body: om.msg.Body, CreatedAt: now}},
+		CreatedAt: now,
+	}
+	s.threads = append(s.threads, th)
+	s.saveThread(&s.threads[len(s.threads)-1])
+}
+
+// lenAt returns the document's length, in p's units, at revision, which
+// must be in its history.
+func (s *Session) lenAt(p ot.Positioner, revision int) (int, error) {
+	if revision == s.doc.Version {
+		return p.Len(s.doc.Snapshot())
+	}
+	// The next edit applied to the document at revision.
+	op, ok := s.doc.History[revision-s.doc.Base].Op.(ot.Operation)
+	if !ok {
+		return 0, fmt.Errorf("no length for %s documents before revision %d", s.doc.Type.Name(), s.doc.Version)
+	}
+	return op.BaseLen(), nil
+}
+
+// handleReply adds a comment to a thread, reopening it if it was
+// resolved.
+func (s *Session) handleReply(c *Client, msg ClientMessage) {
+	i := s.threadIndex(msg.ThreadID)
+	if i < 0 {
+		c.sendError("no thread " + msg.ThreadID)
+		return
+	}
+	if !s.validComment(c, msg.Body) {
+		return
+	}
+	th := &s.threads[i]
+	th.Comments = append(th.Comments, Comment{Author: c.UserID, Body: msg.Body, CreatedAt: time.Now()})
+	th.Resolved = false
+	s.saveThread(th)
+}
+
+// handleResolve marks a thread resolved. Editors may resolve any thread,
+// and the user who wrote a thread's first comment may resolve it.
+func (s *Session) handleResolve(c *Client, id string) {
+	i := s.threadIndex(id)
+	if i < 0 {
+		c.sendError("no thread " + id)
+		return
+	}
+	th := &s.threads[i]
+	if !c.canEdit() && th.Comments[0].Author != c.UserID {
+		c.sendErrorCode(CodeForbidden, "only editors can resolve others' threads")
+		return
+	}
+	if th.Resolved {
+		return
+	}
+	th.Resolved = true
+	s.saveThread(th)
+}
+
+// validComment reports whether body can be a comment, telling c why not
+// if it can't.
+func (s *Session) validComment(c *Client, body string) bool {
+	switch {
+	case body == "":
+		c.sendError("comment is empty")
+		return false
+	case len(body) > maxCommentLength:
+		c.sendError(fmt.Sprintf("comment is longer than %d bytes", maxCommentLength))
+		return false
+	}
+	return true
+}
+
+// saveThread persists a new or changed thread and sends it to every
+// client.
+func (s *Session) saveThread(th *Thread) {
+	s.storeThread(th)
+	msg := *th
+	for c := range s.clients {
+		c.sendMsg(ServerMessage{
+			Type:     MsgThread,
+			DocID:    s.docID,
+			Revision: s.doc.Version,
+			Thread:   &msg,
+		})
+	}
+}
+
+// storeThread persists a thread, with its anchor at the current revision.
+func (s *Session) storeThread(th *Thread) {
+	err := s.store.SaveThread(context.Background(), s.docID, store.Thread{
+		ID:        th.ID,
+		Anchor:    th.Anchor,
+		Revision:  s.doc.Version,
+		Resolved:  th.Resolved,
+		Comments:  storeComments(th.Comments),
+		CreatedAt: th.CreatedAt,
+	})
+	if err != nil {
+		log.Printf("session %s: failed to save thread %s: %v", s.docID, th.ID, err)
+		return
+	}
+	th.saved = s.doc.Version
+}
+
+// storeAnchors persists the threads whose anchors edits may have moved
+// since they were stored, so that loading them replays only the edits
+// since this revision.
+func (s *Session) storeAnchors() {
+	for i := range s.threads {
+		if s.threads[i].saved < s.doc.Version {
+			s.storeThread(&s.threads[i])
+		}
+	}
+}
+
+func (s *Session) threadIndex(id string) int {
+	for i, th := range s.threads {
+		if th.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// transformThreads moves every thread's anchor past op, which has just
+// been applied to the document. Anchors aren't persisted as they move, but
+// with each snapshot; stored threads keep the revision they were saved at,
+// and loadThreads brings them up to date.
+func (s *Session) transformThreads(op ot.Op) {
+	p, ok := s.doc.Type.(ot.Positioner)
+	if !ok {
+		return
+	}
+	for i := range s.threads {
+		r, err := transformAnchor(p, s.threads[i].Anchor, op)
+		if err != nil {
+			log.Printf("session %s: thread %s transform error: %v", s.docID, s.threads[i].ID, err)
+			continue
+		}
+		s.threads[i].Anchor = r
+	}
+}
+
+// transformAnchor moves r, a range with Start <= End, past op. Text
+// inserted at either end of the range goes outside it, so typing next to
+// commented text doesn't extend the comment. An empty range keeps to the
+// text on its left. Once all of its text is deleted, a range is empty.
+func transformAnchor(p ot.Positioner, r ot.Range, op ot.Op) (ot.Range, error) {
+	start, err := p.TransformIndex(r.Start, op, ot.BiasRight)
+	if err != nil {
+		return r, err
+	}
+	end, err := p.TransformIndex(r.End, op, ot.BiasLeft)
+	if err != nil {
+		return r, err
+	}
+	return ot.Range{Start: min(start, end), End: end}, nil
+}
+
+// loadThreads returns a document's comment threads with their anchors
+// brought up to date with the document at version, replaying the edits
+// made since each was saved.
+func loadThreads(ctx context.Context, st store.DocumentStore, docID string, t ot.Type, version int) ([]Thread, error) {
+	stored, err := st.ListThreads(ctx, docID)
+	if err != nil || len(stored) == 0 {
+		return nil, err
+	}
+	p, ok := t.(ot.Positioner)
+	if !ok {
+		return nil, fmt.Errorf("%s documents can't have comments", t.Name())
+	}
+	from := version
+	for _, th := range stored {
+		if th.Revision > version {
+			return nil, fmt.Errorf("thread %s saved at revision %d, document at %d", th.ID, th.Revision, version)
+		}
+		from = min(from, th.Revision)
+	}
+	edits, err := st.GetOperations(ctx, docID, from)
+	if err != nil {
+		return nil, err
+	}
+	if len(edits) != version-from {
+		return nil, fmt.Errorf("stored history has %d edits since revision %d, want %d", len(edits), from, version-from)
+	}
+
+	threads := make([]Thread, len(stored))
+	for i, th := range stored {
+		r := th.Anchor
+		for _, e := range edits[th.Revision-from:] {
+			if r, err = transformAnchor(p, r, e.Op); err != nil {
+				return nil, fmt.Errorf("thread %s: %w", th.ID, err)
+			}
+		}
+		comments := make([]Comment, len(th.Comments))
+		for j, c := range th.Comments {
+			comments[j] = Comment{Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt}
+		}
+		threads[i] = Thread{
+			ID:        th.ID,
+			Anchor:    r,
+			Resolved:  th.Resolved,
+			Comments:  comments,
+			CreatedAt: th.CreatedAt,
+			saved:     th.Revision,
+		}
+	}
+	return threads, nil
+}
+
+func storeComments(comments []Comment) []store.Comment {
+	result := make([]store.Comment, len(comments))
+	for i, c := range comments {
+		result[i] = store.Comment{Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/alimasry/go-collab-editor/ot"
+)
+
+// maxCursorRanges bounds how many ranges a client's cursor can have.
+const maxCursorRanges = 100
+
+// Cursor is where a client is in the document: one range per caret, with
+// Start == End for a caret without a selection and Start after End for a
+// backwards selection. Its Ranges apply to the document at the revision of
+// the message that carries it.
+type Cursor struct {
+	ClientID string     `json:"clientId"`
+	Ranges   []ot.Range `json:"ranges"`
+}
+
+// handleCursor records a client's cursor, sent at the revision it sent,
+// and passes it on to every other client. A cursor without ranges clears
+// the client's cursor, as when its editor loses focus.
+func (s *Session) handleCursor(om opMessage) {
+	p, ok := s.doc.Type.(ot.Positioner)
+	if !ok {
+		om.client.sendError("cursors are not supported for " + s.doc.Type.Name() + " documents")
+		return
+	}
+	if len(om.msg.Ranges) > maxCursorRanges {
+		om.client.sendError(fmt.Sprintf("cursor has more than %d ranges", maxCursorRanges))
+		return
+	}
+	if om.msg.Revision > s.doc.Version {
+		om.client.sendError(fmt.Sprintf("no revision %d (document at %d)", om.msg.Revision, s.doc.Version))
+		return
+	}
+	if om.msg.Revision < s.doc.Base {
+		// The client is too far behind to edit, and will move its cursor
+		// again once it has resynced.
+		return
+	}
+
+	// Ranges are checked against the document they were sent for, since
+	// transforming moves even out-of-range positions into it.
+	n, err := s.lenAt(p, om.msg.Revision)
+	if err != nil {
+		log.Printf("session %s: cursor error: %v", s.docID, err)
+		om.client.sendError("failed to read document")
+		return
+	}
+	for _, r := range om.msg.Ranges {
+		if min(r.Start, r.End) < 0 || max(r.Start, r.End) > n {
+			om.client.sendError(fmt.Sprintf("range [%d, %d) is outside the document", r.Start, r.End))
+			return
+		}
+	}
+	ranges := append([]ot.Range(nil), om.msg.Ranges...)
+	for _, e := range s.doc.History[om.msg.Revision-s.doc.Base:] {
+		if err := transformCursor(p, ranges, e.Op); err != nil {
+			log.Printf("session %s: cursor transform error: %v", s.docID, err)
+			om.client.sendError("transform error: " + err.Error())
+			return
+		}
+	}
+
+	if len(ranges) == 0 {
+		delete(s.cursors, om.client.ID)
+	} else {
+		s.cursors[om.client.ID] = ranges
+	}
+	for c := range s.clients {
+		if c != om.client {
+			c.sendMsg(ServerMessage{
+				Type:     MsgCursor,
+				DocID:    s.docID,
+				Revision: s.doc.Version,
+				ClientID: om.client.ID,
+				Ranges:   ranges,
+			})
+		}
+	}
+}
+
+// transformCursors moves every client's cursor past op, which has just been
+// applied to the document.
+func (s *Session) transformCursors(op ot.Op) {
+	p, ok := s.doc.Type.(ot.Positioner)
+	if !ok {
+		return
+	}
+	for id, ranges := range s.cursors {
+		if err := transformCursor(p, ranges, op); err != nil {
+			log.Printf("session %s: cursor %s transform error: %v", s.docID, id, err)
+			delete(s.cursors, id)
+		}
+	}
+}
+
+// transformCursor moves each of ranges past op in place. Text inserted at
+// a caret goes before it, the way the browser client moves its own caret,
+// so collaborators see a cursor where its owner does.
+func transformCursor(p ot.Positioner, ranges []ot.Range, op ot.Op) error {
+	for i, r := range ranges {
+		start, err := p.TransformIndex(r.Start, op, ot.BiasRight)
+		if err != nil {
+			return err
+		}
+		end, err := p.TransformIndex(r.End, op, ot.BiasRight)
+		if err != nil {
+			return err
+		}
+		ranges[i] = ot.Range{Start: start, End: end}
+	}
+	return nil
+}
+
+// cursorList returns every client's cursor except skip's, ordered by
+// client ID.
+func (s *Session) cursorList(skip *Client) []Cursor {
+	var cursors []Cursor
+	for id, ranges := range s.cursors {
+		if id != skip.ID {
+			cursors = append(cursors, Cursor{ClientID: id, Ranges: ranges})
+		}
+	}
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].ClientID < cursors[j].ClientID })
+	return cursors
+}
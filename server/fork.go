@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+// errForkMerged is returned when merging a fork that was already merged.
+var errForkMerged = errors.New("fork was already merged")
+
+// forkDocument creates document id with the content of parent at revision,
+// recording it as a fork so it can later be merged back. The fork starts
+// with no history of its own: its revision 0 is the parent's revision.
+func forkDocument(ctx context.Context, st store.DocumentStore, parent, id string, revision int) error {
+	info, err := st.Get(ctx, parent)
+	if err != nil {
+		return err
+	}
+	doc, err := loadRevision(ctx, st, parent, revision)
+	if err != nil {
+		return err
+	}
+	if err := st.CreateTyped(ctx, id, info.Type, doc.Serialized()); err != nil {
+		return err
+	}
+	return st.SaveFork(ctx, id, store.Fork{Parent: parent, Revision: revision})
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -20,16 +21,46 @@ func NewHandler(hub *Hub) http.Handler {
 	mux.Handle("/", fs)
 
 	// WebSocket endpoint.
-	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/ws", authenticated(hub, func(w http.ResponseWriter, r *http.Request) {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Printf("websocket upgrade error: %v", err)
 			return
 		}
-		client := newClient(hub, conn)
+		client := newClient(hub, conn, requestUser(r))
 		go client.WritePump()
 		go client.ReadPump()
-	})
+	}))
+
+	// REST API.
+	registerAPI(mux, hub)
 
 	return mux
 }
+
+type userKey struct{}
+
+// authenticated wraps h so that, if the hub has an Auth, requests are
+// authenticated first, and rejected with 401 Unauthorized if they can't
+// be. h gets the user with requestUser.
+func authenticated(hub *Hub, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hub.Auth == nil {
+			h(w, r)
+			return
+		}
+		user, err := hub.Auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// requestUser returns the user who made r, or nil if the hub has no Auth.
+func requestUser(r *http.Request) *Identity {
+	user, _ := r.Context().Value(userKey{}).(*Identity)
+	return user
+}
@@ -102,7 +102,7 @@ func TestHandler_TwoClientsCollaborate(t *testing.T) {
 
 	// c1 sends an insert
 	op := ot.NewInsert(0, "hello", 0)
-	conn1.WriteJSON(ClientMessage{Type: MsgOp, DocID: "collab", Revision: 0, Op: op})
+	conn1.WriteJSON(ClientMessage{Type: MsgOp, DocID: "collab", Revision: 0, Op: rawOp(op)})
 
 	// c1 gets ack
 	ack := readWsMsg(t, conn1)
@@ -116,3 +116,60 @@ func TestHandler_TwoClientsCollaborate(t *testing.T) {
 		t.Fatalf("expected op broadcast, got %q", broadcast.Type)
 	}
 }
+
+func TestHandler_Auth(t *testing.T) {
+	server, hub := setupTestServer(t)
+	defer server.Close()
+	auth := TokenAuth{Secret: []byte("secret")}
+	hub.Auth = auth
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// Connections without a valid token aren't upgraded.
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without token: err=%v, want 401", err)
+	}
+
+	token, err := auth.Sign(Identity{ID: "alice", Name: "Alice"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(ClientMessage{Type: MsgJoin, DocID: "doc"})
+	doc := readWsMsg(t, conn)
+	if doc.UserID != "alice" || len(doc.Clients) != 1 || doc.Clients[0].Name != "Alice" {
+		t.Fatalf("got userId=%q clients=%+v, want alice named Alice", doc.UserID, doc.Clients)
+	}
+
+	// Edits are by the user, from the connection's site.
+	conn.WriteJSON(ClientMessage{Type: MsgOp, DocID: "doc", Revision: 0, Op: rawOp(ot.NewInsert(0, "hi", 0))})
+	readWsMsg(t, conn) // ack
+	edits, err := hub.store.GetOperations(ctx(), "doc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].Author != "alice" || edits[0].Site != doc.ClientID {
+		t.Errorf("edits = %+v, want one by alice from %s", edits, doc.ClientID)
+	}
+}
+
+func TestGenerateID(t *testing.T) {
+	// IDs made in quick succession, as for a burst of chat messages, are
+	// distinct.
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := generateID()
+		if len(id) != 8 || strings.Trim(id, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			t.Fatalf("id %q is not 8 lowercase letters and digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("id %q generated twice", id)
+		}
+		seen[id] = true
+	}
+}
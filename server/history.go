@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/alimasry/go-collab-editor/ot"
+	"github.com/alimasry/go-collab-editor/store"
+)
+
+// latestSnapshot returns a document's most recent snapshot, backfilling
+// them if the document was stored before snapshots were kept.
+func latestSnapshot(ctx context.Context, st store.DocumentStore, info *store.DocumentInfo) (*store.Snapshot, error) {
+	snap, err := st.GetSnapshot(ctx, info.ID, math.MaxInt)
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		return backfillSnapshots(ctx, st, info)
+	}
+	return snap, err
+}
+
+// backfillSnapshots saves snapshots for a document stored before snapshots
+// were kept, returning the latest. Its stored content, which was then saved
+// with every edit, is its content at its stored version. If replaying its
+// edits from an empty document reproduces that content, the document was
+// created empty, and version 0 is saved too so that earlier revisions can
+// be rebuilt.
+func backfillSnapshots(ctx context.Context, st store.DocumentStore, info *store.DocumentInfo) (*store.Snapshot, error) {
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := ot.NewTypedDocument(t, info.Content)
+	if err != nil {
+		return nil, err
+	}
+	edits, err := st.GetOperations(ctx, info.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(edits) >= info.Version {
+		empty, err := ot.NewTypedDocument(t, "")
+		if err != nil {
+			return nil, err
+		}
+		initial := store.Snapshot{Version: 0, Content: empty.Serialized()}
+		empty.LazyContent = true
+		for _, e := range edits[:info.Version] {
+			if err = empty.ApplyEdit(e); err != nil {
+				break
+			}
+		}
+		if err == nil && empty.Serialized() == latest.Serialized() {
+			if err := st.SaveSnapshot(ctx, info.ID, initial); err != nil {
+				return nil, err
+			}
+		}
+	}
+	snap := store.Snapshot{Version: info.Version, Content: latest.Serialized()}
+	if err := st.SaveSnapshot(ctx, info.ID, snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// currentVersion returns a document's current revision. Sessions save a
+// document's content only with each snapshot, so the version stored with
+// it can lag behind the stored edits.
+func currentVersion(ctx context.Context, st store.DocumentStore, info *store.DocumentInfo) (int, error) {
+	snap, err := latestSnapshot(ctx, st, info)
+	if err != nil {
+		return 0, err
+	}
+	edits, err := st.GetOperations(ctx, info.ID, snap.Version)
+	if err != nil {
+		return 0, err
+	}
+	return snap.Version + len(edits), nil
+}
+
+// loadLatest rebuilds a document's current state from its latest snapshot
+// and the edits stored since, keeping at most limit edits of history (all
+// of them if limit is 0).
+func loadLatest(ctx context.Context, st store.DocumentStore, info *store.DocumentInfo, limit int) (*ot.Document, error) {
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := latestSnapshot(ctx, st, info)
+	if err != nil {
+		return nil, err
+	}
+	edits, err := st.GetOperations(ctx, info.ID, snap.Version)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := ot.NewTypedDocument(t, snap.Content)
+	if err != nil {
+		return nil, err
+	}
+	doc.LazyContent = true
+	doc.HistoryLimit = limit
+	doc.Version, doc.Base = snap.Version, snap.Version
+	for _, e := range edits {
+		if err := doc.ApplyEdit(e); err != nil {
+			return nil, err
+		}
+	}
+
+	// Add the edits before the snapshot that the history window covers.
+	from := 0
+	if limit > 0 {
+		from = max(0, doc.Version-limit)
+	}
+	if from < doc.Base {
+		earlier, err := st.GetOperations(ctx, info.ID, from)
+		if err != nil {
+			return nil, err
+		}
+		if n := doc.Base - from; n <= len(earlier) {
+			doc.History = append(earlier[:n:n], doc.History...)
+			doc.Base = from
+		}
+	}
+	return doc, nil
+}
+
+// loadRevision rebuilds a document as it was at a revision, replaying its
+// stored edits from the latest snapshot at or before it. It fails with
+// store.ErrSnapshotNotFound for revisions before a document stored without
+// snapshots was loaded, unless the document was created empty.
+func loadRevision(ctx context.Context, st store.DocumentStore, id string, revision int) (*ot.Document, error) {
+	info, err := st.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	version, err := currentVersion(ctx, st, info)
+	if err != nil {
+		return nil, err
+	}
+	if revision < 0 || revision > version {
+		return nil, fmt.Errorf("revision %d out of range (document at %d)", revision, version)
+	}
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := latestSnapshot(ctx, st, info)
+	if err == nil && snap.Version > revision {
+		snap, err = st.GetSnapshot(ctx, id, revision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	edits, err := st.GetOperations(ctx, id, snap.Version)
+	if err != nil {
+		return nil, err
+	}
+	if revision-snap.Version > len(edits) {
+		return nil, fmt.Errorf("history ends at revision %d", snap.Version+len(edits))
+	}
+	doc, err := ot.NewTypedDocument(t, snap.Content)
+	if err != nil {
+		return nil, err
+	}
+	doc.LazyContent = true
+	doc.Version, doc.Base = snap.Version, snap.Version
+	for _, e := range edits[:revision-snap.Version] {
+		if err := doc.ApplyEdit(e); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// loadDiff returns the operation that takes a document from revision from
+// to revision to, composing the stored edits between them. It returns nil
+// if from == to.
+func loadDiff(ctx context.Context, st store.DocumentStore, id string, from, to int) (ot.Op, error) {
+	info, err := st.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	version, err := currentVersion(ctx, st, info)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 || from > to || to > version {
+		return nil, fmt.Errorf("invalid revisions %d to %d (document at %d)", from, to, version)
+	}
+	if from == to {
+		return nil, nil
+	}
+	t, err := ot.LookupType(info.Type)
+	if err != nil {
+		return nil, err
+	}
+	edits, err := st.GetOperations(ctx, id, from)
+	if err != nil {
+		return nil, err
+	}
+	if to-from > len(edits) {
+		return nil, fmt.Errorf("history ends at revision %d", from+len(edits))
+	}
+	diff := edits[0].Op
+	for _, e := range edits[1 : to-from] {
+		if diff, err = t.Compose(diff, e.Op); err != nil {
+			return nil, err
+		}
+	}
+	return diff, nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
 	"sync"
 
@@ -9,13 +10,36 @@ import (
 	"github.com/alimasry/go-collab-editor/store"
 )
 
+// DefaultHistoryLimit is the Hub's default HistoryLimit.
+const DefaultHistoryLimit = 1000
+
 type joinRequest struct {
-	client *Client
-	docID  string
+	client  *Client
+	docID   string
+	docType string // type name for a new document; empty means the default
+	role    string // empty means the client's granted role
+	since   *int   // revision to send the edits since, if any
 }
 
 // Hub manages document sessions and routes clients to the right session.
 type Hub struct {
+	// DefaultType is the type of documents created by a join that doesn't
+	// name one. Set it before calling Run.
+	DefaultType ot.Type
+	// NewEngine, if set, creates the engine for each new session, for
+	// engines that keep per-document state like ot.CRDTEngine. Otherwise
+	// every session uses the engine passed to NewHub.
+	NewEngine func() ot.Engine
+	// HistoryLimit is the most edits each session keeps in memory to
+	// transform incoming edits against; edits made before them are
+	// rejected with CodeResync. Zero means no limit.
+	HistoryLimit int
+	// Auth, if set, authenticates WebSocket connections before they are
+	// upgraded, rejecting those it can't with 401 Unauthorized. Clients
+	// then act as the user it returns. Otherwise every connection is an
+	// anonymous user. Set it before serving NewHandler's handler.
+	Auth Authenticator
+
 	store    store.DocumentStore
 	engine   ot.Engine
 	sessions map[string]*Session
@@ -26,10 +50,12 @@ type Hub struct {
 
 func NewHub(st store.DocumentStore, engine ot.Engine) *Hub {
 	return &Hub{
-		store:    st,
-		engine:   engine,
-		sessions: make(map[string]*Session),
-		joinDoc:  make(chan joinRequest, 64),
+		DefaultType:  ot.Text,
+		HistoryLimit: DefaultHistoryLimit,
+		store:        st,
+		engine:       engine,
+		sessions:     make(map[string]*Session),
+		joinDoc:      make(chan joinRequest, 64),
 	}
 }
 
@@ -41,41 +67,87 @@ func (h *Hub) Run() {
 }
 
 func (h *Hub) handleJoinDoc(req joinRequest) {
+	granted := req.client.grant
+	if granted == "" {
+		granted = RoleEditor
+	}
+	role := req.role
+	switch role {
+	case "":
+		role = granted
+	case RoleEditor, RoleReviewer:
+	default:
+		req.client.sendError("unknown role: " + role)
+		return
+	}
+	if role == RoleEditor && granted != RoleEditor {
+		req.client.sendErrorCode(CodeForbidden, "not allowed to join as "+role)
+		return
+	}
+	s, err := h.session(req.docID, req.docType)
+	if err != nil {
+		req.client.sendError(err.Error())
+		return
+	}
+	req.client.mu.Lock()
+	req.client.role = role
+	req.client.since = req.since
+	req.client.mu.Unlock()
+	s.join <- req.client
+}
+
+// session returns the session for a document, starting one if none is
+// active. A document that doesn't exist is created with the named type, or
+// DefaultType if docType is empty. Errors are suitable for clients.
+func (h *Hub) session(docID, docType string) (*Session, error) {
 	h.mu.Lock()
-	s, ok := h.sessions[req.docID]
-	if !ok {
-		// Create document in store if it doesn't exist.
-		ctx := context.Background()
-		if _, err := h.store.Get(ctx, req.docID); err != nil {
-			if err := h.store.Create(ctx, req.docID, ""); err != nil {
-				log.Printf("hub: failed to create doc %q: %v", req.docID, err)
-				h.mu.Unlock()
-				req.client.sendError("failed to create document")
-				return
+	defer h.mu.Unlock()
+	if s, ok := h.sessions[docID]; ok {
+		return s, nil
+	}
+
+	// Create document in store if it doesn't exist.
+	ctx := context.Background()
+	if _, err := h.store.Get(ctx, docID); err != nil {
+		t := h.DefaultType
+		if docType != "" {
+			var err error
+			if t, err = ot.LookupType(docType); err != nil {
+				return nil, err
 			}
 		}
-
-		info, err := h.store.Get(ctx, req.docID)
-		if err != nil {
-			log.Printf("hub: failed to get doc %q: %v", req.docID, err)
-			h.mu.Unlock()
-			req.client.sendError("failed to load document")
-			return
+		if err := h.store.CreateTyped(ctx, docID, t.Name(), ""); err != nil {
+			log.Printf("hub: failed to create doc %q: %v", docID, err)
+			return nil, errors.New("failed to create document")
 		}
+	}
 
-		ops, err := h.store.GetOperations(ctx, req.docID, 0)
-		if err != nil {
-			log.Printf("hub: failed to load history for %q: %v", req.docID, err)
-			ops = nil
-		}
+	info, err := h.store.Get(ctx, docID)
+	if err != nil {
+		log.Printf("hub: failed to get doc %q: %v", docID, err)
+		return nil, errors.New("failed to load document")
+	}
 
-		s = newSession(req.docID, info.Content, info.Version, ops, h.engine, h.store)
-		h.sessions[req.docID] = s
-		go s.Run()
+	doc, err := loadLatest(ctx, h.store, info, h.HistoryLimit)
+	if err != nil {
+		log.Printf("hub: failed to load doc %q: %v", docID, err)
+		return nil, errors.New("failed to load document")
 	}
-	h.mu.Unlock()
 
-	s.join <- req.client
+	engine := h.engine
+	if h.NewEngine != nil {
+		engine = h.NewEngine()
+	}
+	s := newSession(docID, doc, engine, h.store)
+	if s.threads, err = loadThreads(ctx, h.store, docID, doc.Type, doc.Version); err != nil {
+		log.Printf("hub: failed to load comment threads for %q: %v", docID, err)
+	}
+	if s.chat, err = loadChat(ctx, h.store, docID); err != nil {
+		log.Printf("hub: failed to load chat for %q: %v", docID, err)
+	}
+	h.sessions[docID] = s
+	go s.Run()
+	return s, nil
 }
 
 // GetSession returns the session for a document, if active.
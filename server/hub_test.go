@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -72,3 +73,189 @@ func TestHub_JoinExistingDoc(t *testing.T) {
 		t.Fatal("timeout")
 	}
 }
+
+func TestHub_CreateTypedDoc(t *testing.T) {
+	st := store.NewMemoryStore()
+	hub := NewHub(st, &ot.JupiterEngine{})
+	go hub.Run()
+
+	c := mockClient("c1")
+	c.hub = hub
+	hub.joinDoc <- joinRequest{client: c, docID: "rich", docType: ot.Rich.Name()}
+
+	msg := recvMsg(t, c)
+	if msg.Type != MsgDoc || msg.DocType != "rich-text" {
+		t.Fatalf("got %s with docType %q, want doc with rich-text", msg.Type, msg.DocType)
+	}
+	info, err := st.Get(ctx(), "rich")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != "rich-text" {
+		t.Errorf("stored type = %q, want %q", info.Type, "rich-text")
+	}
+
+	// Formatting is applied through the rich-text type.
+	s := hub.GetSession("rich")
+	s.incoming <- opMessage{client: c, msg: ClientMessage{
+		Type: MsgOp,
+		Op:   rawOp(ot.Operation{Ops: []ot.Component{{Insert: "hi", Attributes: ot.Attributes{"bold": true}}}}),
+	}}
+	if ack := recvMsg(t, c); ack.Type != MsgAck {
+		t.Fatalf("expected ack, got %s: %s", ack.Type, ack.Message)
+	}
+	doc, err := loadLatest(ctx(), st, info, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"ops":[{"insert":"hi","attributes":{"bold":true}}]}`; doc.Serialized() != want {
+		t.Errorf("content = %s, want %s", doc.Serialized(), want)
+	}
+}
+
+func TestHub_UnknownDocType(t *testing.T) {
+	hub := NewHub(store.NewMemoryStore(), &ot.JupiterEngine{})
+	go hub.Run()
+
+	c := mockClient("c1")
+	c.hub = hub
+	hub.joinDoc <- joinRequest{client: c, docID: "x", docType: "spreadsheet"}
+
+	if msg := recvMsg(t, c); msg.Type != MsgError {
+		t.Errorf("expected error, got %s", msg.Type)
+	}
+}
+
+func TestHub_JoinRole(t *testing.T) {
+	hub := NewHub(store.NewMemoryStore(), &ot.JupiterEngine{})
+	go hub.Run()
+
+	c1 := mockClient("c1")
+	hub.joinDoc <- joinRequest{client: c1, docID: "x"}
+	if msg := recvMsg(t, c1); msg.Type != MsgDoc || msg.Role != RoleEditor {
+		t.Errorf("got type=%q role=%q, want doc as editor", msg.Type, msg.Role)
+	}
+
+	c2 := mockClient("c2")
+	hub.joinDoc <- joinRequest{client: c2, docID: "x", role: RoleReviewer}
+	if msg := recvMsg(t, c2); msg.Type != MsgDoc || msg.Role != RoleReviewer {
+		t.Errorf("got type=%q role=%q, want doc as reviewer", msg.Type, msg.Role)
+	}
+	if msg := recvMsg(t, c1); msg.Type != MsgJoin || msg.Role != RoleReviewer {
+		t.Errorf("got type=%q role=%q, want join of a reviewer", msg.Type, msg.Role)
+	}
+
+	c3 := mockClient("c3")
+	hub.joinDoc <- joinRequest{client: c3, docID: "x", role: "owner"}
+	if msg := recvMsg(t, c3); msg.Type != MsgError {
+		t.Errorf("expected error, got %s", msg.Type)
+	}
+
+	// A client granted only reviewing joins as a reviewer by default, and
+	// can't ask to edit.
+	c4 := mockClient("c4")
+	c4.grant = RoleReviewer
+	hub.joinDoc <- joinRequest{client: c4, docID: "x"}
+	if msg := recvMsg(t, c4); msg.Type != MsgDoc || msg.Role != RoleReviewer {
+		t.Errorf("got type=%q role=%q, want doc as reviewer", msg.Type, msg.Role)
+	}
+	recvMsg(t, c1) // join
+	recvMsg(t, c2) // join
+	c5 := mockClient("c5")
+	c5.grant = RoleReviewer
+	hub.joinDoc <- joinRequest{client: c5, docID: "x", role: RoleEditor}
+	if msg := recvMsg(t, c5); msg.Type != MsgError || msg.Code != CodeForbidden {
+		t.Errorf("got type=%q code=%q, want forbidden error", msg.Type, msg.Code)
+	}
+}
+
+func TestHub_NewEnginePerSession(t *testing.T) {
+	hub := NewHub(store.NewMemoryStore(), &ot.JupiterEngine{})
+	hub.NewEngine = func() ot.Engine { return &ot.CRDTEngine{} }
+	go hub.Run()
+
+	for _, id := range []string{"doc1", "doc2"} {
+		c := mockClient("c-" + id)
+		c.hub = hub
+		hub.joinDoc <- joinRequest{client: c, docID: id}
+		recvMsg(t, c) // doc
+	}
+	e1, e2 := hub.GetSession("doc1").engine, hub.GetSession("doc2").engine
+	if _, ok := e1.(*ot.CRDTEngine); !ok {
+		t.Fatalf("engine = %T, want *ot.CRDTEngine", e1)
+	}
+	if e1 == e2 {
+		t.Error("sessions share an engine")
+	}
+}
+
+func TestHub_LoadsHistoryWindow(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc", "")
+	content := ""
+	for v := 1; v <= 5; v++ {
+		op := ot.NewInsert(len(content), "x", len(content))
+		content += "x"
+		st.AppendOperation(ctx(), "doc", ot.Edit{Op: op}, v)
+		st.UpdateContent(ctx(), "doc", content, v)
+	}
+	hub := NewHub(st, &ot.JupiterEngine{})
+	hub.HistoryLimit = 2
+	go hub.Run()
+
+	c := mockClient("c1")
+	c.hub = hub
+	hub.joinDoc <- joinRequest{client: c, docID: "doc"}
+	recvMsg(t, c) // doc
+
+	doc := hub.GetSession("doc").doc
+	if doc.Version != 5 || doc.Base != 3 || len(doc.History) != 2 || doc.HistoryLimit != 2 {
+		t.Errorf("version=%d base=%d history=%d limit=%d, want 5, 3, 2 and 2",
+			doc.Version, doc.Base, len(doc.History), doc.HistoryLimit)
+	}
+}
+
+func TestHub_LoadsFromSnapshot(t *testing.T) {
+	// The stored content lags behind: it was last saved with the snapshot
+	// at revision 3.
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc", "")
+	for v := 1; v <= 5; v++ {
+		st.AppendOperation(ctx(), "doc", ot.Edit{Op: ot.NewInsert(v-1, "x", v-1)}, v)
+	}
+	st.SaveSnapshot(ctx(), "doc", store.Snapshot{Version: 3, Content: "xxx"})
+	st.UpdateContent(ctx(), "doc", "xxx", 3)
+	hub := NewHub(st, &ot.JupiterEngine{})
+	go hub.Run()
+
+	c := mockClient("c1")
+	c.hub = hub
+	hub.joinDoc <- joinRequest{client: c, docID: "doc"}
+	if msg := recvMsg(t, c); msg.Content != "xxxxx" || msg.Revision != 5 {
+		t.Errorf("doc = %q at %d, want %q at 5", msg.Content, msg.Revision, "xxxxx")
+	}
+	if doc := hub.GetSession("doc").doc; doc.Base != 0 || len(doc.History) != 5 {
+		t.Errorf("base=%d history=%d, want 0 and 5", doc.Base, len(doc.History))
+	}
+}
+
+func TestHub_LoadsChat(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc", "")
+	for i := range chatHistorySize + 1 {
+		st.AppendChat(ctx(), "doc", store.ChatMessage{ID: fmt.Sprint(i), Author: "old", Body: "hi"})
+	}
+	hub := NewHub(st, &ot.JupiterEngine{})
+	go hub.Run()
+
+	c := mockClient("c1")
+	c.hub = hub
+	hub.joinDoc <- joinRequest{client: c, docID: "doc"}
+	msg := recvMsg(t, c)
+	if len(msg.ChatHistory) != chatHistorySize {
+		t.Fatalf("chat history has %d messages, want %d", len(msg.ChatHistory), chatHistorySize)
+	}
+	if msg.ChatHistory[0].ID != "1" {
+		t.Errorf("chat history starts with %+v, want message 1", msg.ChatHistory[0])
+	}
+}
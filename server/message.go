@@ -14,35 +14,113 @@ const (
 	MsgAck   = "ack"
 	MsgDoc   = "doc"
 	MsgError = "error"
+	MsgUndo  = "undo"
+	MsgRedo  = "redo"
+	MsgBlame = "blame"
+
+	MsgSuggest    = "suggest"    // client proposes an edit
+	MsgSuggestion = "suggestion" // server announces a pending suggestion
+	MsgAccept     = "accept"
+	MsgReject     = "reject"
+
+	MsgComment = "comment" // client starts a comment thread
+	MsgReply   = "reply"
+	MsgResolve = "resolve"
+	MsgThread  = "thread" // server sends a new or changed thread
+
+	MsgCursor    = "cursor"
+	MsgAwareness = "awareness"
+	MsgChat      = "chat"
+)
+
+// Roles a client can join a document with.
+const (
+	RoleEditor   = "editor"   // may edit, and accept or reject suggestions
+	RoleReviewer = "reviewer" // may only suggest edits
+)
+
+// Error codes, sent with some error messages so clients can react to them.
+const (
+	CodeInvalidOp = "invalid_op" // the operation was malformed and was rejected
+	CodeResync    = "resync"     // the operation's revision is too old; rejoin to get the document
+	CodeForbidden = "forbidden"  // the client's role doesn't allow the request
 )
 
 // ClientMessage is a message from client to server.
 type ClientMessage struct {
-	Type     string       `json:"type"`
-	DocID    string       `json:"docId,omitempty"`
-	Revision int          `json:"revision"`
-	Op       ot.Operation `json:"op,omitempty"`
+	Type     string          `json:"type"`
+	DocID    string          `json:"docId,omitempty"`
+	DocType  string          `json:"docType,omitempty"` // type for a new document on join
+	Role     string          `json:"role,omitempty"`    // role on join; the granted role if empty
+	Revision int             `json:"revision"`
+	Op       json.RawMessage `json:"op,omitempty"`    // decoded by the document's ot.Type
+	Seq      int             `json:"seq,omitempty"`   // for op: the client's number for it
+	Since    *int            `json:"since,omitempty"` // for join: send the edits made since this revision
+
+	SuggestionID string `json:"suggestionId,omitempty"` // for accept and reject
+
+	Range    *ot.Range `json:"range,omitempty"`    // for comment
+	Body     string    `json:"body,omitempty"`     // for comment, reply and chat
+	ThreadID string    `json:"threadId,omitempty"` // for reply and resolve
+
+	Ranges []ot.Range                 `json:"ranges,omitempty"` // for cursor
+	State  map[string]json.RawMessage `json:"state,omitempty"`  // for awareness; null values remove keys
 }
 
 // ServerMessage is a message from server to client.
 type ServerMessage struct {
 	Type     string       `json:"type"`
 	DocID    string       `json:"docId,omitempty"`
+	DocType  string       `json:"docType,omitempty"`
 	Content  string       `json:"content"`
 	Revision int          `json:"revision"`
-	Op       ot.Operation `json:"op,omitempty"`
+	Op       ot.Op        `json:"op,omitempty"`
+	Seq      int          `json:"seq,omitempty"` // on op, if its sender numbered it
 	ClientID string       `json:"clientId,omitempty"`
+	UserID   string       `json:"userId,omitempty"` // on doc, join and op
 	Name     string       `json:"name,omitempty"`
 	Color    string       `json:"color,omitempty"`
 	Message  string       `json:"message,omitempty"`
+	Code     string       `json:"code,omitempty"`
 	Clients  []ClientInfo `json:"clients,omitempty"`
+	Blame    ot.Blame     `json:"blame,omitempty"`
+	Role     string       `json:"role,omitempty"`
+
+	Suggestion   *Suggestion  `json:"suggestion,omitempty"`
+	Suggestions  []Suggestion `json:"suggestions,omitempty"` // pending ones, on doc
+	SuggestionID string       `json:"suggestionId,omitempty"`
+
+	Thread  *Thread  `json:"thread,omitempty"`
+	Threads []Thread `json:"threads,omitempty"` // all of them, on doc
+
+	Ranges  []ot.Range `json:"ranges,omitempty"`  // a client's cursor, on cursor
+	Cursors []Cursor   `json:"cursors,omitempty"` // everyone else's, on doc
+
+	State     map[string]json.RawMessage `json:"state,omitempty"`     // a client's awareness, on awareness
+	Awareness []AwarenessState           `json:"awareness,omitempty"` // everyone else's, on doc
+
+	Chat        *ChatMessage  `json:"chat,omitempty"`
+	ChatHistory []ChatMessage `json:"chatHistory,omitempty"` // recent messages, oldest first, on doc
+
+	Edits []HistoryEdit `json:"edits,omitempty"` // on doc, if the join asked for them
+}
+
+// HistoryEdit is an edit made since the revision a rejoining client asked
+// for, with the client that made it and that client's number for it.
+type HistoryEdit struct {
+	Op       ot.Op  `json:"op"`
+	ClientID string `json:"clientId,omitempty"`
+	UserID   string `json:"userId,omitempty"`
+	Seq      int    `json:"seq,omitempty"`
 }
 
 // ClientInfo describes a connected user.
 type ClientInfo struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Color string `json:"color"`
+	ID     string `json:"id"`
+	UserID string `json:"userId"`
+	Name   string `json:"name"`
+	Color  string `json:"color"`
+	Role   string `json:"role"`
 }
 
 // Encode serializes a ServerMessage to JSON bytes.
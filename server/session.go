@@ -2,7 +2,10 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/alimasry/go-collab-editor/ot"
 	"github.com/alimasry/go-collab-editor/store"
@@ -13,6 +16,49 @@ type opMessage struct {
 	msg    ClientMessage
 }
 
+// restoreRequest asks the session to bring the document back to a past
+// revision on behalf of author.
+type restoreRequest struct {
+	revision int
+	author   string
+	reply    chan editResult
+}
+
+// mergeRequest asks the session to merge a fork of its document back in.
+type mergeRequest struct {
+	fork  string
+	reply chan editResult
+}
+
+// editResult is the reply to a request that edits the document: its
+// revision afterwards, or why the edit failed.
+type editResult struct {
+	revision int
+	err      error
+}
+
+// blameResult is the reply to a request for the document's blame.
+type blameResult struct {
+	blame    ot.Blame
+	revision int
+	err      error
+}
+
+// snapshotInterval is how often, in revisions, the session saves a snapshot
+// of the document, bounding how many edits loading it or rebuilding a past
+// revision replays.
+const snapshotInterval = 100
+
+// maxUndoDepth bounds each client's undo and redo stacks.
+const maxUndoDepth = 100
+
+// undoEntry records how to revert one operation. The inverse applies to the
+// document at version, just after the original operation.
+type undoEntry struct {
+	inverse ot.Op
+	version int
+}
+
 // Session manages collaboration for a single document.
 // All operations are serialized through a single goroutine.
 type Session struct {
@@ -22,31 +68,69 @@ type Session struct {
 	store   store.DocumentStore
 	clients map[*Client]bool
 
+	// Undo and redo stacks, keyed by client ID.
+	undo map[string][]undoEntry
+	redo map[string][]undoEntry
+
+	// blame is the document's blame, loaded from the store when first
+	// asked for (setting blameLoaded) and kept up to date by apply.
+	blame       ot.Blame
+	blameLoaded bool
+
+	// Pending suggestions, oldest first, each applying to the current
+	// document.
+	suggestions []Suggestion
+
+	// Comment threads, oldest first, anchored in the current document.
+	threads []Thread
+
+	// Clients' cursors, keyed by client ID, in the current document.
+	cursors map[string][]ot.Range
+
+	// Clients' awareness states, keyed by client ID, and how long each
+	// lasts without being renewed.
+	awareness        map[string]*awareness
+	awarenessTimeout time.Duration
+
+	// Recent chat messages, oldest first.
+	chat []ChatMessage
+
 	incoming chan opMessage
+	restore  chan restoreRequest
+	merge    chan mergeRequest
+	blames   chan chan blameResult
 	join     chan *Client
 	leave    chan *Client
 	stop     chan struct{}
 }
 
-func newSession(docID, content string, version int, history []ot.Operation, engine ot.Engine, st store.DocumentStore) *Session {
-	doc := ot.NewDocument(content)
-	doc.Version = version
-	doc.History = history
+func newSession(docID string, doc *ot.Document, engine ot.Engine, st store.DocumentStore) *Session {
 	return &Session{
 		docID:    docID,
 		doc:      doc,
 		engine:   engine,
 		store:    st,
 		clients:  make(map[*Client]bool),
+		undo:     make(map[string][]undoEntry),
+		redo:     make(map[string][]undoEntry),
+		cursors:  make(map[string][]ot.Range),
 		incoming: make(chan opMessage, 64),
+		restore:  make(chan restoreRequest),
+		merge:    make(chan mergeRequest),
+		blames:   make(chan chan blameResult),
 		join:     make(chan *Client, 16),
 		leave:    make(chan *Client, 16),
 		stop:     make(chan struct{}),
+
+		awareness:        make(map[string]*awareness),
+		awarenessTimeout: awarenessTimeout,
 	}
 }
 
 // Run is the session's main loop. It serializes all operations.
 func (s *Session) Run() {
+	expire := time.NewTicker(s.awarenessTimeout / 2)
+	defer expire.Stop()
 	for {
 		select {
 		case c := <-s.join:
@@ -54,8 +138,47 @@ func (s *Session) Run() {
 		case c := <-s.leave:
 			s.handleLeave(c)
 		case om := <-s.incoming:
-			s.handleOp(om)
+			switch om.msg.Type {
+			case MsgUndo:
+				s.handleUndo(om.client)
+			case MsgRedo:
+				s.handleRedo(om.client)
+			case MsgBlame:
+				s.handleBlame(om.client)
+			case MsgSuggest:
+				s.handleSuggest(om)
+			case MsgAccept:
+				s.handleAccept(om.client, om.msg.SuggestionID)
+			case MsgReject:
+				s.handleReject(om.client, om.msg.SuggestionID)
+			case MsgComment:
+				s.handleComment(om)
+			case MsgReply:
+				s.handleReply(om.client, om.msg)
+			case MsgResolve:
+				s.handleResolve(om.client, om.msg.ThreadID)
+			case MsgCursor:
+				s.handleCursor(om)
+			case MsgAwareness:
+				s.handleAwareness(om.client, om.msg.State)
+			case MsgChat:
+				s.handleChat(om.client, om.msg.Body)
+			default:
+				s.handleOp(om)
+			}
+		case req := <-s.restore:
+			revision, err := s.handleRestore(req.revision, req.author)
+			req.reply <- editResult{revision: revision, err: err}
+		case req := <-s.merge:
+			revision, err := s.handleMerge(req.fork)
+			req.reply <- editResult{revision: revision, err: err}
+		case reply := <-s.blames:
+			b, err := s.currentBlame()
+			reply <- blameResult{blame: b, revision: s.doc.Version, err: err}
+		case now := <-expire.C:
+			s.expireAwareness(now)
 		case <-s.stop:
+			s.persist()
 			return
 		}
 	}
@@ -69,13 +192,38 @@ func (s *Session) handleJoin(c *Client) {
 
 	// Send current document state to the joining client.
 	clients := s.clientInfos()
-	c.sendMsg(ServerMessage{
+	doc := ServerMessage{
 		Type:     MsgDoc,
 		DocID:    s.docID,
-		Content:  s.doc.Content,
+		ClientID: c.ID,
+		UserID:   c.UserID,
+		DocType:  s.doc.Type.Name(),
+		Content:  s.doc.Serialized(),
 		Revision: s.doc.Version,
 		Clients:  clients,
-	})
+		Role:     c.Role(),
+
+		Suggestions: s.suggestions,
+		Threads:     s.threads,
+		Cursors:     s.cursorList(c),
+		Awareness:   s.awarenessList(c),
+		ChatHistory: s.chat,
+	}
+	c.mu.Lock()
+	since := c.since
+	c.mu.Unlock()
+	if since != nil {
+		// A rejoining client catches up on the edits it missed, so it can
+		// tell which of its own were applied and transform the rest.
+		if *since < s.doc.Base || *since > s.doc.Version {
+			doc.Code = CodeResync
+		} else {
+			for _, e := range s.doc.History[*since-s.doc.Base:] {
+				doc.Edits = append(doc.Edits, HistoryEdit{Op: e.Op, ClientID: e.Site, UserID: e.Author, Seq: e.Seq})
+			}
+		}
+	}
+	c.sendMsg(doc)
 
 	// Notify other clients about the new user.
 	for other := range s.clients {
@@ -83,8 +231,10 @@ func (s *Session) handleJoin(c *Client) {
 			other.sendMsg(ServerMessage{
 				Type:     MsgJoin,
 				ClientID: c.ID,
+				UserID:   c.UserID,
 				Name:     c.Name,
 				Color:    c.Color,
+				Role:     c.Role(),
 			})
 		}
 	}
@@ -95,6 +245,10 @@ func (s *Session) handleLeave(c *Client) {
 		return
 	}
 	delete(s.clients, c)
+	delete(s.undo, c.ID)
+	delete(s.redo, c.ID)
+	delete(s.cursors, c.ID)
+	delete(s.awareness, c.ID)
 	c.mu.Lock()
 	c.session = nil
 	c.mu.Unlock()
@@ -110,46 +264,439 @@ func (s *Session) handleLeave(c *Client) {
 }
 
 func (s *Session) handleOp(om opMessage) {
+	if !om.client.canEdit() {
+		om.client.sendErrorCode(CodeForbidden, "reviewers can only suggest edits")
+		return
+	}
+
+	// Decode, validate and normalize the operation for this document's
+	// type, rejecting malformed ones before they reach the engine.
+	op, err := s.doc.Type.DecodeOp(om.msg.Op)
+	if err != nil {
+		om.client.sendErrorCode(CodeInvalidOp, "invalid operation: "+err.Error())
+		return
+	}
+
 	// Transform the client's operation against server history.
-	transformed, err := s.engine.TransformIncoming(om.msg.Op, om.msg.Revision, s.doc.History)
+	edit := ot.Edit{Op: op, Site: om.client.ID, Author: om.client.UserID}
+	transformed, err := s.engine.TransformIncoming(s.doc.Type, edit, om.msg.Revision, s.doc.Base, s.doc.History)
+	if errors.Is(err, ot.ErrRevisionTooOld) {
+		om.client.sendErrorCode(CodeResync, err.Error())
+		return
+	}
 	if err != nil {
 		log.Printf("session %s: transform error: %v", s.docID, err)
 		om.client.sendError("transform error: " + err.Error())
 		return
 	}
 
+	fix, err := s.correction(edit, om.msg.Revision, transformed)
+	if err != nil {
+		log.Printf("session %s: correction error: %v", s.docID, err)
+		om.client.sendError("transform error: " + err.Error())
+		return
+	}
+
 	// Apply to the document.
-	if err := s.doc.Apply(transformed); err != nil {
+	applied := ot.Edit{Op: transformed, Site: om.client.ID, Author: om.client.UserID, Seq: om.msg.Seq}
+	inverse, err := s.apply(applied)
+	if err != nil {
 		log.Printf("session %s: apply error: %v", s.docID, err)
 		om.client.sendError("apply error: " + err.Error())
 		return
 	}
-
-	// Persist.
-	ctx := context.Background()
-	s.store.UpdateContent(ctx, s.docID, s.doc.Content, s.doc.Version)
-	s.store.AppendOperation(ctx, s.docID, transformed, s.doc.Version)
+	if inverse != nil && !s.doc.Type.IsNoop(transformed) {
+		pushUndo(s.undo, om.client.ID, undoEntry{inverse: inverse, version: s.doc.Version})
+		delete(s.redo, om.client.ID)
+	}
 
 	// Ack the sender.
 	om.client.sendMsg(ServerMessage{
 		Type:     MsgAck,
 		Revision: s.doc.Version,
+		Op:       fix,
 	})
 
 	// Broadcast to other clients.
+	s.broadcastOp(applied, om.client)
+}
+
+// correction returns the operation that takes the sender's copy of the
+// document to the server's once e, made at revision, has been applied as
+// result. Clients transform their unacknowledged edits against remote ones
+// the way JupiterEngine does, so it is nil unless the engine resolved e
+// differently.
+func (s *Session) correction(e ot.Edit, revision int, result ot.Op) (ot.Op, error) {
+	if _, ok := s.engine.(*ot.JupiterEngine); ok {
+		return nil, nil
+	}
+	expected, err := (&ot.JupiterEngine{}).TransformIncoming(s.doc.Type, e, revision, s.doc.Base, s.doc.History)
+	if err != nil {
+		return nil, err
+	}
+	t := s.doc.Type
+	snapshot := s.doc.Snapshot()
+	clientCopy, err := t.Apply(snapshot, expected)
+	if err != nil {
+		return nil, err
+	}
+	serverCopy, err := t.Apply(snapshot, result)
+	if err != nil {
+		return nil, err
+	}
+	a, err := t.Serialize(clientCopy)
+	if err != nil {
+		return nil, err
+	}
+	b, err := t.Serialize(serverCopy)
+	if err != nil || a == b {
+		return nil, err
+	}
+	inv, ok := t.(ot.Inverter)
+	if !ok {
+		return nil, fmt.Errorf("can't correct %s documents", t.Name())
+	}
+	undo, err := inv.Invert(expected, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return t.Compose(undo, result)
+}
+
+// apply applies e to the document and persists it. It returns the
+// operation that reverts e, or nil if the document's type can't invert
+// operations.
+func (s *Session) apply(e ot.Edit) (ot.Op, error) {
+	var inverse ot.Op
+	if inv, ok := s.doc.Type.(ot.Inverter); ok {
+		var err error
+		if inverse, err = inv.Invert(e.Op, s.doc.Snapshot()); err != nil {
+			return nil, err
+		}
+	}
+	version := s.doc.Version
+	if err := s.doc.ApplyEdit(e); err != nil {
+		return nil, err
+	}
+	if s.doc.Version == version {
+		// A no-op: there is nothing to record, and the stored history
+		// must keep one edit per revision.
+		return inverse, nil
+	}
+	s.transformSuggestions(e.Op)
+	s.transformThreads(e.Op)
+	s.transformCursors(e.Op)
+
+	// Persist. The content is saved only with each snapshot, as
+	// serializing it takes time proportional to its length; loading
+	// replays the edits since.
+	s.store.AppendOperation(context.Background(), s.docID, e, s.doc.Version)
+	if s.doc.Version%snapshotInterval == 0 {
+		s.persist()
+	}
+
+	if s.blameLoaded {
+		b, err := s.doc.Type.(ot.Blamer).ApplyBlame(s.blame, e.Op, e.Author)
+		if err != nil {
+			// Reload it next time it's asked for.
+			log.Printf("session %s: blame error: %v", s.docID, err)
+			s.blameLoaded = false
+		}
+		s.blame = b
+	}
+	return inverse, nil
+}
+
+// persist saves the document's content as a snapshot at its current
+// version, with the comment threads' anchors.
+func (s *Session) persist() {
+	ctx := context.Background()
+	content := s.doc.Serialized()
+	if err := s.store.SaveSnapshot(ctx, s.docID, store.Snapshot{Version: s.doc.Version, Content: content}); err != nil {
+		log.Printf("session %s: save snapshot: %v", s.docID, err)
+	}
+	if err := s.store.UpdateContent(ctx, s.docID, content, s.doc.Version); err != nil {
+		log.Printf("session %s: save content: %v", s.docID, err)
+	}
+	s.storeAnchors()
+}
+
+// broadcastOp sends an applied edit to every client except skip, which may
+// be nil. Its clientId is the edit's site, which clients use to break ties
+// the way the engine does, and its userId the edit's author.
+func (s *Session) broadcastOp(e ot.Edit, skip *Client) {
 	for c := range s.clients {
-		if c != om.client {
+		if c != skip {
 			c.sendMsg(ServerMessage{
 				Type:     MsgOp,
 				DocID:    s.docID,
 				Revision: s.doc.Version,
-				Op:       transformed,
-				ClientID: om.client.ID,
+				Op:       e.Op,
+				Seq:      e.Seq,
+				ClientID: e.Site,
+				UserID:   e.Author,
 			})
 		}
 	}
 }
 
+func (s *Session) handleUndo(c *Client) {
+	if _, ok := s.doc.Type.(ot.Inverter); !ok {
+		c.sendError("undo is not supported for " + s.doc.Type.Name() + " documents")
+		return
+	}
+	if !s.revert(c, s.undo, s.redo) {
+		c.sendError("nothing to undo")
+	}
+}
+
+func (s *Session) handleRedo(c *Client) {
+	if _, ok := s.doc.Type.(ot.Inverter); !ok {
+		c.sendError("redo is not supported for " + s.doc.Type.Name() + " documents")
+		return
+	}
+	if !s.revert(c, s.redo, s.undo) {
+		c.sendError("nothing to redo")
+	}
+}
+
+// revert pops c's most recent entry from the from stack, transforms it past
+// every later operation so collaborators' edits are kept, and applies it.
+// The reverting operation's own inverse is pushed onto the to stack. Entries
+// whose changes were already removed by others are skipped. Since the
+// client did not author the result locally, it is broadcast to everyone,
+// including c. It reports false if there was nothing to revert.
+func (s *Session) revert(c *Client, from, to map[string][]undoEntry) bool {
+	for stack := from[c.ID]; len(stack) > 0; stack = from[c.ID] {
+		e := stack[len(stack)-1]
+		from[c.ID] = stack[:len(stack)-1]
+
+		op, err := s.engine.TransformIncoming(s.doc.Type, ot.Edit{Op: e.inverse, Site: c.ID}, e.version, s.doc.Base, s.doc.History)
+		if errors.Is(err, ot.ErrRevisionTooOld) {
+			// The rest of the stack is older still.
+			delete(from, c.ID)
+			return false
+		}
+		if err != nil {
+			log.Printf("session %s: undo transform error: %v", s.docID, err)
+			c.sendError("transform error: " + err.Error())
+			return true
+		}
+		if s.doc.Type.IsNoop(op) {
+			continue
+		}
+
+		applied := ot.Edit{Op: op, Site: c.ID, Author: c.UserID}
+		inverse, err := s.apply(applied)
+		if err != nil {
+			log.Printf("session %s: undo apply error: %v", s.docID, err)
+			c.sendError("apply error: " + err.Error())
+			return true
+		}
+		pushUndo(to, c.ID, undoEntry{inverse: inverse, version: s.doc.Version})
+		s.broadcastOp(applied, nil)
+		return true
+	}
+	return false
+}
+
+// Restore brings the document back to its content at a past revision, on
+// behalf of author. The change is applied as a new edit and broadcast to
+// every client, so history and undo are kept. It returns the document's
+// revision afterwards. It is safe to call from any goroutine.
+func (s *Session) Restore(revision int, author string) (int, error) {
+	reply := make(chan editResult, 1)
+	s.restore <- restoreRequest{revision: revision, author: author, reply: reply}
+	r := <-reply
+	return r.revision, r.err
+}
+
+// handleRestore applies the operation that takes the document from its
+// current revision back to revision: the inverse of every edit made since,
+// composed.
+func (s *Session) handleRestore(revision int, author string) (int, error) {
+	inv, ok := s.doc.Type.(ot.Inverter)
+	if !ok {
+		return s.doc.Version, fmt.Errorf("restore is not supported for %s documents", s.doc.Type.Name())
+	}
+	if revision < 0 || revision > s.doc.Version {
+		return s.doc.Version, fmt.Errorf("no revision %d (document at %d)", revision, s.doc.Version)
+	}
+	if revision == s.doc.Version {
+		return s.doc.Version, nil
+	}
+
+	ctx := context.Background()
+	past, err := loadRevision(ctx, s.store, s.docID, revision)
+	if err != nil {
+		return s.doc.Version, err
+	}
+	diff, err := loadDiff(ctx, s.store, s.docID, revision, s.doc.Version)
+	if err != nil {
+		return s.doc.Version, err
+	}
+	op, err := inv.Invert(diff, past.Snapshot())
+	if err != nil {
+		return s.doc.Version, err
+	}
+	if s.doc.Type.IsNoop(op) {
+		return s.doc.Version, nil
+	}
+	// The author stands in for a site, so clients break ties against the
+	// edit as the engine does.
+	applied := ot.Edit{Op: op, Site: author, Author: author}
+	if _, err := s.apply(applied); err != nil {
+		return s.doc.Version, err
+	}
+	s.broadcastOp(applied, nil)
+	return s.doc.Version, nil
+}
+
+// Merge applies the edits made in fork, a fork of the session's document,
+// transformed past the edits made here since the fork. Each keeps its
+// author and is broadcast to every client. Either every edit is applied or,
+// if any can't be, none are. A fork can be merged once. It
+// returns the document's revision afterwards. It is safe to call from any
+// goroutine.
+func (s *Session) Merge(fork string) (int, error) {
+	reply := make(chan editResult, 1)
+	s.merge <- mergeRequest{fork: fork, reply: reply}
+	r := <-reply
+	return r.revision, r.err
+}
+
+// handleMerge merges fork. It runs in the session's goroutine, so merges
+// of the document's forks can't race each other.
+func (s *Session) handleMerge(fork string) (int, error) {
+	ctx := context.Background()
+	f, err := s.store.GetFork(ctx, fork)
+	if err != nil {
+		return s.doc.Version, err
+	}
+	if f == nil || f.Parent != s.docID {
+		return s.doc.Version, fmt.Errorf("%q is not a fork of %q", fork, s.docID)
+	}
+	if f.Merged {
+		return s.doc.Version, errForkMerged
+	}
+	if f.Revision > s.doc.Version {
+		return s.doc.Version, fmt.Errorf("fork made at revision %d, document at %d", f.Revision, s.doc.Version)
+	}
+	edits, err := s.store.GetOperations(ctx, fork, 0)
+	if err != nil {
+		return s.doc.Version, err
+	}
+
+	// Each fork edit is concurrent with the document's edits since the
+	// fork; those are composed into one operation, which is transformed
+	// past each fork edit in turn. The document's edits win ties, so its
+	// text stays first. Every edit is checked against a copy of the
+	// document before any is applied, so a merge is all or nothing.
+	t := s.doc.Type
+	since, err := loadDiff(ctx, s.store, s.docID, f.Revision, s.doc.Version)
+	if err != nil {
+		return s.doc.Version, err
+	}
+	check, err := ot.NewTypedDocument(t, s.doc.Serialized())
+	if err != nil {
+		return s.doc.Version, err
+	}
+	check.LazyContent = true
+	inv, _ := t.(ot.Inverter)
+	var merged []ot.Edit
+	for i, e := range edits {
+		op := e.Op
+		if since != nil {
+			if since, op, err = t.Transform(since, op); err != nil {
+				return s.doc.Version, fmt.Errorf("fork edit %d: %w", i, err)
+			}
+		}
+		if t.IsNoop(op) {
+			continue
+		}
+		if inv != nil {
+			if _, err := inv.Invert(op, check.Snapshot()); err != nil {
+				return s.doc.Version, fmt.Errorf("fork edit %d: %w", i, err)
+			}
+		}
+		if err := check.Apply(op); err != nil {
+			return s.doc.Version, fmt.Errorf("fork edit %d: %w", i, err)
+		}
+		merged = append(merged, ot.Edit{Op: op, Site: e.Site, Author: e.Author})
+	}
+
+	// The fork is recorded as merged first, so that if this fails
+	// nothing is applied, and a retry can't apply the edits twice.
+	f.Merged, f.MergedRevision = true, s.doc.Version+len(merged)
+	if err := s.store.SaveFork(ctx, fork, *f); err != nil {
+		return s.doc.Version, err
+	}
+	for _, e := range merged {
+		if _, err := s.apply(e); err != nil {
+			// It applied to the copy, so this is a bug.
+			return s.doc.Version, fmt.Errorf("apply merged edit: %w", err)
+		}
+		s.broadcastOp(e, nil)
+	}
+	return s.doc.Version, nil
+}
+
+func (s *Session) handleBlame(c *Client) {
+	if _, ok := s.doc.Type.(ot.Blamer); !ok {
+		c.sendError("blame is not supported for " + s.doc.Type.Name() + " documents")
+		return
+	}
+	b, err := s.currentBlame()
+	if err != nil {
+		log.Printf("session %s: blame error: %v", s.docID, err)
+		c.sendError("failed to load blame")
+		return
+	}
+	c.sendMsg(ServerMessage{
+		Type:     MsgBlame,
+		DocID:    s.docID,
+		Revision: s.doc.Version,
+		Blame:    b,
+	})
+}
+
+// Blame returns the document's blame and the revision it is for. The
+// document's type must be an ot.Blamer. It is safe to call from any
+// goroutine.
+func (s *Session) Blame() (ot.Blame, int, error) {
+	reply := make(chan blameResult, 1)
+	s.blames <- reply
+	r := <-reply
+	return r.blame, r.revision, r.err
+}
+
+// currentBlame returns the document's blame, loading it from the store the
+// first time; apply keeps it up to date from then on.
+func (s *Session) currentBlame() (ot.Blame, error) {
+	if s.blameLoaded {
+		return s.blame, nil
+	}
+	b, version, err := loadBlame(context.Background(), s.store, s.docID)
+	if err == nil && version != s.doc.Version {
+		err = fmt.Errorf("stored history is at revision %d, document at %d", version, s.doc.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.blame, s.blameLoaded = b, true
+	return b, nil
+}
+
+// pushUndo appends e to id's stack in stacks, dropping the oldest entry
+// once the stack exceeds maxUndoDepth.
+func pushUndo(stacks map[string][]undoEntry, id string, e undoEntry) {
+	stack := append(stacks[id], e)
+	if len(stack) > maxUndoDepth {
+		stack = stack[len(stack)-maxUndoDepth:]
+	}
+	stacks[id] = stack
+}
+
 func (s *Session) clientInfos() []ClientInfo {
 	infos := make([]ClientInfo, 0, len(s.clients))
 	for c := range s.clients {
@@ -3,6 +3,9 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -15,13 +18,20 @@ func ctx() context.Context { return context.Background() }
 // mockClient creates a client without a real WebSocket connection, for testing.
 func mockClient(id string) *Client {
 	return &Client{
-		ID:    id,
-		Name:  "Test " + id,
-		Color: "#000000",
-		send:  make(chan []byte, 256),
+		ID:     id,
+		UserID: id,
+		Name:   "Test " + id,
+		Color:  "#000000",
+		send:   make(chan []byte, 256),
 	}
 }
 
+// rawOp encodes an operation for a ClientMessage.
+func rawOp(op ot.Op) json.RawMessage {
+	b, _ := json.Marshal(op)
+	return b
+}
+
 // recvMsg reads one message from a mock client's send channel with timeout.
 func recvMsg(t *testing.T, c *Client) ServerMessage {
 	t.Helper()
@@ -42,7 +52,7 @@ func TestSession_JoinAndReceiveDoc(t *testing.T) {
 	st := store.NewMemoryStore()
 	st.Create(ctx(), "doc1", "hello")
 	engine := &ot.JupiterEngine{}
-	s := newSession("doc1", "hello", 0, nil, engine, st)
+	s := newSession("doc1", ot.NewDocument("hello"), engine, st)
 	go s.Run()
 	defer close(s.stop)
 
@@ -59,13 +69,65 @@ func TestSession_JoinAndReceiveDoc(t *testing.T) {
 	if msg.Revision != 0 {
 		t.Errorf("revision = %d, want 0", msg.Revision)
 	}
+	if msg.ClientID != "c1" {
+		t.Errorf("clientId = %q, want %q", msg.ClientID, "c1")
+	}
+}
+
+func TestSession_JoinSince(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "")
+	doc := ot.NewDocument("")
+	doc.HistoryLimit = 2
+	s := newSession("doc1", doc, &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+	for i, text := range []string{"a", "b", "c"} {
+		op := ot.NewInsert(i, text, i)
+		s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: i, Op: rawOp(op), Seq: i + 1}}
+		recvMsg(t, c1) // ack
+	}
+
+	// A client rejoining at revision 1 gets the edits made since, with
+	// their senders' numbers.
+	since := 1
+	c2 := mockClient("c2")
+	c2.since = &since
+	s.join <- c2
+	msg := recvMsg(t, c2)
+	if msg.Type != MsgDoc || msg.Code != "" {
+		t.Fatalf("got %s with code %q, want doc", msg.Type, msg.Code)
+	}
+	if len(msg.Edits) != 2 {
+		t.Fatalf("got %d edits, want 2", len(msg.Edits))
+	}
+	if e := msg.Edits[1]; e.ClientID != "c1" || e.UserID != "c1" || e.Seq != 3 {
+		t.Errorf("edit = %+v, want c1's edit 3", e)
+	}
+
+	// The first edit is no longer in the history.
+	since = 0
+	c3 := mockClient("c3")
+	c3.since = &since
+	s.join <- c3
+	msg = recvMsg(t, c3)
+	if msg.Type != MsgDoc || msg.Code != CodeResync || msg.Edits != nil {
+		t.Errorf("got %s with code %q and %d edits, want doc with code %q", msg.Type, msg.Code, len(msg.Edits), CodeResync)
+	}
+	if msg.Content != "abc" {
+		t.Errorf("content = %q, want %q", msg.Content, "abc")
+	}
 }
 
 func TestSession_OpTransformAndBroadcast(t *testing.T) {
 	st := store.NewMemoryStore()
 	st.Create(ctx(), "doc1", "abc")
 	engine := &ot.JupiterEngine{}
-	s := newSession("doc1", "abc", 0, nil, engine, st)
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
 	go s.Run()
 	defer close(s.stop)
 
@@ -79,7 +141,7 @@ func TestSession_OpTransformAndBroadcast(t *testing.T) {
 
 	// c1 sends an insert at position 0
 	op := ot.NewInsert(0, "X", 3)
-	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: op}}
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: rawOp(op)}}
 
 	// c1 should get ack
 	ack := recvMsg(t, c1)
@@ -103,8 +165,8 @@ func TestSession_OpTransformAndBroadcast(t *testing.T) {
 	}
 
 	// Verify document state
-	if s.doc.Content != "Xabc" {
-		t.Errorf("doc content = %q, want %q", s.doc.Content, "Xabc")
+	if s.doc.Serialized() != "Xabc" {
+		t.Errorf("doc content = %q, want %q", s.doc.Serialized(), "Xabc")
 	}
 }
 
@@ -112,7 +174,7 @@ func TestSession_ConcurrentOps(t *testing.T) {
 	st := store.NewMemoryStore()
 	st.Create(ctx(), "doc1", "abc")
 	engine := &ot.JupiterEngine{}
-	s := newSession("doc1", "abc", 0, nil, engine, st)
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
 	go s.Run()
 	defer close(s.stop)
 
@@ -129,21 +191,57 @@ func TestSession_ConcurrentOps(t *testing.T) {
 	// c2 inserts "Y" at pos 3: "abcY"
 	s.incoming <- opMessage{
 		client: c1,
-		msg:    ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: ot.NewInsert(0, "X", 3)},
+		msg:    ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: rawOp(ot.NewInsert(0, "X", 3))},
 	}
 	recvMsg(t, c1) // ack
 	recvMsg(t, c2) // broadcast
 
 	s.incoming <- opMessage{
 		client: c2,
-		msg:    ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: ot.NewInsert(3, "Y", 3)},
+		msg:    ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: rawOp(ot.NewInsert(3, "Y", 3))},
 	}
 	recvMsg(t, c2) // ack
 	recvMsg(t, c1) // broadcast
 
 	// After both ops, doc should be "XabcY"
-	if s.doc.Content != "XabcY" {
-		t.Errorf("doc content = %q, want %q", s.doc.Content, "XabcY")
+	if s.doc.Serialized() != "XabcY" {
+		t.Errorf("doc content = %q, want %q", s.doc.Serialized(), "XabcY")
+	}
+}
+
+func TestSession_ConcurrentInsertsOrderedByClient(t *testing.T) {
+	for _, first := range []string{"c1", "c2"} {
+		t.Run(first+" arrives first", func(t *testing.T) {
+			st := store.NewMemoryStore()
+			st.Create(ctx(), "doc1", "ab")
+			s := newSession("doc1", ot.NewDocument("ab"), &ot.JupiterEngine{}, st)
+			go s.Run()
+			defer close(s.stop)
+
+			clients := map[string]*Client{"c1": mockClient("c1"), "c2": mockClient("c2")}
+			inserts := map[string]string{"c1": "X", "c2": "Y"}
+			order := []string{first, map[string]string{"c1": "c2", "c2": "c1"}[first]}
+			for _, id := range order {
+				s.join <- clients[id]
+				recvMsg(t, clients[id]) // doc
+			}
+			recvMsg(t, clients[order[0]]) // join notification
+
+			// Both insert at position 1 at revision 0.
+			for _, id := range order {
+				s.incoming <- opMessage{
+					client: clients[id],
+					msg:    ClientMessage{Type: MsgOp, DocID: "doc1", Revision: 0, Op: rawOp(ot.NewInsert(1, inserts[id], 2))},
+				}
+				for recvMsg(t, clients[id]).Type != MsgAck {
+				}
+			}
+
+			// c1 has the lower ID, so its text goes first either way.
+			if s.doc.Serialized() != "aXYb" {
+				t.Errorf("doc content = %q, want %q", s.doc.Serialized(), "aXYb")
+			}
+		})
 	}
 }
 
@@ -151,7 +249,7 @@ func TestSession_LeaveNotification(t *testing.T) {
 	st := store.NewMemoryStore()
 	st.Create(ctx(), "doc1", "")
 	engine := &ot.JupiterEngine{}
-	s := newSession("doc1", "", 0, nil, engine, st)
+	s := newSession("doc1", ot.NewDocument(""), engine, st)
 	go s.Run()
 	defer close(s.stop)
 
@@ -172,3 +270,839 @@ func TestSession_LeaveNotification(t *testing.T) {
 		t.Errorf("leave clientId = %q, want %q", msg.ClientID, "c2")
 	}
 }
+
+func TestSession_UndoRedo(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	engine := &ot.JupiterEngine{}
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(3, "X", 3))}}
+	recvMsg(t, c1) // ack
+	recvMsg(t, c2) // broadcast
+
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgUndo}}
+
+	// Both clients, including the one undoing, receive the reverting op.
+	for _, c := range []*Client{c1, c2} {
+		msg := recvMsg(t, c)
+		if msg.Type != MsgOp {
+			t.Fatalf("%s: expected op, got %q", c.ID, msg.Type)
+		}
+		if msg.Revision != 2 || msg.ClientID != "c1" {
+			t.Errorf("%s: revision=%d clientId=%q, want 2 and c1", c.ID, msg.Revision, msg.ClientID)
+		}
+	}
+	if s.doc.Serialized() != "abc" {
+		t.Errorf("after undo: %q, want %q", s.doc.Serialized(), "abc")
+	}
+
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgRedo}}
+	recvMsg(t, c1)
+	recvMsg(t, c2)
+	if s.doc.Serialized() != "abcX" {
+		t.Errorf("after redo: %q, want %q", s.doc.Serialized(), "abcX")
+	}
+}
+
+func TestSession_UndoKeepsCollaboratorEdits(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	engine := &ot.JupiterEngine{}
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	// c1 inserts "X" at the start, then c2 appends "Y".
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(0, "X", 3))}}
+	recvMsg(t, c1) // ack
+	recvMsg(t, c2) // broadcast
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgOp, Revision: 1, Op: rawOp(ot.NewInsert(4, "Y", 4))}}
+	recvMsg(t, c2) // ack
+	recvMsg(t, c1) // broadcast
+
+	// c1's undo removes only its own "X".
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgUndo}}
+	recvMsg(t, c1)
+	recvMsg(t, c2)
+	if s.doc.Serialized() != "abcY" {
+		t.Errorf("after undo: %q, want %q", s.doc.Serialized(), "abcY")
+	}
+
+	// c2 has nothing of its own left to undo after its one op is undone.
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgUndo}}
+	recvMsg(t, c1)
+	recvMsg(t, c2)
+	if s.doc.Serialized() != "abc" {
+		t.Errorf("after c2 undo: %q, want %q", s.doc.Serialized(), "abc")
+	}
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgUndo}}
+	if msg := recvMsg(t, c2); msg.Type != MsgError {
+		t.Errorf("expected error, got %q", msg.Type)
+	}
+}
+
+func TestSession_UndoSkipsChangesRemovedByOthers(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	engine := &ot.JupiterEngine{}
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	// c1 inserts "X", then c2 deletes it.
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(1, "X", 3))}}
+	recvMsg(t, c1) // ack
+	recvMsg(t, c2) // broadcast
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgOp, Revision: 1, Op: rawOp(ot.NewDelete(1, 1, 4))}}
+	recvMsg(t, c2) // ack
+	recvMsg(t, c1) // broadcast
+
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgUndo}}
+	if msg := recvMsg(t, c1); msg.Type != MsgError {
+		t.Errorf("expected error, got %q", msg.Type)
+	}
+	if s.doc.Serialized() != "abc" || s.doc.Version != 2 {
+		t.Errorf("doc changed: %q v%d", s.doc.Serialized(), s.doc.Version)
+	}
+}
+
+func TestSession_RejectsInvalidOp(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	engine := &ot.JupiterEngine{}
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+
+	bad := ot.Operation{Ops: []ot.Component{{Retain: 3, Insert: "X"}}}
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(bad)}}
+
+	msg := recvMsg(t, c1)
+	if msg.Type != MsgError || msg.Code != CodeInvalidOp {
+		t.Fatalf("expected %s error, got type=%q code=%q", CodeInvalidOp, msg.Type, msg.Code)
+	}
+	if s.doc.Serialized() != "abc" || s.doc.Version != 0 {
+		t.Errorf("doc changed: %q v%d", s.doc.Serialized(), s.doc.Version)
+	}
+}
+
+func TestSession_NormalizesOp(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	engine := &ot.JupiterEngine{}
+	s := newSession("doc1", ot.NewDocument("abc"), engine, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+
+	op := ot.Operation{Ops: []ot.Component{{Retain: 1}, {}, {Retain: 2}, {Insert: "X"}, {Insert: "Y"}}}
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(op)}}
+	if msg := recvMsg(t, c1); msg.Type != MsgAck {
+		t.Fatalf("expected ack, got %q", msg.Type)
+	}
+	if got := s.doc.History[0].Op.(ot.Operation).Ops; len(got) != 2 {
+		t.Errorf("stored op not normalized: %+v", got)
+	}
+}
+
+func TestSession_CorrectsAuthorForCRDTEngine(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "xy")
+	s := newSession("doc1", ot.NewDocument("xy"), &ot.CRDTEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	clients := map[string]*Client{"c0": mockClient("c0"), "c1": mockClient("c1"), "c2": mockClient("c2")}
+	for _, id := range []string{"c0", "c1", "c2"} {
+		s.join <- clients[id]
+	}
+	send := func(id string, revision int, op ot.Operation) ServerMessage {
+		s.incoming <- opMessage{client: clients[id], msg: ClientMessage{Type: MsgOp, Revision: revision, Op: rawOp(op)}}
+		for {
+			if msg := recvMsg(t, clients[id]); msg.Type == MsgAck {
+				return msg
+			}
+		}
+	}
+
+	// c0 deletes everything while c1 inserts between x and y. c2 then
+	// inserts into the empty document without having seen c1's insert.
+	send("c0", 0, ot.NewDelete(0, 2, 2))
+	if msg := send("c1", 0, ot.NewInsert(1, "A", 2)); msg.Op != nil {
+		t.Errorf("unexpected correction for c1: %+v", msg.Op)
+	}
+	msg := send("c2", 1, ot.NewInsert(0, "B", 0))
+
+	// c2 transformed its insert against c1's and, having the higher ID,
+	// put it second. The sequence puts it first.
+	if s.doc.Serialized() != "BA" {
+		t.Fatalf("doc content = %q, want %q", s.doc.Serialized(), "BA")
+	}
+	if msg.Op == nil {
+		t.Fatal("expected a correction in c2's ack")
+	}
+	var fix ot.Operation
+	if err := json.Unmarshal(rawOp(msg.Op), &fix); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ot.Apply("AB", fix); err != nil || got != "BA" {
+		t.Errorf("Apply(c2's copy, correction) = %q, %v; want %q", got, err, "BA")
+	}
+}
+
+func TestSession_ResyncWhenTooFarBehind(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	doc := ot.NewDocument("abc")
+	doc.HistoryLimit = 1
+	s := newSession("doc1", doc, &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(0, "X", 3))}}
+	recvMsg(t, c1) // ack
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 1, Op: rawOp(ot.NewInsert(0, "Y", 4))}}
+	recvMsg(t, c1) // ack
+
+	// Revision 0 is no longer in the history.
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(3, "Z", 3))}}
+	msg := recvMsg(t, c1)
+	if msg.Type != MsgError || msg.Code != CodeResync {
+		t.Fatalf("expected %s error, got type=%q code=%q", CodeResync, msg.Type, msg.Code)
+	}
+
+	// The first edit can't be undone any more either.
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgUndo}}
+	if msg := recvMsg(t, c1); msg.Type != MsgOp {
+		t.Fatalf("expected op, got %q", msg.Type)
+	}
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgUndo}}
+	if msg := recvMsg(t, c1); msg.Type != MsgError {
+		t.Fatalf("expected error, got %q", msg.Type)
+	}
+	if s.doc.Serialized() != "Xabc" {
+		t.Errorf("doc content = %q, want %q", s.doc.Serialized(), "Xabc")
+	}
+}
+
+func TestSession_Blame(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	s := newSession("doc1", ot.NewDocument("abc"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(3, "XY", 3))}}
+	recvMsg(t, c1) // ack
+	recvMsg(t, c2) // broadcast
+
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgBlame}}
+	msg := recvMsg(t, c2)
+	if msg.Type != MsgBlame || msg.Revision != 1 {
+		t.Fatalf("got type=%q revision=%d, want blame at 1", msg.Type, msg.Revision)
+	}
+	if want := (ot.Blame{{Author: "", Len: 3}, {Author: "c1", Len: 2}}); !reflect.DeepEqual(msg.Blame, want) {
+		t.Errorf("blame = %v, want %v", msg.Blame, want)
+	}
+
+	// Once loaded, blame follows new edits.
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgOp, Revision: 1, Op: rawOp(ot.NewDelete(0, 4, 5))}}
+	recvMsg(t, c2) // ack
+	recvMsg(t, c1) // broadcast
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgBlame}}
+	if msg := recvMsg(t, c1); !reflect.DeepEqual(msg.Blame, ot.Blame{{Author: "c1", Len: 1}}) {
+		t.Errorf("blame = %v, want [{c1 1}]", msg.Blame)
+	}
+}
+
+func TestSession_Restore(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	s := newSession("doc1", ot.NewDocument("abc"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+
+	for i, op := range []ot.Operation{ot.NewInsert(3, "def", 3), ot.NewDelete(0, 2, 6)} {
+		s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: i, Op: rawOp(op)}}
+		recvMsg(t, c1) // ack
+	}
+
+	revision, err := s.Restore(1, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 3 {
+		t.Errorf("revision = %d, want 3", revision)
+	}
+	msg := recvMsg(t, c1)
+	if msg.Type != MsgOp || msg.Revision != 3 || msg.ClientID != "bob" {
+		t.Fatalf("got type=%q revision=%d clientId=%q, want op at 3 by bob", msg.Type, msg.Revision, msg.ClientID)
+	}
+	var op ot.Operation
+	if err := json.Unmarshal(rawOp(msg.Op), &op); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ot.Apply("cdef", op); got != "abcdef" {
+		t.Errorf("broadcast op gives %q, want %q", got, "abcdef")
+	}
+	if s.doc.Serialized() != "abcdef" {
+		t.Errorf("content = %q, want %q", s.doc.Serialized(), "abcdef")
+	}
+
+	if _, err := s.Restore(10, "bob"); err == nil {
+		t.Error("expected error for a revision the document hasn't reached")
+	}
+}
+
+func TestSession_Merge(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	if err := forkDocument(ctx(), st, "doc1", "fork1", 0); err != nil {
+		t.Fatal(err)
+	}
+	s := newSession("doc1", ot.NewDocument("abc"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+
+	// The document and the fork are edited concurrently.
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(0, "X", 3))}}
+	recvMsg(t, c1) // ack
+	st.AppendOperation(ctx(), "fork1", ot.Edit{Op: ot.NewInsert(3, "Y", 3), Site: "alice", Author: "alice"}, 1)
+	st.AppendOperation(ctx(), "fork1", ot.Edit{Op: ot.NewDelete(0, 1, 4), Site: "bob", Author: "bob"}, 2)
+
+	revision, err := s.Merge("fork1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 3 {
+		t.Errorf("revision = %d, want 3", revision)
+	}
+	for _, author := range []string{"alice", "bob"} {
+		if msg := recvMsg(t, c1); msg.Type != MsgOp || msg.ClientID != author {
+			t.Errorf("got type=%q clientId=%q, want op by %s", msg.Type, msg.ClientID, author)
+		}
+	}
+	if s.doc.Serialized() != "XbcY" {
+		t.Errorf("content = %q, want %q", s.doc.Serialized(), "XbcY")
+	}
+	edits, _ := st.GetOperations(ctx(), "doc1", 1)
+	if len(edits) != 2 || edits[0].Author != "alice" || edits[1].Author != "bob" {
+		t.Errorf("merged edits = %+v, want alice's then bob's", edits)
+	}
+
+	f, _ := st.GetFork(ctx(), "fork1")
+	if !f.Merged || f.MergedRevision != 3 {
+		t.Errorf("fork = %+v, want merged at 3", f)
+	}
+	if _, err := s.Merge("fork1"); !errors.Is(err, errForkMerged) {
+		t.Errorf("second merge: err = %v, want errForkMerged", err)
+	}
+	if _, err := s.Merge("doc1"); err == nil {
+		t.Error("expected error merging a document that isn't a fork")
+	}
+}
+
+func TestSession_MergeAllOrNothing(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "abc")
+	if err := forkDocument(ctx(), st, "doc1", "fork1", 0); err != nil {
+		t.Fatal(err)
+	}
+	s := newSession("doc1", ot.NewDocument("abc"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+
+	// The second fork edit doesn't fit the fork's text.
+	st.AppendOperation(ctx(), "fork1", ot.Edit{Op: ot.NewInsert(3, "Y", 3), Author: "alice"}, 1)
+	st.AppendOperation(ctx(), "fork1", ot.Edit{Op: ot.NewDelete(0, 1, 10), Author: "bob"}, 2)
+
+	if _, err := s.Merge("fork1"); err == nil {
+		t.Fatal("expected error for an edit that doesn't apply")
+	}
+	if s.doc.Serialized() != "abc" || s.doc.Version != 0 {
+		t.Errorf("doc changed: %q v%d", s.doc.Serialized(), s.doc.Version)
+	}
+	if f, _ := st.GetFork(ctx(), "fork1"); f.Merged {
+		t.Error("fork marked merged")
+	}
+	select {
+	case msg := <-c1.send:
+		t.Errorf("unexpected message: %s", msg)
+	default:
+	}
+}
+
+func TestSession_Suggestions(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "hello")
+	s := newSession("doc1", ot.NewDocument("hello"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	editor := mockClient("c1")
+	reviewer := mockClient("c2")
+	reviewer.role = RoleReviewer
+	s.join <- editor
+	s.join <- reviewer
+	recvMsg(t, editor)   // doc
+	recvMsg(t, reviewer) // doc
+	recvMsg(t, editor)   // c2 join
+
+	// Reviewers can't edit directly.
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(5, "!", 5))}}
+	if msg := recvMsg(t, reviewer); msg.Type != MsgError || msg.Code != CodeForbidden {
+		t.Fatalf("got type=%q code=%q, want forbidden error", msg.Type, msg.Code)
+	}
+
+	// A suggestion is announced to everyone.
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgSuggest, Revision: 0, Op: rawOp(ot.NewInsert(5, " world", 5))}}
+	var id string
+	for _, c := range []*Client{editor, reviewer} {
+		msg := recvMsg(t, c)
+		if msg.Type != MsgSuggestion || msg.Suggestion == nil || msg.Suggestion.Author != "c2" {
+			t.Fatalf("got %+v, want suggestion by c2", msg)
+		}
+		id = msg.Suggestion.ID
+	}
+
+	// It follows edits made meanwhile.
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(0, "oh ", 5))}}
+	recvMsg(t, editor)   // ack
+	recvMsg(t, reviewer) // op
+	want := ot.Operation{Ops: []ot.Component{{Retain: 8}, {Insert: " world"}}}
+	if len(s.suggestions) != 1 || !reflect.DeepEqual(s.suggestions[0].Op, want) {
+		t.Errorf("suggestions = %+v, want one with %v", s.suggestions, want)
+	}
+
+	// A late joiner gets the pending suggestions with the document.
+	late := mockClient("c3")
+	s.join <- late
+	if msg := recvMsg(t, late); len(msg.Suggestions) != 1 || msg.Suggestions[0].ID != id {
+		t.Errorf("doc suggestions = %+v, want %s", msg.Suggestions, id)
+	}
+	recvMsg(t, editor)   // c3 join
+	recvMsg(t, reviewer) // c3 join
+
+	// Only editors can accept.
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgAccept, SuggestionID: id}}
+	if msg := recvMsg(t, reviewer); msg.Code != CodeForbidden {
+		t.Fatalf("got %+v, want forbidden error", msg)
+	}
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgAccept, SuggestionID: id}}
+	for _, c := range []*Client{editor, reviewer, late} {
+		if msg := recvMsg(t, c); msg.Type != MsgOp || msg.ClientID != "c2" || msg.Revision != 2 {
+			t.Fatalf("got type=%q clientId=%q revision=%d, want op by c2 at 2", msg.Type, msg.ClientID, msg.Revision)
+		}
+		if msg := recvMsg(t, c); msg.Type != MsgAccept || msg.SuggestionID != id || msg.ClientID != "c1" {
+			t.Fatalf("got %+v, want accept of %s by c1", msg, id)
+		}
+	}
+	if s.doc.Serialized() != "oh hello world" {
+		t.Errorf("content = %q, want %q", s.doc.Serialized(), "oh hello world")
+	}
+	if edits, _ := st.GetOperations(ctx(), "doc1", 1); len(edits) != 1 || edits[0].Author != "c2" {
+		t.Errorf("accepted edit = %+v, want one by c2", edits)
+	}
+
+	// Authors can withdraw their own suggestions.
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgSuggest, Revision: 2, Op: rawOp(ot.NewDelete(0, 3, 14))}}
+	id = recvMsg(t, reviewer).Suggestion.ID
+	recvMsg(t, editor) // suggestion
+	recvMsg(t, late)   // suggestion
+	s.incoming <- opMessage{client: late, msg: ClientMessage{Type: MsgReject, SuggestionID: id}}
+	if msg := recvMsg(t, late); msg.Type != MsgReject || msg.ClientID != "c3" {
+		t.Fatalf("got %+v, want reject by c3", msg)
+	}
+	recvMsg(t, editor)   // reject
+	recvMsg(t, reviewer) // reject
+	if len(s.suggestions) != 0 {
+		t.Errorf("suggestions = %+v, want none", s.suggestions)
+	}
+
+	// A suggestion whose change is made by an edit is dropped.
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgSuggest, Revision: 2, Op: rawOp(ot.NewDelete(0, 3, 14))}}
+	id = recvMsg(t, reviewer).Suggestion.ID
+	recvMsg(t, editor) // suggestion
+	recvMsg(t, late)   // suggestion
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgOp, Revision: 2, Op: rawOp(ot.NewDelete(0, 3, 14))}}
+	if msg := recvMsg(t, reviewer); msg.Type != MsgReject || msg.SuggestionID != id {
+		t.Fatalf("got %+v, want reject of %s", msg, id)
+	}
+	recvMsg(t, editor) // reject
+	recvMsg(t, editor) // ack
+	if len(s.suggestions) != 0 {
+		t.Errorf("suggestions = %+v, want none", s.suggestions)
+	}
+}
+
+func TestSession_Comments(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "hello world")
+	s := newSession("doc1", ot.NewDocument("hello world"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	editor := mockClient("c1")
+	reviewer := mockClient("c2")
+	reviewer.role = RoleReviewer
+	s.join <- editor
+	s.join <- reviewer
+	recvMsg(t, editor)   // doc
+	recvMsg(t, reviewer) // doc
+	recvMsg(t, editor)   // c2 join
+
+	// Reviewers can comment, and every client is sent the thread.
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgComment, Revision: 0, Range: &ot.Range{Start: 11, End: 6}, Body: "world?"}}
+	var id string
+	for _, c := range []*Client{editor, reviewer} {
+		msg := recvMsg(t, c)
+		if msg.Type != MsgThread || msg.Thread == nil || msg.Thread.Anchor != (ot.Range{Start: 6, End: 11}) {
+			t.Fatalf("got %+v, want thread on [6, 11)", msg)
+		}
+		id = msg.Thread.ID
+	}
+
+	// Text typed at the anchor's edge stays outside it.
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(6, "big ", 11))}}
+	recvMsg(t, editor)   // ack
+	recvMsg(t, reviewer) // op
+	if want := (ot.Range{Start: 10, End: 15}); s.threads[0].Anchor != want {
+		t.Errorf("anchor = %+v, want %+v", s.threads[0].Anchor, want)
+	}
+
+	// A comment made before that edit is anchored past it.
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgComment, Revision: 0, Range: &ot.Range{Start: 0, End: 11}, Body: "all of it"}}
+	if msg := recvMsg(t, editor); msg.Thread == nil || msg.Thread.Anchor != (ot.Range{Start: 0, End: 15}) {
+		t.Fatalf("got %+v, want thread on [0, 15)", msg)
+	}
+	recvMsg(t, reviewer) // thread
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgComment, Revision: 1, Range: &ot.Range{Start: 0, End: 16}, Body: "too far"}}
+	if msg := recvMsg(t, editor); msg.Type != MsgError {
+		t.Fatalf("got %+v, want error for a range outside the document", msg)
+	}
+	// The range is checked against the document at the comment's
+	// revision.
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgComment, Revision: 0, Range: &ot.Range{Start: 0, End: 15}, Body: "too far then"}}
+	if msg := recvMsg(t, editor); msg.Type != MsgError {
+		t.Fatalf("got %+v, want error for a range outside the document", msg)
+	}
+
+	// Reviewers can't resolve others' threads, but can resolve their own.
+	other := s.threads[1].ID
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgResolve, ThreadID: other}}
+	if msg := recvMsg(t, reviewer); msg.Code != CodeForbidden {
+		t.Fatalf("got %+v, want forbidden error", msg)
+	}
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgReply, ThreadID: id, Body: "yes"}}
+	recvMsg(t, reviewer) // thread
+	s.incoming <- opMessage{client: reviewer, msg: ClientMessage{Type: MsgResolve, ThreadID: id}}
+	recvMsg(t, reviewer)      // thread
+	msg := recvMsg(t, editor) // reply
+	if msg.Thread == nil || len(msg.Thread.Comments) != 2 || msg.Thread.Comments[1].Author != "c1" {
+		t.Fatalf("got %+v, want thread with c1's reply", msg)
+	}
+	if msg := recvMsg(t, editor); msg.Thread == nil || !msg.Thread.Resolved {
+		t.Fatalf("got %+v, want resolved thread", msg)
+	}
+
+	// A late joiner gets the threads with the document.
+	late := mockClient("c3")
+	s.join <- late
+	if msg := recvMsg(t, late); len(msg.Threads) != 2 || msg.Threads[0].ID != id || !msg.Threads[0].Resolved {
+		t.Errorf("doc threads = %+v", msg.Threads)
+	}
+	recvMsg(t, editor)   // c3 join
+	recvMsg(t, reviewer) // c3 join
+
+	// Threads are stored as they were last saved, and brought up to date
+	// when loaded.
+	s.incoming <- opMessage{client: editor, msg: ClientMessage{Type: MsgOp, Revision: 1, Op: rawOp(ot.NewDelete(0, 6, 15))}}
+	recvMsg(t, editor) // ack
+	threads, err := loadThreads(ctx(), st, "doc1", ot.Text, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 || threads[0].Anchor != (ot.Range{Start: 4, End: 9}) || threads[1].Anchor != (ot.Range{Start: 0, End: 9}) {
+		t.Errorf("loaded threads = %+v", threads)
+	}
+	if !threads[0].Resolved || len(threads[0].Comments) != 2 {
+		t.Errorf("loaded thread = %+v", threads[0])
+	}
+}
+
+func TestSession_StoresAnchorsWithSnapshots(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "hello")
+	s := newSession("doc1", ot.NewDocument("hello"), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	s.join <- c1
+	recvMsg(t, c1) // doc
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgComment, Revision: 0, Range: &ot.Range{Start: 0, End: 5}, Body: "hi"}}
+	recvMsg(t, c1) // thread
+
+	// Text typed before the anchor moves it, and the move is stored with
+	// the next snapshot.
+	for v := 0; v < snapshotInterval; v++ {
+		s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgOp, Revision: v, Op: rawOp(ot.NewInsert(0, "x", 5+v))}}
+		recvMsg(t, c1) // ack
+	}
+	threads, err := st.ListThreads(ctx(), "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ot.Range{Start: snapshotInterval, End: snapshotInterval + 5}
+	if len(threads) != 1 || threads[0].Revision != snapshotInterval || threads[0].Anchor != want {
+		t.Errorf("stored threads = %+v, want anchor %+v at revision %d", threads, want, snapshotInterval)
+	}
+}
+
+func TestSession_Cursors(t *testing.T) {
+	s := newSession("doc1", ot.NewDocument("hello"), &ot.JupiterEngine{}, store.NewMemoryStore())
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	// A cursor is passed on to the other clients.
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgCursor, Revision: 0, Ranges: []ot.Range{{Start: 5, End: 5}}}}
+	if msg := recvMsg(t, c2); msg.Type != MsgCursor || msg.ClientID != "c1" || !reflect.DeepEqual(msg.Ranges, []ot.Range{{Start: 5, End: 5}}) {
+		t.Fatalf("got %+v, want c1's cursor at 5", msg)
+	}
+
+	// Stored cursors follow edits, and text typed at a caret goes before
+	// it.
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgOp, Revision: 0, Op: rawOp(ot.NewInsert(5, "!", 5))}}
+	recvMsg(t, c2) // ack
+	recvMsg(t, c1) // op
+
+	// A cursor sent before an edit is moved past it.
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgCursor, Revision: 0, Ranges: []ot.Range{{Start: 4, End: 1}}}}
+	if msg := recvMsg(t, c1); msg.Revision != 1 || !reflect.DeepEqual(msg.Ranges, []ot.Range{{Start: 4, End: 1}}) {
+		t.Fatalf("got %+v, want c2's backwards selection at revision 1", msg)
+	}
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgCursor, Revision: 1, Ranges: []ot.Range{{Start: 0, End: 7}}}}
+	if msg := recvMsg(t, c2); msg.Type != MsgError {
+		t.Fatalf("got %+v, want error for a range outside the document", msg)
+	}
+	// Ranges are checked at the revision they were sent at: 6 is past the
+	// end of revision 0, though not of revision 1.
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgCursor, Revision: 0, Ranges: []ot.Range{{Start: 6, End: 6}}}}
+	if msg := recvMsg(t, c2); msg.Type != MsgError {
+		t.Fatalf("got %+v, want error for a range outside the document at revision 0", msg)
+	}
+
+	// A late joiner gets everyone's cursors with the document.
+	late := mockClient("c3")
+	s.join <- late
+	want := []Cursor{
+		{ClientID: "c1", Ranges: []ot.Range{{Start: 6, End: 6}}},
+		{ClientID: "c2", Ranges: []ot.Range{{Start: 4, End: 1}}},
+	}
+	if msg := recvMsg(t, late); !reflect.DeepEqual(msg.Cursors, want) {
+		t.Errorf("doc cursors = %+v, want %+v", msg.Cursors, want)
+	}
+	recvMsg(t, c1) // c3 join
+	recvMsg(t, c2) // c3 join
+
+	// Leaving or sending no ranges clears a cursor.
+	s.leave <- c1
+	recvMsg(t, c2)   // c1 leave
+	recvMsg(t, late) // c1 leave
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgCursor, Revision: 1}}
+	if msg := recvMsg(t, late); msg.Type != MsgCursor || msg.ClientID != "c2" || len(msg.Ranges) != 0 {
+		t.Fatalf("got %+v, want c2's cursor cleared", msg)
+	}
+	late2 := mockClient("c4")
+	s.join <- late2
+	if msg := recvMsg(t, late2); len(msg.Cursors) != 0 {
+		t.Errorf("doc cursors = %+v, want none", msg.Cursors)
+	}
+}
+
+func TestSession_Awareness(t *testing.T) {
+	s := newSession("doc1", ot.NewDocument("hello"), &ot.JupiterEngine{}, store.NewMemoryStore())
+	s.awarenessTimeout = 500 * time.Millisecond
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	awareness := func(c *Client, state string) {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(state), &m); err != nil {
+			t.Fatal(err)
+		}
+		s.incoming <- opMessage{client: c, msg: ClientMessage{Type: MsgAwareness, State: m}}
+	}
+	wantState := func(msg ServerMessage, id, want string) {
+		t.Helper()
+		got, _ := json.Marshal(msg.State)
+		if msg.Type != MsgAwareness || msg.ClientID != id || string(got) != want {
+			t.Fatalf("got type=%q clientId=%q state=%s, want %s's state %s", msg.Type, msg.ClientID, got, id, want)
+		}
+	}
+
+	awareness(c1, `{"typing": true, "viewport": {"top": 3}}`)
+	wantState(recvMsg(t, c2), "c1", `{"typing":true,"viewport":{"top":3}}`)
+
+	// Only changes are passed on, and null removes a key.
+	awareness(c1, `{"typing": true}`)
+	awareness(c1, `{"typing": null}`)
+	wantState(recvMsg(t, c2), "c1", `{"viewport":{"top":3}}`)
+
+	tooMany := make(map[string]json.RawMessage)
+	for i := range maxAwarenessKeys + 1 {
+		tooMany[fmt.Sprint(i)] = json.RawMessage("1")
+	}
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgAwareness, State: tooMany}}
+	if msg := recvMsg(t, c1); msg.Type != MsgError {
+		t.Fatalf("got %+v, want error for too many keys", msg)
+	}
+
+	// A late joiner gets everyone else's state with the document, and a
+	// client's state is gone when it leaves.
+	c3 := mockClient("c3")
+	s.join <- c3
+	if msg := recvMsg(t, c3); len(msg.Awareness) != 1 || msg.Awareness[0].ClientID != "c1" {
+		t.Errorf("doc awareness = %+v, want c1's", msg.Awareness)
+	}
+	recvMsg(t, c1) // c3 join
+	recvMsg(t, c2) // c3 join
+	awareness(c3, `{"status": "away"}`)
+	recvMsg(t, c1) // c3's state
+	recvMsg(t, c2) // c3's state
+	s.leave <- c3
+	recvMsg(t, c1) // c3 leave
+	recvMsg(t, c2) // c3 leave
+
+	// State that isn't renewed expires.
+	wantState(recvMsg(t, c2), "c1", `null`)
+	c4 := mockClient("c4")
+	s.join <- c4
+	if msg := recvMsg(t, c4); len(msg.Awareness) != 0 {
+		t.Errorf("doc awareness = %+v, want none", msg.Awareness)
+	}
+}
+
+func TestSession_Chat(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.Create(ctx(), "doc1", "")
+	s := newSession("doc1", ot.NewDocument(""), &ot.JupiterEngine{}, st)
+	go s.Run()
+	defer close(s.stop)
+
+	c1 := mockClient("c1")
+	c2 := mockClient("c2")
+	c2.role = RoleReviewer
+	s.join <- c1
+	s.join <- c2
+	recvMsg(t, c1) // doc
+	recvMsg(t, c2) // doc
+	recvMsg(t, c1) // c2 join
+
+	// Messages go to everyone, including their author.
+	s.incoming <- opMessage{client: c2, msg: ClientMessage{Type: MsgChat, Body: "looks good"}}
+	for _, c := range []*Client{c1, c2} {
+		msg := recvMsg(t, c)
+		if msg.Type != MsgChat || msg.Chat == nil || msg.Chat.Author != "c2" || msg.Chat.Name != "Test c2" || msg.Chat.Body != "looks good" {
+			t.Fatalf("got %+v, want c2's chat message", msg)
+		}
+	}
+	s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgChat}}
+	if msg := recvMsg(t, c1); msg.Type != MsgError {
+		t.Fatalf("got %+v, want error for an empty message", msg)
+	}
+
+	// Only recent messages are sent to clients that join, or kept by the
+	// memory store.
+	for i := range chatHistorySize {
+		s.incoming <- opMessage{client: c1, msg: ClientMessage{Type: MsgChat, Body: fmt.Sprint(i)}}
+		recvMsg(t, c1)
+		recvMsg(t, c2)
+	}
+	late := mockClient("c3")
+	s.join <- late
+	msg := recvMsg(t, late)
+	if len(msg.ChatHistory) != chatHistorySize {
+		t.Fatalf("chat history has %d messages, want %d", len(msg.ChatHistory), chatHistorySize)
+	}
+	if msg.ChatHistory[0].Body != "0" {
+		t.Errorf("chat history starts with %+v, want message 0", msg.ChatHistory[0])
+	}
+	if stored, _ := st.RecentChat(ctx(), "doc1", 100); len(stored) != chatHistorySize || stored[0].Body != "0" {
+		t.Errorf("stored %d messages, want the latest %d", len(stored), chatHistorySize)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"github.com/alimasry/go-collab-editor/ot"
+)
+
+// maxSuggestions bounds how many suggestions a document can have pending.
+const maxSuggestions = 1000
+
+// Suggestion is a proposed edit awaiting review. Its Op applies to the
+// document at the revision of the message that carries it.
+type Suggestion struct {
+	ID     string `json:"id"`
+	Author string `json:"author"` // user ID of the client that suggested it
+	Op     ot.Op  `json:"op"`
+}
+
+// handleSuggest records a client's proposed edit as pending and announces
+// it to every client, including its author. Any client may suggest.
+func (s *Session) handleSuggest(om opMessage) {
+	if len(s.suggestions) >= maxSuggestions {
+		om.client.sendError("too many pending suggestions")
+		return
+	}
+	op, err := s.doc.Type.DecodeOp(om.msg.Op)
+	if err != nil {
+		om.client.sendErrorCode(CodeInvalidOp, "invalid operation: "+err.Error())
+		return
+	}
+
+	// Bring the suggestion up to date the way an edit would be.
+	edit := ot.Edit{Op: op, Site: om.client.ID, Author: om.client.UserID}
+	op, err = s.engine.TransformIncoming(s.doc.Type, edit, om.msg.Revision, s.doc.Base, s.doc.History)
+	if errors.Is(err, ot.ErrRevisionTooOld) {
+		om.client.sendErrorCode(CodeResync, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("session %s: suggestion transform error: %v", s.docID, err)
+		om.client.sendError("transform error: " + err.Error())
+		return
+	}
+	if s.doc.Type.IsNoop(op) {
+		om.client.sendError("suggestion changes nothing")
+		return
+	}
+
+	sg := Suggestion{ID: generateID(), Author: om.client.UserID, Op: op}
+	s.suggestions = append(s.suggestions, sg)
+	for c := range s.clients {
+		c.sendMsg(ServerMessage{
+			Type:       MsgSuggestion,
+			DocID:      s.docID,
+			Revision:   s.doc.Version,
+			Suggestion: &sg,
+		})
+	}
+}
+
+// handleAccept applies a pending suggestion as an edit by its author. Only
+// editors may accept suggestions.
+func (s *Session) handleAccept(c *Client, id string) {
+	if !c.canEdit() {
+		c.sendErrorCode(CodeForbidden, "only editors can accept suggestions")
+		return
+	}
+	sg, ok := s.takeSuggestion(id)
+	if !ok {
+		c.sendError("no suggestion " + id)
+		return
+	}
+	// The author stands in for a site, as for a restore.
+	applied := ot.Edit{Op: sg.Op, Site: sg.Author, Author: sg.Author}
+	if _, err := s.apply(applied); err != nil {
+		log.Printf("session %s: accept apply error: %v", s.docID, err)
+		c.sendError("apply error: " + err.Error())
+		return
+	}
+	s.broadcastOp(applied, nil)
+	s.broadcastResolved(MsgAccept, id, c)
+}
+
+// handleReject drops a pending suggestion. Editors may reject any
+// suggestion, and authors may withdraw their own.
+func (s *Session) handleReject(c *Client, id string) {
+	i := s.suggestionIndex(id)
+	if i < 0 {
+		c.sendError("no suggestion " + id)
+		return
+	}
+	if !c.canEdit() && s.suggestions[i].Author != c.UserID {
+		c.sendErrorCode(CodeForbidden, "only editors can reject suggestions")
+		return
+	}
+	s.takeSuggestion(id)
+	s.broadcastResolved(MsgReject, id, c)
+}
+
+// broadcastResolved tells every client that by accepted or rejected the
+// suggestion id, as typ says.
+func (s *Session) broadcastResolved(typ, id string, by *Client) {
+	for c := range s.clients {
+		c.sendMsg(ServerMessage{
+			Type:         typ,
+			DocID:        s.docID,
+			Revision:     s.doc.Version,
+			SuggestionID: id,
+			ClientID:     by.ID,
+		})
+	}
+}
+
+func (s *Session) suggestionIndex(id string) int {
+	for i, sg := range s.suggestions {
+		if sg.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// takeSuggestion removes the pending suggestion id and returns it.
+func (s *Session) takeSuggestion(id string) (Suggestion, bool) {
+	i := s.suggestionIndex(id)
+	if i < 0 {
+		return Suggestion{}, false
+	}
+	sg := s.suggestions[i]
+	s.suggestions = append(s.suggestions[:i], s.suggestions[i+1:]...)
+	return sg, true
+}
+
+// transformSuggestions moves every pending suggestion past op, which has
+// just been applied to the document. The document's edit wins ties, so
+// text a suggestion would insert where op inserted goes after it.
+// Suggestions whose changes op has made moot are dropped, and clients are
+// told they were rejected.
+func (s *Session) transformSuggestions(op ot.Op) {
+	kept := s.suggestions[:0]
+	for _, sg := range s.suggestions {
+		_, sop, err := s.doc.Type.Transform(op, sg.Op)
+		if err != nil {
+			log.Printf("session %s: suggestion %s transform error: %v", s.docID, sg.ID, err)
+		}
+		if err != nil || s.doc.Type.IsNoop(sop) {
+			for c := range s.clients {
+				c.sendMsg(ServerMessage{Type: MsgReject, DocID: s.docID, Revision: s.doc.Version, SuggestionID: sg.ID})
+			}
+			continue
+		}
+		sg.Op = sop
+		kept = append(kept, sg)
+	}
+	s.suggestions = kept
+}
@@ -2,6 +2,9 @@
 // OT Client — mirrors server-side retain/insert/delete model
 // ============================================================
 
+// All lengths are JavaScript string lengths (UTF-16 code units), matching
+// the server's default ot.UTF16 unit.
+
 function opBaseLen(op) {
     let n = 0;
     for (const c of op.ops) {
@@ -122,11 +125,21 @@ class OpIterator {
     }
 }
 
-// Transform two concurrent operations
-function transform(a, b) {
+// Transform two concurrent operations. Where both insert at the same
+// position, a's text goes first unless aFirst is false.
+function transform(a, b, aFirst = true) {
+    if (!aFirst) {
+        const [bp, ap] = transform(b, a);
+        return [ap, bp];
+    }
+
     if (opBaseLen(a) !== opBaseLen(b)) {
         throw new Error(`base lengths differ: ${opBaseLen(a)} vs ${opBaseLen(b)}`);
     }
+    // Ties depend on whether an insert comes before or after an adjacent
+    // delete, so transform the canonical form, as the server does.
+    a = normalize(a);
+    b = normalize(b);
 
     const ap = [], bp = [];
     const ia = new OpIterator(a), ib = new OpIterator(b);
@@ -171,6 +184,24 @@ function transform(a, b) {
     return [{ ops: compact(ap) }, { ops: compact(bp) }];
 }
 
+// Move inserts ahead of adjacent deletes, matching ot.Normalize.
+function normalize(op) {
+    const ops = [];
+    let deletes = [];
+    for (const c of op.ops) {
+        if (c.insert !== undefined) {
+            if (c.insert.length > 0) ops.push(c);
+            continue;
+        }
+        if (c.delete) { deletes.push(c); continue; }
+        ops.push(...deletes);
+        deletes = [];
+        if (c.retain) ops.push(c);
+    }
+    ops.push(...deletes);
+    return { ops: compact(ops.map(c => ({ ...c }))) };
+}
+
 function compact(ops) {
     const result = [];
     for (const c of ops) {
@@ -194,6 +225,7 @@ let pending = null;  // op sent to server, awaiting ack
 let buffer = null;   // op buffered while waiting for ack
 let revision = 0;
 let docId = "";
+let myId = "";       // this client's ID, used to break ties like the server
 
 function sendOp(op) {
     if (!ws || ws.readyState !== WebSocket.OPEN) return;
@@ -205,6 +237,13 @@ function sendOp(op) {
     }));
 }
 
+// Ask the server to undo or redo this client's last change. The result
+// arrives as a regular "op" message.
+function sendUndo(type) {
+    if (!ws || ws.readyState !== WebSocket.OPEN) return;
+    ws.send(JSON.stringify({ type: type, docId: docId }));
+}
+
 function clientSendOp(op) {
     switch (state) {
         case "synchronized":
@@ -223,14 +262,22 @@ function clientSendOp(op) {
     }
 }
 
-function handleAck(newRevision) {
+// If the server placed our edit differently from the way we transformed
+// it, the ack carries a correction from our copy to the server's.
+function handleAck(newRevision, correction) {
     revision = newRevision;
     switch (state) {
         case "awaitingAck":
             pending = null;
             state = "synchronized";
+            if (correction) applyRemoteOp(correction);
             break;
         case "awaitingAckWithBuffer":
+            if (correction) {
+                const [bufferP, correctionP] = transform(buffer, correction);
+                buffer = bufferP;
+                applyRemoteOp(correctionP);
+            }
             pending = buffer;
             buffer = null;
             state = "awaitingAck";
@@ -239,22 +286,25 @@ function handleAck(newRevision) {
     }
 }
 
-function handleRemoteOp(serverOp) {
+// Ties between concurrent inserts go to the lower client ID, matching
+// ot.SiteSide on the server.
+function handleRemoteOp(serverOp, authorId) {
+    const first = myId <= (authorId || "");
     switch (state) {
         case "synchronized":
             applyRemoteOp(serverOp);
             revision++;
             break;
         case "awaitingAck": {
-            const [pendingP, serverP] = transform(pending, serverOp);
+            const [pendingP, serverP] = transform(pending, serverOp, first);
             pending = pendingP;
             applyRemoteOp(serverP);
             revision++;
             break;
         }
         case "awaitingAckWithBuffer": {
-            const [pendingP, serverP1] = transform(pending, serverOp);
-            const [bufferP, serverP2] = transform(buffer, serverP1);
+            const [pendingP, serverP1] = transform(pending, serverOp, first);
+            const [bufferP, serverP2] = transform(buffer, serverP1, first);
             pending = pendingP;
             buffer = bufferP;
             applyRemoteOp(serverP2);
@@ -318,6 +368,14 @@ function initEditor() {
         lineNumbers: true,
         lineWrapping: true,
         theme: "default",
+        // Undo/redo run on the server so they never revert collaborators' edits.
+        extraKeys: {
+            "Ctrl-Z": () => sendUndo("undo"),
+            "Cmd-Z": () => sendUndo("undo"),
+            "Ctrl-Y": () => sendUndo("redo"),
+            "Shift-Ctrl-Z": () => sendUndo("redo"),
+            "Shift-Cmd-Z": () => sendUndo("redo"),
+        },
     });
 
     editor.on("change", (cm, change) => {
@@ -377,7 +435,11 @@ let reconnectTimer;
 
 function connect() {
     const protocol = location.protocol === "https:" ? "wss:" : "ws:";
-    ws = new WebSocket(`${protocol}//${location.host}/ws`);
+    // A server that requires authentication is opened with ?token=...,
+    // which is passed on to the WebSocket handshake.
+    const token = new URLSearchParams(location.search).get("token");
+    const query = token ? `?token=${encodeURIComponent(token)}` : "";
+    ws = new WebSocket(`${protocol}//${location.host}/ws${query}`);
 
     ws.onopen = () => {
         setStatus(true);
@@ -391,10 +453,10 @@ function connect() {
                 handleDocMessage(msg);
                 break;
             case "ack":
-                handleAck(msg.revision);
+                handleAck(msg.revision, msg.op);
                 break;
             case "op":
-                handleRemoteOp(msg.op);
+                handleRemoteOp(msg.op, msg.clientId);
                 revision = msg.revision;
                 break;
             case "join":
@@ -405,6 +467,11 @@ function connect() {
                 break;
             case "error":
                 console.error("Server error:", msg.message);
+                if (msg.code === "resync") {
+                    // Our revision fell out of the server's history;
+                    // reconnect to reload the document.
+                    ws.close();
+                }
                 break;
         }
     };
@@ -420,6 +487,7 @@ function connect() {
 }
 
 function handleDocMessage(msg) {
+    myId = msg.clientId || "";
     revision = msg.revision || 0;
     state = "synchronized";
     pending = null;
@@ -492,7 +560,8 @@ document.addEventListener("DOMContentLoaded", () => {
     document.getElementById("doc-id").textContent = "#" + docId;
 
     document.getElementById("copy-link").addEventListener("click", () => {
-        navigator.clipboard.writeText(location.href);
+        // Leave out the query, which may hold the user's token.
+        navigator.clipboard.writeText(location.origin + location.pathname + location.hash);
         const btn = document.getElementById("copy-link");
         btn.textContent = "Copied!";
         setTimeout(() => btn.textContent = "Copy Link", 1500);
@@ -2,7 +2,9 @@ package store
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,9 +13,22 @@ import (
 
 // dirtyState tracks what needs flushing for a single document.
 type dirtyState struct {
-	contentDirty bool // content/version needs writing to backing store
-	flushedOps   int  // number of ops already flushed (index into history)
-	created      bool // doc created locally but not yet in backing store
+	contentDirty bool               // content/version needs writing to backing store
+	flushedOps   int                // number of ops already flushed (index into history)
+	created      bool               // doc created locally but not yet in backing store
+	snapshots    []Snapshot         // snapshots not yet written to backing store
+	checkpoints  []checkpointChange // likewise for checkpoint changes
+	forkDirty    bool               // fork record needs writing to backing store
+	forkSaves    int                // number of SaveFork calls, to spot ones made during a flush
+	threads      []Thread           // threads saved but not yet written to backing store
+	chat         []ChatMessage      // chat messages not yet written to backing store
+}
+
+// checkpointChange is a checkpoint saved or deleted in the cache but not
+// yet in the backing store.
+type checkpointChange struct {
+	checkpoint Checkpoint
+	deleted    bool // only checkpoint.Name is set
 }
 
 // CachedStore wraps a backing DocumentStore with an in-memory cache.
@@ -45,11 +60,23 @@ func NewCachedStore(backing DocumentStore, flushInterval time.Duration) *CachedS
 }
 
 func (cs *CachedStore) Create(ctx context.Context, id, content string) error {
-	if err := cs.cache.Create(ctx, id, content); err != nil {
+	return cs.CreateTyped(ctx, id, ot.Text.Name(), content)
+}
+
+func (cs *CachedStore) CreateTyped(ctx context.Context, id, docType, content string) error {
+	// The document may be in the backing store but not yet cached.
+	if _, err := cs.Get(ctx, id); err == nil {
+		return fmt.Errorf("%w: %q", ErrDocumentExists, id)
+	}
+	if err := cs.cache.CreateTyped(ctx, id, docType, content); err != nil {
 		return err
 	}
 	cs.mu.Lock()
-	cs.dirty[id] = &dirtyState{contentDirty: true, created: true}
+	cs.dirty[id] = &dirtyState{
+		contentDirty: true,
+		created:      true,
+		snapshots:    []Snapshot{{Version: 0, Content: content}},
+	}
 	cs.mu.Unlock()
 	return nil
 }
@@ -79,6 +106,14 @@ func (cs *CachedStore) UpdateContent(ctx context.Context, id, content string, ve
 		return err
 	}
 	cs.mu.Lock()
+	cs.markDirty(id).contentDirty = true
+	cs.mu.Unlock()
+	return nil
+}
+
+// markDirty returns id's dirty state, adding it if the document was clean.
+// cs.mu must be held.
+func (cs *CachedStore) markDirty(id string) *dirtyState {
 	ds := cs.dirty[id]
 	if ds == nil {
 		cs.cache.mu.RLock()
@@ -87,12 +122,10 @@ func (cs *CachedStore) UpdateContent(ctx context.Context, id, content string, ve
 		ds = &dirtyState{flushedOps: flushed}
 		cs.dirty[id] = ds
 	}
-	ds.contentDirty = true
-	cs.mu.Unlock()
-	return nil
+	return ds
 }
 
-func (cs *CachedStore) AppendOperation(ctx context.Context, id string, op ot.Operation, version int) error {
+func (cs *CachedStore) AppendOperation(ctx context.Context, id string, e ot.Edit, version int) error {
 	// Ensure doc is in cache.
 	if _, err := cs.Get(ctx, id); err != nil {
 		return err
@@ -104,7 +137,7 @@ func (cs *CachedStore) AppendOperation(ctx context.Context, id string, op ot.Ope
 	prevLen := len(cs.cache.docs[id].history)
 	cs.cache.mu.RUnlock()
 
-	if err := cs.cache.AppendOperation(ctx, id, op, version); err != nil {
+	if err := cs.cache.AppendOperation(ctx, id, e, version); err != nil {
 		return err
 	}
 	// Mark dirty so flush loop picks up the new op.
@@ -116,7 +149,7 @@ func (cs *CachedStore) AppendOperation(ctx context.Context, id string, op ot.Ope
 	return nil
 }
 
-func (cs *CachedStore) GetOperations(ctx context.Context, id string, fromVersion int) ([]ot.Operation, error) {
+func (cs *CachedStore) GetOperations(ctx context.Context, id string, fromVersion int) ([]ot.Edit, error) {
 	// Ensure doc is in cache.
 	if _, err := cs.Get(ctx, id); err != nil {
 		return nil, err
@@ -124,9 +157,178 @@ func (cs *CachedStore) GetOperations(ctx context.Context, id string, fromVersion
 	return cs.cache.GetOperations(ctx, id, fromVersion)
 }
 
-// loadFromBacking loads a document and its operations from the backing store
-// into the cache. It sets flushedOps so that already-persisted ops are not
-// re-flushed.
+func (cs *CachedStore) SaveSnapshot(ctx context.Context, id string, snap Snapshot) error {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := cs.cache.SaveSnapshot(ctx, id, snap); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	ds := cs.markDirty(id)
+	ds.snapshots = append(ds.snapshots, snap)
+	cs.mu.Unlock()
+	return nil
+}
+
+// GetSnapshot returns the latest cached snapshot at or before version. The
+// cache only holds snapshots saved since the document was loaded, which
+// are later than any in the backing store, so the backing store is asked
+// only if the cache has none.
+func (cs *CachedStore) GetSnapshot(ctx context.Context, id string, version int) (*Snapshot, error) {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	if snap, err := cs.cache.GetSnapshot(ctx, id, version); err == nil {
+		return snap, nil
+	}
+	return cs.backing.GetSnapshot(ctx, id, version)
+}
+
+func (cs *CachedStore) SaveCheckpoint(ctx context.Context, id string, cp Checkpoint) error {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := cs.cache.SaveCheckpoint(ctx, id, cp); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	ds := cs.markDirty(id)
+	ds.checkpoints = append(ds.checkpoints, checkpointChange{checkpoint: cp})
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *CachedStore) ListCheckpoints(ctx context.Context, id string) ([]Checkpoint, error) {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return cs.cache.ListCheckpoints(ctx, id)
+}
+
+func (cs *CachedStore) DeleteCheckpoint(ctx context.Context, id, name string) error {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := cs.cache.DeleteCheckpoint(ctx, id, name); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	ds := cs.markDirty(id)
+	ds.checkpoints = append(ds.checkpoints, checkpointChange{checkpoint: Checkpoint{Name: name}, deleted: true})
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *CachedStore) SaveFork(ctx context.Context, id string, f Fork) error {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := cs.cache.SaveFork(ctx, id, f); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	ds := cs.markDirty(id)
+	ds.forkDirty = true
+	ds.forkSaves++
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *CachedStore) GetFork(ctx context.Context, id string) (*Fork, error) {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return cs.cache.GetFork(ctx, id)
+}
+
+func (cs *CachedStore) SaveThread(ctx context.Context, id string, th Thread) error {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := cs.cache.SaveThread(ctx, id, th); err != nil {
+		return err
+	}
+	th.Comments = slices.Clone(th.Comments)
+	cs.mu.Lock()
+	ds := cs.markDirty(id)
+	ds.threads = append(ds.threads, th)
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *CachedStore) ListThreads(ctx context.Context, id string) ([]Thread, error) {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return cs.cache.ListThreads(ctx, id)
+}
+
+// AppendChat records m in the cache and, if the backing store keeps chat
+// history, queues it to be written there.
+func (cs *CachedStore) AppendChat(ctx context.Context, id string, m ChatMessage) error {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := cs.cache.AppendChat(ctx, id, m); err != nil {
+		return err
+	}
+	if _, ok := cs.backing.(ChatStore); ok {
+		cs.mu.Lock()
+		ds := cs.markDirty(id)
+		ds.chat = append(ds.chat, m)
+		cs.mu.Unlock()
+	}
+	return nil
+}
+
+// RecentChat returns the latest n chat messages from the backing store
+// followed by those not yet written to it. If the backing store doesn't
+// keep chat history, they are the messages sent since the cache started.
+func (cs *CachedStore) RecentChat(ctx context.Context, id string, n int) ([]ChatMessage, error) {
+	// Ensure doc is in cache.
+	if _, err := cs.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	backing, ok := cs.backing.(ChatStore)
+	if !ok {
+		return cs.cache.RecentChat(ctx, id, n)
+	}
+	cs.mu.Lock()
+	var pending []ChatMessage
+	if ds := cs.dirty[id]; ds != nil {
+		pending = slices.Clone(ds.chat)
+	}
+	cs.mu.Unlock()
+	flushed, err := backing.RecentChat(ctx, id, n)
+	if err != nil {
+		return nil, err
+	}
+
+	// A flush may have written some pending messages since they were
+	// copied.
+	msgs := flushed
+	for _, m := range pending {
+		if !slices.ContainsFunc(flushed, func(f ChatMessage) bool { return f.ID == m.ID }) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs[max(0, len(msgs)-n):], nil
+}
+
+// loadFromBacking loads a document, its operations, checkpoints, fork
+// record and comment threads from the backing store into the cache. It sets flushedOps so that
+// already-persisted ops are not re-flushed.
 func (cs *CachedStore) loadFromBacking(ctx context.Context, id string) error {
 	info, err := cs.backing.Get(ctx, id)
 	if err != nil {
@@ -136,13 +338,28 @@ func (cs *CachedStore) loadFromBacking(ctx context.Context, id string) error {
 	if err != nil {
 		return err
 	}
+	checkpoints, err := cs.backing.ListCheckpoints(ctx, id)
+	if err != nil {
+		return err
+	}
+	fork, err := cs.backing.GetFork(ctx, id)
+	if err != nil {
+		return err
+	}
+	threads, err := cs.backing.ListThreads(ctx, id)
+	if err != nil {
+		return err
+	}
 
 	// Write directly into cache's internal map.
 	cs.cache.mu.Lock()
 	if _, exists := cs.cache.docs[id]; !exists {
 		cs.cache.docs[id] = &docRecord{
-			info:    *info,
-			history: ops,
+			info:        *info,
+			history:     ops,
+			checkpoints: checkpoints,
+			fork:        fork,
+			threads:     threads,
 		}
 	}
 	cs.cache.mu.Unlock()
@@ -197,25 +414,25 @@ func (cs *CachedStore) flush() {
 		info := rec.info
 		totalOps := len(rec.history)
 		// Copy the new ops slice while holding the lock.
-		var newOps []ot.Operation
+		var newOps []ot.Edit
 		if ds.flushedOps < totalOps {
-			newOps = make([]ot.Operation, totalOps-ds.flushedOps)
+			newOps = make([]ot.Edit, totalOps-ds.flushedOps)
 			copy(newOps, rec.history[ds.flushedOps:])
 		}
 		cs.cache.mu.RUnlock()
 
 		// 1. Create doc in backing store if needed.
 		if ds.created {
-			if err := cs.backing.Create(ctx, id, ""); err != nil {
+			if err := cs.backing.CreateTyped(ctx, id, info.Type, ""); err != nil {
 				log.Printf("cached store: failed to create doc %q in backing store: %v", id, err)
 				continue
 			}
 		}
 
 		// 2. Flush new ops (before content, so crash-recovery can replay).
-		for i, op := range newOps {
+		for i, e := range newOps {
 			version := ds.flushedOps + i + 1
-			if err := cs.backing.AppendOperation(ctx, id, op, version); err != nil {
+			if err := cs.backing.AppendOperation(ctx, id, e, version); err != nil {
 				log.Printf("cached store: failed to flush op %d for doc %q: %v", version, id, err)
 				// Stop flushing this doc — will retry next cycle.
 				break
@@ -223,7 +440,73 @@ func (cs *CachedStore) flush() {
 			ds.flushedOps++
 		}
 
-		// 3. Flush content if dirty.
+		// 3. Flush snapshots.
+		flushedSnapshots := 0
+		for _, snap := range ds.snapshots {
+			if err := cs.backing.SaveSnapshot(ctx, id, snap); err != nil {
+				log.Printf("cached store: failed to flush snapshot %d for doc %q: %v", snap.Version, id, err)
+				break
+			}
+			flushedSnapshots++
+		}
+
+		// 4. Flush checkpoint changes, in the order they were made.
+		flushedCheckpoints := 0
+		for _, c := range ds.checkpoints {
+			var err error
+			if c.deleted {
+				err = cs.backing.DeleteCheckpoint(ctx, id, c.checkpoint.Name)
+			} else {
+				err = cs.backing.SaveCheckpoint(ctx, id, c.checkpoint)
+			}
+			if err != nil {
+				log.Printf("cached store: failed to flush checkpoint %q for doc %q: %v", c.checkpoint.Name, id, err)
+				break
+			}
+			flushedCheckpoints++
+		}
+
+		// 5. Flush threads, in the order they were saved.
+		flushedThreads := 0
+		for _, th := range ds.threads {
+			if err := cs.backing.SaveThread(ctx, id, th); err != nil {
+				log.Printf("cached store: failed to flush thread %q for doc %q: %v", th.ID, id, err)
+				break
+			}
+			flushedThreads++
+		}
+
+		// 6. Flush chat messages, in the order they were sent.
+		flushedChat := 0
+		if chat, ok := cs.backing.(ChatStore); ok {
+			for _, m := range ds.chat {
+				if err := chat.AppendChat(ctx, id, m); err != nil {
+					log.Printf("cached store: failed to flush chat message %q for doc %q: %v", m.ID, id, err)
+					break
+				}
+				flushedChat++
+			}
+		}
+
+		// 7. Flush the fork record if dirty.
+		if ds.forkDirty {
+			cs.cache.mu.RLock()
+			var f *Fork
+			if r, ok := cs.cache.docs[id]; ok && r.fork != nil {
+				cp := *r.fork
+				f = &cp
+			}
+			cs.cache.mu.RUnlock()
+			if f != nil {
+				if err := cs.backing.SaveFork(ctx, id, *f); err != nil {
+					log.Printf("cached store: failed to flush fork record for doc %q: %v", id, err)
+				} else {
+					ds.forkDirty = false
+				}
+			}
+		}
+
+		// 8. Flush content if dirty.
 		if ds.contentDirty {
 			if err := cs.backing.UpdateContent(ctx, id, info.Content, info.Version); err != nil {
 				log.Printf("cached store: failed to flush content for doc %q: %v", id, err)
@@ -240,12 +523,23 @@ func (cs *CachedStore) flush() {
 		if cur != nil {
 			cur.flushedOps = ds.flushedOps
 			cur.created = ds.created
+			// Snapshots, checkpoint changes, threads and chat messages
+			// saved since are appended after the flushed ones.
+			cur.snapshots = cur.snapshots[flushedSnapshots:]
+			cur.checkpoints = cur.checkpoints[flushedCheckpoints:]
+			cur.threads = cur.threads[flushedThreads:]
+			cur.chat = cur.chat[flushedChat:]
 			// Only clear contentDirty if no new writes happened since snapshot.
 			if !ds.contentDirty {
 				cur.contentDirty = false
 			}
+			// Likewise the fork record: a SaveFork since the copy above, such
+			// as the one marking a fork merged, still has to be written.
+			if !ds.forkDirty && cur.forkSaves == ds.forkSaves {
+				cur.forkDirty = false
+			}
 			// Remove from dirty map if fully clean.
-			if !cur.contentDirty && !cur.created && cur.flushedOps >= totalOps {
+			if !cur.contentDirty && !cur.created && len(cur.snapshots) == 0 && len(cur.checkpoints) == 0 && len(cur.threads) == 0 && len(cur.chat) == 0 && !cur.forkDirty && cur.flushedOps >= totalOps {
 				// Re-check current totalOps — new ops may have arrived.
 				cs.cache.mu.RLock()
 				if r, ok := cs.cache.docs[id]; ok && cur.flushedOps >= len(r.history) {
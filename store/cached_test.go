@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -17,7 +18,7 @@ func TestCachedStore_ReadThrough(t *testing.T) {
 		t.Fatal(err)
 	}
 	op := ot.NewInsert(5, " world", 5)
-	if err := backing.AppendOperation(ctx, "doc1", op, 1); err != nil {
+	if err := backing.AppendOperation(ctx, "doc1", ot.Edit{Op: op}, 1); err != nil {
 		t.Fatal(err)
 	}
 
@@ -87,7 +88,7 @@ func TestCachedStore_OperationFlushTracking(t *testing.T) {
 	// Append 3 ops.
 	for i := 1; i <= 3; i++ {
 		op := ot.NewInsert(0, "x", 4+i)
-		if err := cs.AppendOperation(ctx, "doc1", op, i); err != nil {
+		if err := cs.AppendOperation(ctx, "doc1", ot.Edit{Op: op}, i); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -106,7 +107,7 @@ func TestCachedStore_OperationFlushTracking(t *testing.T) {
 	// Append 2 more.
 	for i := 4; i <= 5; i++ {
 		op := ot.NewInsert(0, "y", 4+i)
-		if err := cs.AppendOperation(ctx, "doc1", op, i); err != nil {
+		if err := cs.AppendOperation(ctx, "doc1", ot.Edit{Op: op}, i); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -136,7 +137,7 @@ func TestCachedStore_CloseFlushes(t *testing.T) {
 		t.Fatal(err)
 	}
 	op := ot.NewInsert(5, " world", 5)
-	if err := cs.AppendOperation(ctx, "doc1", op, 1); err != nil {
+	if err := cs.AppendOperation(ctx, "doc1", ot.Edit{Op: op}, 1); err != nil {
 		t.Fatal(err)
 	}
 
@@ -170,11 +171,11 @@ func TestCachedStore_PreLoadedDoc(t *testing.T) {
 		t.Fatal(err)
 	}
 	op1 := ot.NewInsert(2, "c", 2)
-	if err := backing.AppendOperation(ctx, "doc1", op1, 1); err != nil {
+	if err := backing.AppendOperation(ctx, "doc1", ot.Edit{Op: op1}, 1); err != nil {
 		t.Fatal(err)
 	}
 	op2 := ot.NewInsert(3, "d", 3)
-	if err := backing.AppendOperation(ctx, "doc1", op2, 2); err != nil {
+	if err := backing.AppendOperation(ctx, "doc1", ot.Edit{Op: op2}, 2); err != nil {
 		t.Fatal(err)
 	}
 
@@ -187,7 +188,7 @@ func TestCachedStore_PreLoadedDoc(t *testing.T) {
 
 	// Append a new op via cache.
 	op3 := ot.NewInsert(4, "e", 4)
-	if err := cs.AppendOperation(ctx, "doc1", op3, 3); err != nil {
+	if err := cs.AppendOperation(ctx, "doc1", ot.Edit{Op: op3}, 3); err != nil {
 		t.Fatal(err)
 	}
 
@@ -204,6 +205,25 @@ func TestCachedStore_PreLoadedDoc(t *testing.T) {
 	}
 }
 
+func TestCachedStore_CreateExisting(t *testing.T) {
+	backing := NewMemoryStore()
+	ctx := context.Background()
+	if err := backing.Create(ctx, "doc1", "backing"); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := NewCachedStore(backing, time.Hour)
+	defer cs.Close()
+
+	// The document isn't cached yet.
+	if err := cs.Create(ctx, "doc1", "cache"); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("err = %v, want ErrDocumentExists", err)
+	}
+	if info, _ := cs.Get(ctx, "doc1"); info.Content != "backing" {
+		t.Errorf("content = %q, want %q", info.Content, "backing")
+	}
+}
+
 func TestCachedStore_ListDelegatesToBacking(t *testing.T) {
 	backing := NewMemoryStore()
 	ctx := context.Background()
@@ -222,3 +242,227 @@ func TestCachedStore_ListDelegatesToBacking(t *testing.T) {
 		t.Errorf("got %d docs, want 2", len(docs))
 	}
 }
+
+func TestCachedStore_Snapshots(t *testing.T) {
+	backing := NewMemoryStore()
+	ctx := context.Background()
+
+	cs := NewCachedStore(backing, time.Hour)
+
+	if err := cs.Create(ctx, "doc1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SaveSnapshot(ctx, "doc1", Snapshot{Version: 100, Content: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Served from the cache before the flush.
+	snap, err := cs.GetSnapshot(ctx, "doc1", 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 100 || snap.Content != "b" {
+		t.Errorf("unexpected snapshot: %+v", snap)
+	}
+
+	cs.Close()
+
+	for version, want := range map[int]string{0: "hello", 100: "b"} {
+		snap, err := backing.GetSnapshot(ctx, "doc1", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap.Version != version || snap.Content != want {
+			t.Errorf("backing snapshot %d: got %+v", version, snap)
+		}
+	}
+}
+
+func TestCachedStore_Checkpoints(t *testing.T) {
+	backing := NewMemoryStore()
+	ctx := context.Background()
+
+	// A checkpoint already in the backing store is loaded with the doc.
+	backing.Create(ctx, "doc1", "")
+	backing.SaveCheckpoint(ctx, "doc1", Checkpoint{Name: "old"})
+
+	cs := NewCachedStore(backing, time.Hour)
+	if err := cs.SaveCheckpoint(ctx, "doc1", Checkpoint{Name: "new", Revision: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.DeleteCheckpoint(ctx, "doc1", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SaveCheckpoint(ctx, "doc1", Checkpoint{Name: "new"}); !errors.Is(err, ErrCheckpointExists) {
+		t.Errorf("err = %v, want ErrCheckpointExists", err)
+	}
+
+	// Backing is unchanged until the flush.
+	cps, _ := backing.ListCheckpoints(ctx, "doc1")
+	if len(cps) != 1 || cps[0].Name != "old" {
+		t.Errorf("backing checkpoints before flush = %+v", cps)
+	}
+
+	cs.Close()
+
+	cps, err := backing.ListCheckpoints(ctx, "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 1 || cps[0].Name != "new" || cps[0].Revision != 2 {
+		t.Errorf("backing checkpoints = %+v, want just new", cps)
+	}
+}
+
+func TestCachedStore_Fork(t *testing.T) {
+	backing := NewMemoryStore()
+	ctx := context.Background()
+
+	cs := NewCachedStore(backing, time.Hour)
+	if err := cs.Create(ctx, "doc1", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := Fork{Parent: "parent", Revision: 4, Merged: true, MergedRevision: 9}
+	if err := cs.SaveFork(ctx, "doc1", want); err != nil {
+		t.Fatal(err)
+	}
+	cs.Close()
+
+	f, err := backing.GetFork(ctx, "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f == nil || *f != want {
+		t.Errorf("backing fork = %+v, want %+v", f, want)
+	}
+
+	// A fresh cache loads the record from the backing store.
+	cs = NewCachedStore(backing, time.Hour)
+	defer cs.Close()
+	if f, err := cs.GetFork(ctx, "doc1"); err != nil || f == nil || *f != want {
+		t.Errorf("cached fork = %+v, %v; want %+v", f, err, want)
+	}
+}
+
+// slowForkStore blocks its first SaveFork, after closing saving, until
+// release is closed.
+type slowForkStore struct {
+	*MemoryStore
+	saving, release chan struct{}
+}
+
+func (s *slowForkStore) SaveFork(ctx context.Context, id string, f Fork) error {
+	if s.saving != nil {
+		close(s.saving)
+		s.saving = nil
+		<-s.release
+	}
+	return s.MemoryStore.SaveFork(ctx, id, f)
+}
+
+func TestCachedStore_ForkSavedDuringFlush(t *testing.T) {
+	backing := &slowForkStore{MemoryStore: NewMemoryStore(), saving: make(chan struct{}), release: make(chan struct{})}
+	saving := backing.saving
+	ctx := context.Background()
+
+	cs := NewCachedStore(backing, time.Hour)
+	if err := cs.Create(ctx, "doc1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SaveFork(ctx, "doc1", Fork{Parent: "parent", Revision: 4}); err != nil {
+		t.Fatal(err)
+	}
+	flushed := make(chan struct{})
+	go func() {
+		cs.flush()
+		close(flushed)
+	}()
+
+	// The fork is merged while the flush is writing the unmerged record.
+	<-saving
+	want := Fork{Parent: "parent", Revision: 4, Merged: true, MergedRevision: 9}
+	if err := cs.SaveFork(ctx, "doc1", want); err != nil {
+		t.Fatal(err)
+	}
+	close(backing.release)
+	<-flushed
+	cs.Close()
+
+	if f, err := backing.GetFork(ctx, "doc1"); err != nil || f == nil || *f != want {
+		t.Errorf("backing fork = %+v, %v; want %+v", f, err, want)
+	}
+}
+
+func TestCachedStore_Threads(t *testing.T) {
+	backing := NewMemoryStore()
+	ctx := context.Background()
+
+	// A thread already in the backing store is loaded with the doc.
+	backing.Create(ctx, "doc1", "")
+	backing.SaveThread(ctx, "doc1", Thread{ID: "old"})
+
+	cs := NewCachedStore(backing, time.Hour)
+	th := Thread{ID: "new", Anchor: ot.Range{Start: 0, End: 2}, Comments: []Comment{{Author: "alice", Body: "hi"}}}
+	if err := cs.SaveThread(ctx, "doc1", th); err != nil {
+		t.Fatal(err)
+	}
+	th.Resolved = true
+	if err := cs.SaveThread(ctx, "doc1", th); err != nil {
+		t.Fatal(err)
+	}
+	if threads, _ := cs.ListThreads(ctx, "doc1"); len(threads) != 2 {
+		t.Errorf("cached threads = %+v", threads)
+	}
+
+	// Backing is unchanged until the flush.
+	if threads, _ := backing.ListThreads(ctx, "doc1"); len(threads) != 1 {
+		t.Errorf("backing threads before flush = %+v", threads)
+	}
+
+	cs.Close()
+
+	threads, err := backing.ListThreads(ctx, "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 || threads[1].ID != "new" || !threads[1].Resolved || len(threads[1].Comments) != 1 {
+		t.Errorf("backing threads = %+v", threads)
+	}
+}
+
+func TestCachedStore_Chat(t *testing.T) {
+	backing := NewMemoryStore()
+	ctx := context.Background()
+
+	backing.Create(ctx, "doc1", "")
+	backing.AppendChat(ctx, "doc1", ChatMessage{ID: "old"})
+
+	cs := NewCachedStore(backing, time.Hour)
+	for _, id := range []string{"m1", "m2"} {
+		if err := cs.AppendChat(ctx, "doc1", ChatMessage{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Recent chat includes messages not yet flushed.
+	msgs, err := cs.RecentChat(ctx, "doc1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "m1" || msgs[1].ID != "m2" {
+		t.Errorf("recent chat = %+v, want m1 and m2", msgs)
+	}
+	if msgs, _ := backing.RecentChat(ctx, "doc1", 10); len(msgs) != 1 {
+		t.Errorf("backing chat before flush = %+v", msgs)
+	}
+
+	cs.Close()
+
+	msgs, err = backing.RecentChat(ctx, "doc1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[0].ID != "old" || msgs[2].ID != "m2" {
+		t.Errorf("backing chat = %+v, want old, m1, m2", msgs)
+	}
+}
@@ -2,7 +2,10 @@ package store
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -35,22 +38,52 @@ func (s *FirestoreStore) opsCollection(docID string) *firestore.CollectionRef {
 	return s.docRef(docID).Collection("operations")
 }
 
+func (s *FirestoreStore) snapshotsCollection(docID string) *firestore.CollectionRef {
+	return s.docRef(docID).Collection("snapshots")
+}
+
+func (s *FirestoreStore) checkpointsCollection(docID string) *firestore.CollectionRef {
+	return s.docRef(docID).Collection("checkpoints")
+}
+
+func (s *FirestoreStore) threadsCollection(docID string) *firestore.CollectionRef {
+	return s.docRef(docID).Collection("threads")
+}
+
+func (s *FirestoreStore) chatCollection(docID string) *firestore.CollectionRef {
+	return s.docRef(docID).Collection("chat")
+}
+
+// checkpointRef returns the checkpoint named name. Names are encoded so
+// that any name is a valid document ID.
+func (s *FirestoreStore) checkpointRef(docID, name string) *firestore.DocumentRef {
+	return s.checkpointsCollection(docID).Doc(base64.RawURLEncoding.EncodeToString([]byte(name)))
+}
+
 func zeroPad(version int) string {
 	return fmt.Sprintf("%010d", version)
 }
 
 func (s *FirestoreStore) Create(ctx context.Context, id, content string) error {
+	return s.CreateTyped(ctx, id, ot.Text.Name(), content)
+}
+
+func (s *FirestoreStore) CreateTyped(ctx context.Context, id, docType, content string) error {
 	now := time.Now()
 	_, err := s.docRef(id).Create(ctx, map[string]interface{}{
+		"type":      docType,
 		"content":   content,
 		"version":   0,
 		"createdAt": now,
 		"updatedAt": now,
 	})
 	if status.Code(err) == codes.AlreadyExists {
-		return fmt.Errorf("document %q already exists", id)
+		return fmt.Errorf("%w: %q", ErrDocumentExists, id)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return s.SaveSnapshot(ctx, id, Snapshot{Version: 0, Content: content})
 }
 
 func (s *FirestoreStore) Get(ctx context.Context, id string) (*DocumentInfo, error) {
@@ -66,12 +99,18 @@ func (s *FirestoreStore) Get(ctx context.Context, id string) (*DocumentInfo, err
 
 func snapshotToDocInfo(id string, snap *firestore.DocumentSnapshot) (*DocumentInfo, error) {
 	data := snap.Data()
+	docType, _ := data["type"].(string)
+	if docType == "" {
+		// Documents created before types existed are plain text.
+		docType = ot.Text.Name()
+	}
 	content, _ := data["content"].(string)
 	version, _ := data["version"].(int64)
 	createdAt, _ := data["createdAt"].(time.Time)
 	updatedAt, _ := data["updatedAt"].(time.Time)
 	return &DocumentInfo{
 		ID:        id,
+		Type:      docType,
 		Content:   content,
 		Version:   int(version),
 		CreatedAt: createdAt,
@@ -113,9 +152,46 @@ func (s *FirestoreStore) UpdateContent(ctx context.Context, id, content string,
 	return err
 }
 
-func (s *FirestoreStore) AppendOperation(ctx context.Context, id string, op ot.Operation, version int) error {
-	components := make([]map[string]interface{}, len(op.Ops))
-	for i, c := range op.Ops {
+func (s *FirestoreStore) AppendOperation(ctx context.Context, id string, e ot.Edit, version int) error {
+	// Store with 0-based index: version 1 → index 0, matching MemoryStore's
+	// history slice semantics where GetOperations(fromVersion) returns history[fromVersion:].
+	index := version - 1
+	data, err := encodeOperation(e.Op)
+	if err != nil {
+		return err
+	}
+	data["version"] = version
+	if e.Site != "" {
+		data["site"] = e.Site
+	}
+	if e.Author != "" {
+		data["author"] = e.Author
+	}
+	if e.Seq != 0 {
+		data["seq"] = e.Seq
+	}
+	_, err = s.opsCollection(id).Doc(zeroPad(index)).Set(ctx, data)
+	return err
+}
+
+// encodeOperation converts an operation to Firestore fields. Text
+// operations are stored as a list of component maps; operations of other
+// types are stored as their JSON encoding. A component's attributes are
+// stored as JSON too, since Firestore would read numbers in a map back as
+// int64 rather than the float64 that JSON decoding, and so every attribute
+// comparison, expects.
+func encodeOperation(op ot.Op) (map[string]interface{}, error) {
+	o, ok := op.(ot.Operation)
+	if !ok {
+		b, err := json.Marshal(op)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"data": string(b)}, nil
+	}
+
+	components := make([]map[string]interface{}, len(o.Ops))
+	for i, c := range o.Ops {
 		m := make(map[string]interface{})
 		if c.Retain > 0 {
 			m["retain"] = c.Retain
@@ -126,28 +202,35 @@ func (s *FirestoreStore) AppendOperation(ctx context.Context, id string, op ot.O
 		if c.Delete > 0 {
 			m["delete"] = c.Delete
 		}
+		if len(c.Attributes) > 0 {
+			b, err := json.Marshal(c.Attributes)
+			if err != nil {
+				return nil, err
+			}
+			m["attributes"] = string(b)
+		}
 		components[i] = m
 	}
-
-	// Store with 0-based index: version 1 → index 0, matching MemoryStore's
-	// history slice semantics where GetOperations(fromVersion) returns history[fromVersion:].
-	index := version - 1
-	_, err := s.opsCollection(id).Doc(zeroPad(index)).Set(ctx, map[string]interface{}{
-		"ops":     components,
-		"version": version,
-	})
-	return err
+	return map[string]interface{}{"ops": components}, nil
 }
 
-func (s *FirestoreStore) GetOperations(ctx context.Context, id string, fromVersion int) ([]ot.Operation, error) {
-	// Verify document exists.
-	_, err := s.docRef(id).Get(ctx)
+func (s *FirestoreStore) GetOperations(ctx context.Context, id string, fromVersion int) ([]ot.Edit, error) {
+	// Verify document exists and find its type.
+	docSnap, err := s.docRef(id).Get(ctx)
 	if status.Code(err) == codes.NotFound {
 		return nil, fmt.Errorf("document %q not found", id)
 	}
 	if err != nil {
 		return nil, err
 	}
+	info, err := snapshotToDocInfo(id, docSnap)
+	if err != nil {
+		return nil, err
+	}
+	docType, err := ot.LookupType(info.Type)
+	if err != nil {
+		return nil, err
+	}
 
 	iter := s.opsCollection(id).
 		OrderBy(firestore.DocumentID, firestore.Asc).
@@ -155,7 +238,7 @@ func (s *FirestoreStore) GetOperations(ctx context.Context, id string, fromVersi
 		Documents(ctx)
 	defer iter.Stop()
 
-	var ops []ot.Operation
+	var edits []ot.Edit
 	for {
 		snap, err := iter.Next()
 		if err == iterator.Done {
@@ -164,17 +247,26 @@ func (s *FirestoreStore) GetOperations(ctx context.Context, id string, fromVersi
 		if err != nil {
 			return nil, err
 		}
-		op, err := snapshotToOperation(snap)
+		op, err := snapshotToOperation(docType, snap)
 		if err != nil {
 			return nil, err
 		}
-		ops = append(ops, op)
+		e := ot.Edit{Op: op}
+		e.Site, _ = snap.Data()["site"].(string)
+		e.Author, _ = snap.Data()["author"].(string)
+		if seq, ok := snap.Data()["seq"].(int64); ok {
+			e.Seq = int(seq)
+		}
+		edits = append(edits, e)
 	}
-	return ops, nil
+	return edits, nil
 }
 
-func snapshotToOperation(snap *firestore.DocumentSnapshot) (ot.Operation, error) {
+func snapshotToOperation(docType ot.Type, snap *firestore.DocumentSnapshot) (ot.Op, error) {
 	data := snap.Data()
+	if encoded, ok := data["data"].(string); ok {
+		return docType.DecodeOp([]byte(encoded))
+	}
 	rawOps, ok := data["ops"].([]interface{})
 	if !ok {
 		return ot.Operation{}, fmt.Errorf("invalid ops field in operation %s", snap.Ref.ID)
@@ -196,7 +288,252 @@ func snapshotToOperation(snap *firestore.DocumentSnapshot) (ot.Operation, error)
 		if v, ok := m["delete"].(int64); ok {
 			c.Delete = int(v)
 		}
+		if v, ok := m["attributes"]; ok {
+			attrs, err := decodeAttributes(v)
+			if err != nil {
+				return ot.Operation{}, fmt.Errorf("invalid attributes of component %d in operation %s: %w", i, snap.Ref.ID, err)
+			}
+			c.Attributes = attrs
+		}
 		components[i] = c
 	}
 	return ot.Operation{Ops: components}, nil
 }
+
+// decodeAttributes decodes a component's stored attributes. They are a
+// JSON string, or a map in operations stored before that, whose values
+// are round-tripped through JSON so that numbers are float64.
+func decodeAttributes(v interface{}) (ot.Attributes, error) {
+	encoded, ok := v.(string)
+	if !ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("attributes are %T", v)
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+	var attrs ot.Attributes
+	if err := json.Unmarshal([]byte(encoded), &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+func (s *FirestoreStore) SaveSnapshot(ctx context.Context, id string, snap Snapshot) error {
+	_, err := s.snapshotsCollection(id).Doc(zeroPad(snap.Version)).Set(ctx, map[string]interface{}{
+		"version": snap.Version,
+		"content": snap.Content,
+	})
+	return err
+}
+
+func (s *FirestoreStore) GetSnapshot(ctx context.Context, id string, version int) (*Snapshot, error) {
+	iter := s.snapshotsCollection(id).
+		OrderBy(firestore.DocumentID, firestore.Desc).
+		StartAt(zeroPad(version)).
+		Limit(1).
+		Documents(ctx)
+	defer iter.Stop()
+
+	snap, err := iter.Next()
+	if err == iterator.Done {
+		return nil, fmt.Errorf("%w: document %q has none at or before version %d", ErrSnapshotNotFound, id, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := snap.Data()
+	v, _ := data["version"].(int64)
+	content, _ := data["content"].(string)
+	return &Snapshot{Version: int(v), Content: content}, nil
+}
+
+func (s *FirestoreStore) SaveCheckpoint(ctx context.Context, id string, cp Checkpoint) error {
+	_, err := s.checkpointRef(id, cp.Name).Create(ctx, map[string]interface{}{
+		"name":      cp.Name,
+		"revision":  cp.Revision,
+		"author":    cp.Author,
+		"createdAt": cp.CreatedAt,
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return fmt.Errorf("%w: %q", ErrCheckpointExists, cp.Name)
+	}
+	return err
+}
+
+func (s *FirestoreStore) ListCheckpoints(ctx context.Context, id string) ([]Checkpoint, error) {
+	iter := s.checkpointsCollection(id).OrderBy("createdAt", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+
+	var result []Checkpoint
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := snap.Data()
+		name, _ := data["name"].(string)
+		revision, _ := data["revision"].(int64)
+		author, _ := data["author"].(string)
+		createdAt, _ := data["createdAt"].(time.Time)
+		result = append(result, Checkpoint{
+			Name:      name,
+			Revision:  int(revision),
+			Author:    author,
+			CreatedAt: createdAt,
+		})
+	}
+	return result, nil
+}
+
+func (s *FirestoreStore) DeleteCheckpoint(ctx context.Context, id, name string) error {
+	_, err := s.checkpointRef(id, name).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %q", ErrCheckpointNotFound, name)
+	}
+	return err
+}
+
+func (s *FirestoreStore) SaveFork(ctx context.Context, id string, f Fork) error {
+	_, err := s.docRef(id).Update(ctx, []firestore.Update{
+		{Path: "fork", Value: map[string]interface{}{
+			"parent":         f.Parent,
+			"revision":       f.Revision,
+			"merged":         f.Merged,
+			"mergedRevision": f.MergedRevision,
+		}},
+	})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("document %q not found", id)
+	}
+	return err
+}
+
+func (s *FirestoreStore) GetFork(ctx context.Context, id string) (*Fork, error) {
+	snap, err := s.docRef(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("document %q not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := snap.Data()["fork"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	parent, _ := data["parent"].(string)
+	revision, _ := data["revision"].(int64)
+	merged, _ := data["merged"].(bool)
+	mergedRevision, _ := data["mergedRevision"].(int64)
+	return &Fork{
+		Parent:         parent,
+		Revision:       int(revision),
+		Merged:         merged,
+		MergedRevision: int(mergedRevision),
+	}, nil
+}
+
+func (s *FirestoreStore) SaveThread(ctx context.Context, id string, th Thread) error {
+	comments := make([]map[string]interface{}, len(th.Comments))
+	for i, c := range th.Comments {
+		comments[i] = map[string]interface{}{
+			"author":    c.Author,
+			"body":      c.Body,
+			"createdAt": c.CreatedAt,
+		}
+	}
+	_, err := s.threadsCollection(id).Doc(th.ID).Set(ctx, map[string]interface{}{
+		"start":     th.Anchor.Start,
+		"end":       th.Anchor.End,
+		"revision":  th.Revision,
+		"resolved":  th.Resolved,
+		"comments":  comments,
+		"createdAt": th.CreatedAt,
+	})
+	return err
+}
+
+func (s *FirestoreStore) ListThreads(ctx context.Context, id string) ([]Thread, error) {
+	iter := s.threadsCollection(id).OrderBy("createdAt", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+
+	var result []Thread
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := snap.Data()
+		start, _ := data["start"].(int64)
+		end, _ := data["end"].(int64)
+		revision, _ := data["revision"].(int64)
+		resolved, _ := data["resolved"].(bool)
+		createdAt, _ := data["createdAt"].(time.Time)
+		th := Thread{
+			ID:        snap.Ref.ID,
+			Anchor:    ot.Range{Start: int(start), End: int(end)},
+			Revision:  int(revision),
+			Resolved:  resolved,
+			CreatedAt: createdAt,
+		}
+		rawComments, _ := data["comments"].([]interface{})
+		for _, raw := range rawComments {
+			m, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid comment in thread %s", snap.Ref.ID)
+			}
+			var c Comment
+			c.Author, _ = m["author"].(string)
+			c.Body, _ = m["body"].(string)
+			c.CreatedAt, _ = m["createdAt"].(time.Time)
+			th.Comments = append(th.Comments, c)
+		}
+		result = append(result, th)
+	}
+	return result, nil
+}
+
+func (s *FirestoreStore) AppendChat(ctx context.Context, id string, m ChatMessage) error {
+	_, err := s.chatCollection(id).Doc(m.ID).Set(ctx, map[string]interface{}{
+		"author":    m.Author,
+		"name":      m.Name,
+		"body":      m.Body,
+		"createdAt": m.CreatedAt,
+	})
+	return err
+}
+
+func (s *FirestoreStore) RecentChat(ctx context.Context, id string, n int) ([]ChatMessage, error) {
+	iter := s.chatCollection(id).OrderBy("createdAt", firestore.Desc).Limit(n).Documents(ctx)
+	defer iter.Stop()
+
+	var result []ChatMessage
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := snap.Data()
+		m := ChatMessage{ID: snap.Ref.ID}
+		m.Author, _ = data["author"].(string)
+		m.Name, _ = data["name"].(string)
+		m.Body, _ = data["body"].(string)
+		m.CreatedAt, _ = data["createdAt"].(time.Time)
+		result = append(result, m)
+	}
+	slices.Reverse(result)
+	return result, nil
+}
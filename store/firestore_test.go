@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -35,14 +37,23 @@ func cleanupDoc(t *testing.T, s *FirestoreStore, docID string) {
 	t.Helper()
 	ctx := context.Background()
 
-	// Delete operations subcollection.
-	ops := s.opsCollection(docID).Documents(ctx)
-	for {
-		snap, err := ops.Next()
-		if err != nil {
-			break
+	// Delete operations, snapshots, checkpoints, threads and chat
+	// subcollections.
+	for _, coll := range []*firestore.CollectionRef{
+		s.opsCollection(docID),
+		s.snapshotsCollection(docID),
+		s.checkpointsCollection(docID),
+		s.threadsCollection(docID),
+		s.chatCollection(docID),
+	} {
+		docs := coll.Documents(ctx)
+		for {
+			snap, err := docs.Next()
+			if err != nil {
+				break
+			}
+			snap.Ref.Delete(ctx)
 		}
-		snap.Ref.Delete(ctx)
 	}
 
 	// Delete document.
@@ -149,12 +160,12 @@ func TestFirestoreStore_Operations(t *testing.T) {
 	s.Create(ctx, docID, "hello")
 
 	op1 := ot.NewInsert(5, " world", 5)
-	if err := s.AppendOperation(ctx, docID, op1, 1); err != nil {
+	if err := s.AppendOperation(ctx, docID, ot.Edit{Op: op1}, 1); err != nil {
 		t.Fatal(err)
 	}
 
 	op2 := ot.NewDelete(0, 5, 11)
-	if err := s.AppendOperation(ctx, docID, op2, 2); err != nil {
+	if err := s.AppendOperation(ctx, docID, ot.Edit{Op: op2}, 2); err != nil {
 		t.Fatal(err)
 	}
 
@@ -177,6 +188,191 @@ func TestFirestoreStore_Operations(t *testing.T) {
 	}
 }
 
+func TestFirestoreStore_OperationAttributes(t *testing.T) {
+	client := testFirestoreClient(t)
+	s := NewFirestoreStore(client)
+	ctx := context.Background()
+	docID := uniqueDocID(t)
+	t.Cleanup(func() { cleanupDoc(t, s, docID) })
+
+	s.CreateTyped(ctx, docID, ot.Rich.Name(), "")
+	op := ot.Operation{Ops: []ot.Component{{Insert: "hi", Attributes: ot.Attributes{"bold": true, "size": 12.0, "link": nil}}}}
+	if err := s.AppendOperation(ctx, docID, ot.Edit{Op: op}, 1); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := s.GetOperations(ctx, docID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || !reflect.DeepEqual(ops[0].Op, op) {
+		t.Errorf("got %+v, want %+v", ops, op)
+	}
+}
+
+func TestDecodeAttributes(t *testing.T) {
+	want := ot.Attributes{"bold": true, "size": 12.0}
+	for _, v := range []interface{}{
+		`{"bold":true,"size":12}`,
+		// Stored as a map, Firestore reads numbers back as int64.
+		map[string]interface{}{"bold": true, "size": int64(12)},
+	} {
+		got, err := decodeAttributes(v)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("decodeAttributes(%#v) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	if _, err := decodeAttributes(int64(1)); err == nil {
+		t.Error("expected error for non-map attributes")
+	}
+}
+
+func TestFirestoreStore_Snapshots(t *testing.T) {
+	client := testFirestoreClient(t)
+	s := NewFirestoreStore(client)
+	ctx := context.Background()
+	docID := uniqueDocID(t)
+	t.Cleanup(func() { cleanupDoc(t, s, docID) })
+
+	s.Create(ctx, docID, "hello")
+	if err := s.SaveSnapshot(ctx, docID, Snapshot{Version: 100, Content: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for version, want := range map[int]Snapshot{0: {0, "hello"}, 99: {0, "hello"}, 150: {100, "b"}} {
+		snap, err := s.GetSnapshot(ctx, docID, version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if *snap != want {
+			t.Errorf("version %d: got %+v, want %+v", version, *snap, want)
+		}
+	}
+}
+
+func TestFirestoreStore_Checkpoints(t *testing.T) {
+	client := testFirestoreClient(t)
+	s := NewFirestoreStore(client)
+	ctx := context.Background()
+	docID := uniqueDocID(t)
+	t.Cleanup(func() { cleanupDoc(t, s, docID) })
+
+	s.Create(ctx, docID, "")
+	now := time.Now()
+	for i, name := range []string{"v1/draft", "v2"} {
+		cp := Checkpoint{Name: name, Revision: i, Author: "alice", CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := s.SaveCheckpoint(ctx, docID, cp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveCheckpoint(ctx, docID, Checkpoint{Name: "v2"}); !errors.Is(err, ErrCheckpointExists) {
+		t.Errorf("err = %v, want ErrCheckpointExists", err)
+	}
+
+	cps, err := s.ListCheckpoints(ctx, docID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 2 || cps[0].Name != "v1/draft" || cps[1].Revision != 1 || cps[1].Author != "alice" {
+		t.Errorf("checkpoints = %+v", cps)
+	}
+
+	if err := s.DeleteCheckpoint(ctx, docID, "v1/draft"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteCheckpoint(ctx, docID, "v1/draft"); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("err = %v, want ErrCheckpointNotFound", err)
+	}
+}
+
+func TestFirestoreStore_Fork(t *testing.T) {
+	client := testFirestoreClient(t)
+	s := NewFirestoreStore(client)
+	ctx := context.Background()
+	docID := uniqueDocID(t)
+	t.Cleanup(func() { cleanupDoc(t, s, docID) })
+
+	s.Create(ctx, docID, "")
+	if f, err := s.GetFork(ctx, docID); f != nil || err != nil {
+		t.Errorf("GetFork = %+v, %v; want nil for a document that isn't a fork", f, err)
+	}
+	want := Fork{Parent: "parent", Revision: 4, Merged: true, MergedRevision: 9}
+	if err := s.SaveFork(ctx, docID, want); err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.GetFork(ctx, docID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f == nil || *f != want {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+}
+
+func TestFirestoreStore_Threads(t *testing.T) {
+	client := testFirestoreClient(t)
+	s := NewFirestoreStore(client)
+	ctx := context.Background()
+	docID := uniqueDocID(t)
+	t.Cleanup(func() { cleanupDoc(t, s, docID) })
+
+	s.Create(ctx, docID, "")
+	now := time.Now()
+	th := Thread{
+		ID:        "t1",
+		Anchor:    ot.Range{Start: 1, End: 3},
+		Revision:  2,
+		Comments:  []Comment{{Author: "alice", Body: "typo", CreatedAt: now}},
+		CreatedAt: now,
+	}
+	if err := s.SaveThread(ctx, docID, th); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveThread(ctx, docID, Thread{ID: "t2", CreatedAt: now.Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	th.Resolved = true
+	th.Comments = append(th.Comments, Comment{Author: "bob", Body: "fixed", CreatedAt: now})
+	if err := s.SaveThread(ctx, docID, th); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err := s.ListThreads(ctx, docID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 || threads[0].ID != "t1" || threads[1].ID != "t2" {
+		t.Fatalf("threads = %+v", threads)
+	}
+	got := threads[0]
+	if got.Anchor != th.Anchor || got.Revision != 2 || !got.Resolved || len(got.Comments) != 2 || got.Comments[1].Author != "bob" {
+		t.Errorf("thread = %+v", got)
+	}
+}
+
+func TestFirestoreStore_Chat(t *testing.T) {
+	client := testFirestoreClient(t)
+	s := NewFirestoreStore(client)
+	ctx := context.Background()
+	docID := uniqueDocID(t)
+	t.Cleanup(func() { cleanupDoc(t, s, docID) })
+
+	s.Create(ctx, docID, "")
+	now := time.Now()
+	for i, id := range []string{"m1", "m2", "m3"} {
+		m := ChatMessage{ID: id, Author: "alice", Name: "Alice", Body: "hi", CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := s.AppendChat(ctx, docID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := s.RecentChat(ctx, docID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "m2" || msgs[1].ID != "m3" || msgs[1].Name != "Alice" {
+		t.Errorf("recent chat = %+v, want m2 and m3", msgs)
+	}
+}
+
 func TestFirestoreStore_OperationsNotFound(t *testing.T) {
 	client := testFirestoreClient(t)
 	s := NewFirestoreStore(client)
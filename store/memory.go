@@ -3,6 +3,8 @@ package store
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,8 +12,13 @@ import (
 )
 
 type docRecord struct {
-	info    DocumentInfo
-	history []ot.Operation
+	info        DocumentInfo
+	history     []ot.Edit
+	snapshots   []Snapshot   // in version order
+	checkpoints []Checkpoint // in the order they were saved
+	fork        *Fork        // nil unless the document is a fork
+	threads     []Thread     // in the order they were created
+	chat        []ChatMessage
 }
 
 // MemoryStore is an in-memory implementation of DocumentStore.
@@ -24,22 +31,28 @@ func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{docs: make(map[string]*docRecord)}
 }
 
-func (s *MemoryStore) Create(_ context.Context, id, content string) error {
+func (s *MemoryStore) Create(ctx context.Context, id, content string) error {
+	return s.CreateTyped(ctx, id, ot.Text.Name(), content)
+}
+
+func (s *MemoryStore) CreateTyped(_ context.Context, id, docType, content string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if _, exists := s.docs[id]; exists {
-		return fmt.Errorf("document %q already exists", id)
+		return fmt.Errorf("%w: %q", ErrDocumentExists, id)
 	}
 	now := time.Now()
 	s.docs[id] = &docRecord{
 		info: DocumentInfo{
 			ID:        id,
+			Type:      docType,
 			Content:   content,
 			Version:   0,
 			CreatedAt: now,
 			UpdatedAt: now,
 		},
+		snapshots: []Snapshot{{Version: 0, Content: content}},
 	}
 	return nil
 }
@@ -81,7 +94,7 @@ func (s *MemoryStore) UpdateContent(_ context.Context, id, content string, versi
 	return nil
 }
 
-func (s *MemoryStore) AppendOperation(_ context.Context, id string, op ot.Operation, version int) error {
+func (s *MemoryStore) AppendOperation(_ context.Context, id string, e ot.Edit, version int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -89,13 +102,13 @@ func (s *MemoryStore) AppendOperation(_ context.Context, id string, op ot.Operat
 	if !ok {
 		return fmt.Errorf("document %q not found", id)
 	}
-	rec.history = append(rec.history, op)
+	rec.history = append(rec.history, ot.Edit{Op: e.Op, Site: e.Site, Author: e.Author, Seq: e.Seq})
 	rec.info.Version = version
 	rec.info.UpdatedAt = time.Now()
 	return nil
 }
 
-func (s *MemoryStore) GetOperations(_ context.Context, id string, fromVersion int) ([]ot.Operation, error) {
+func (s *MemoryStore) GetOperations(_ context.Context, id string, fromVersion int) ([]ot.Edit, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -106,7 +119,170 @@ func (s *MemoryStore) GetOperations(_ context.Context, id string, fromVersion in
 	if fromVersion < 0 || fromVersion > len(rec.history) {
 		return nil, fmt.Errorf("invalid version %d", fromVersion)
 	}
-	ops := make([]ot.Operation, len(rec.history)-fromVersion)
+	ops := make([]ot.Edit, len(rec.history)-fromVersion)
 	copy(ops, rec.history[fromVersion:])
 	return ops, nil
 }
+
+func (s *MemoryStore) SaveSnapshot(_ context.Context, id string, snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return fmt.Errorf("document %q not found", id)
+	}
+	i := sort.Search(len(rec.snapshots), func(i int) bool { return rec.snapshots[i].Version >= snap.Version })
+	if i < len(rec.snapshots) && rec.snapshots[i].Version == snap.Version {
+		rec.snapshots[i] = snap
+		return nil
+	}
+	rec.snapshots = slices.Insert(rec.snapshots, i, snap)
+	return nil
+}
+
+func (s *MemoryStore) GetSnapshot(_ context.Context, id string, version int) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return nil, fmt.Errorf("document %q not found", id)
+	}
+	i := sort.Search(len(rec.snapshots), func(i int) bool { return rec.snapshots[i].Version > version })
+	if i == 0 {
+		return nil, fmt.Errorf("%w: document %q has none at or before version %d", ErrSnapshotNotFound, id, version)
+	}
+	snap := rec.snapshots[i-1]
+	return &snap, nil
+}
+
+func (s *MemoryStore) SaveCheckpoint(_ context.Context, id string, cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return fmt.Errorf("document %q not found", id)
+	}
+	if slices.ContainsFunc(rec.checkpoints, func(c Checkpoint) bool { return c.Name == cp.Name }) {
+		return fmt.Errorf("%w: %q", ErrCheckpointExists, cp.Name)
+	}
+	rec.checkpoints = append(rec.checkpoints, cp)
+	return nil
+}
+
+func (s *MemoryStore) ListCheckpoints(_ context.Context, id string) ([]Checkpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return nil, fmt.Errorf("document %q not found", id)
+	}
+	return slices.Clone(rec.checkpoints), nil
+}
+
+func (s *MemoryStore) DeleteCheckpoint(_ context.Context, id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return fmt.Errorf("document %q not found", id)
+	}
+	i := slices.IndexFunc(rec.checkpoints, func(c Checkpoint) bool { return c.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrCheckpointNotFound, name)
+	}
+	rec.checkpoints = slices.Delete(rec.checkpoints, i, i+1)
+	return nil
+}
+
+func (s *MemoryStore) SaveFork(_ context.Context, id string, f Fork) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return fmt.Errorf("document %q not found", id)
+	}
+	rec.fork = &f
+	return nil
+}
+
+func (s *MemoryStore) GetFork(_ context.Context, id string) (*Fork, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return nil, fmt.Errorf("document %q not found", id)
+	}
+	if rec.fork == nil {
+		return nil, nil
+	}
+	f := *rec.fork
+	return &f, nil
+}
+
+func (s *MemoryStore) SaveThread(_ context.Context, id string, th Thread) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return fmt.Errorf("document %q not found", id)
+	}
+	th.Comments = slices.Clone(th.Comments)
+	i := slices.IndexFunc(rec.threads, func(t Thread) bool { return t.ID == th.ID })
+	if i < 0 {
+		rec.threads = append(rec.threads, th)
+	} else {
+		rec.threads[i] = th
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListThreads(_ context.Context, id string) ([]Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return nil, fmt.Errorf("document %q not found", id)
+	}
+	threads := slices.Clone(rec.threads)
+	for i := range threads {
+		threads[i].Comments = slices.Clone(threads[i].Comments)
+	}
+	return threads, nil
+}
+
+func (s *MemoryStore) AppendChat(_ context.Context, id string, m ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return fmt.Errorf("document %q not found", id)
+	}
+	rec.chat = append(rec.chat, m)
+	// Older messages are never asked for, so drop them rather than let
+	// the chat of a long-running server grow without bound.
+	if len(rec.chat) > ChatHistorySize {
+		rec.chat = slices.Clone(rec.chat[len(rec.chat)-ChatHistorySize:])
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecentChat(_ context.Context, id string, n int) ([]ChatMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.docs[id]
+	if !ok {
+		return nil, fmt.Errorf("document %q not found", id)
+	}
+	return slices.Clone(rec.chat[max(0, len(rec.chat)-n):]), nil
+}
@@ -2,6 +2,8 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/alimasry/go-collab-editor/ot"
@@ -81,12 +83,12 @@ func TestMemoryStore_Operations(t *testing.T) {
 	s.Create(ctx, "doc1", "hello")
 
 	op1 := ot.NewInsert(5, " world", 5)
-	if err := s.AppendOperation(ctx, "doc1", op1, 1); err != nil {
+	if err := s.AppendOperation(ctx, "doc1", ot.Edit{Op: op1}, 1); err != nil {
 		t.Fatal(err)
 	}
 
 	op2 := ot.NewDelete(0, 5, 11)
-	if err := s.AppendOperation(ctx, "doc1", op2, 2); err != nil {
+	if err := s.AppendOperation(ctx, "doc1", ot.Edit{Op: op2, Site: "s1", Author: "alice", Seq: 3}, 2); err != nil {
 		t.Fatal(err)
 	}
 
@@ -98,6 +100,9 @@ func TestMemoryStore_Operations(t *testing.T) {
 	if len(ops) != 2 {
 		t.Fatalf("got %d ops, want 2", len(ops))
 	}
+	if ops[1].Site != "s1" || ops[1].Author != "alice" || ops[1].Seq != 3 {
+		t.Errorf("site=%q author=%q seq=%d, want s1, alice and 3", ops[1].Site, ops[1].Author, ops[1].Seq)
+	}
 
 	// Get ops from version 1
 	ops, err = s.GetOperations(ctx, "doc1", 1)
@@ -116,3 +121,164 @@ func TestMemoryStore_OperationsNotFound(t *testing.T) {
 		t.Error("expected error for missing document")
 	}
 }
+
+func TestMemoryStore_Snapshots(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	s.Create(ctx, "doc1", "hello")
+	// Saved out of order; GetSnapshot still finds the nearest.
+	for _, snap := range []Snapshot{{200, "c"}, {100, "b"}} {
+		if err := s.SaveSnapshot(ctx, "doc1", snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for version, want := range map[int]Snapshot{
+		0:   {0, "hello"},
+		99:  {0, "hello"},
+		100: {100, "b"},
+		150: {100, "b"},
+		500: {200, "c"},
+	} {
+		snap, err := s.GetSnapshot(ctx, "doc1", version)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if *snap != want {
+			t.Errorf("version %d: got %+v, want %+v", version, *snap, want)
+		}
+	}
+
+	if _, err := s.GetSnapshot(ctx, "doc1", -1); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("err = %v, want ErrSnapshotNotFound", err)
+	}
+	if err := s.SaveSnapshot(ctx, "nope", Snapshot{}); err == nil {
+		t.Error("expected error for missing document")
+	}
+}
+
+func TestMemoryStore_Checkpoints(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	s.Create(ctx, "doc1", "")
+	for _, cp := range []Checkpoint{{Name: "v1", Revision: 3}, {Name: "v2", Revision: 1}} {
+		if err := s.SaveCheckpoint(ctx, "doc1", cp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveCheckpoint(ctx, "doc1", Checkpoint{Name: "v1"}); !errors.Is(err, ErrCheckpointExists) {
+		t.Errorf("err = %v, want ErrCheckpointExists", err)
+	}
+
+	if err := s.DeleteCheckpoint(ctx, "doc1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteCheckpoint(ctx, "doc1", "v1"); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("err = %v, want ErrCheckpointNotFound", err)
+	}
+
+	cps, err := s.ListCheckpoints(ctx, "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 1 || cps[0].Name != "v2" || cps[0].Revision != 1 {
+		t.Errorf("checkpoints = %+v", cps)
+	}
+}
+
+func TestMemoryStore_Fork(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	s.Create(ctx, "doc1", "")
+	if f, err := s.GetFork(ctx, "doc1"); f != nil || err != nil {
+		t.Errorf("GetFork = %+v, %v; want nil for a document that isn't a fork", f, err)
+	}
+	want := Fork{Parent: "parent", Revision: 4}
+	if err := s.SaveFork(ctx, "doc1", want); err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.GetFork(ctx, "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *f != want {
+		t.Errorf("got %+v, want %+v", *f, want)
+	}
+	if _, err := s.GetFork(ctx, "nope"); err == nil {
+		t.Error("expected error for missing document")
+	}
+}
+
+func TestMemoryStore_Threads(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	s.Create(ctx, "doc1", "")
+	th := Thread{ID: "t1", Anchor: ot.Range{Start: 1, End: 3}, Comments: []Comment{{Author: "alice", Body: "typo"}}}
+	if err := s.SaveThread(ctx, "doc1", th); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveThread(ctx, "doc1", Thread{ID: "t2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving again replaces the thread in place.
+	th.Resolved, th.Revision = true, 4
+	th.Comments = append(th.Comments, Comment{Author: "bob", Body: "fixed"})
+	if err := s.SaveThread(ctx, "doc1", th); err != nil {
+		t.Fatal(err)
+	}
+	th.Comments[0].Body = "changed after saving"
+
+	threads, err := s.ListThreads(ctx, "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 || threads[0].ID != "t1" || threads[1].ID != "t2" {
+		t.Fatalf("threads = %+v", threads)
+	}
+	got := threads[0]
+	if !got.Resolved || got.Revision != 4 || len(got.Comments) != 2 || got.Comments[0].Body != "typo" {
+		t.Errorf("thread = %+v", got)
+	}
+	if err := s.SaveThread(ctx, "nope", th); err == nil {
+		t.Error("expected error for missing document")
+	}
+}
+
+func TestMemoryStore_Chat(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	s.Create(ctx, "doc1", "")
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := s.AppendChat(ctx, "doc1", ChatMessage{ID: id, Author: "alice", Body: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := s.RecentChat(ctx, "doc1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "m2" || msgs[1].ID != "m3" {
+		t.Errorf("recent chat = %+v, want m2 and m3", msgs)
+	}
+	if msgs, _ := s.RecentChat(ctx, "doc1", 10); len(msgs) != 3 {
+		t.Errorf("recent chat = %+v, want all 3", msgs)
+	}
+	if err := s.AppendChat(ctx, "nope", ChatMessage{ID: "m4"}); err == nil {
+		t.Error("expected error for missing document")
+	}
+
+	// Only the latest ChatHistorySize messages are kept.
+	for i := 4; i <= ChatHistorySize+10; i++ {
+		s.AppendChat(ctx, "doc1", ChatMessage{ID: fmt.Sprintf("m%d", i)})
+	}
+	msgs, _ = s.RecentChat(ctx, "doc1", ChatHistorySize+10)
+	if len(msgs) != ChatHistorySize || msgs[0].ID != "m11" {
+		t.Errorf("kept %d messages, want %d from m11", len(msgs), ChatHistorySize)
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/alimasry/go-collab-editor/ot"
@@ -10,19 +11,134 @@ import (
 // DocumentInfo holds document metadata and content.
 type DocumentInfo struct {
 	ID        string
-	Content   string
+	Type      string // name of the document's ot.Type
+	Content   string // snapshot serialized by the document's ot.Type
 	Version   int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Snapshot is a document's serialized content at a version.
+type Snapshot struct {
+	Version int
+	Content string
+}
+
+// Checkpoint is a named revision of a document, such as "v1 sent to legal".
+type Checkpoint struct {
+	Name      string // unique within the document
+	Revision  int
+	Author    string
+	CreatedAt time.Time
+}
+
+// Fork records that a document was forked from another, its parent.
+type Fork struct {
+	Parent   string // ID of the parent document
+	Revision int    // parent's revision the fork was made at
+	// Merged is set once the fork's edits have been merged back into the
+	// parent, taking it to MergedRevision.
+	Merged         bool
+	MergedRevision int
+}
+
+// Thread is a comment thread attached to a range of a document's text.
+type Thread struct {
+	ID string // unique within the document
+	// Anchor is the range the thread is attached to, in the document at
+	// Revision.
+	Anchor    ot.Range
+	Revision  int
+	Resolved  bool
+	Comments  []Comment // oldest first
+	CreatedAt time.Time
+}
+
+// Comment is one message in a Thread.
+type Comment struct {
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// ChatMessage is a message in a document's chat.
+type ChatMessage struct {
+	ID        string
+	Author    string
+	Name      string // the author's display name when it was sent
+	Body      string
+	CreatedAt time.Time
+}
+
+var (
+	// ErrDocumentExists is returned when creating a document under an ID
+	// that is taken.
+	ErrDocumentExists = errors.New("document already exists")
+	// ErrCheckpointExists is returned when saving a checkpoint under a
+	// name the document already has.
+	ErrCheckpointExists = errors.New("checkpoint already exists")
+	// ErrCheckpointNotFound is returned when deleting a checkpoint the
+	// document doesn't have.
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+	// ErrSnapshotNotFound is returned when a document has no snapshot at
+	// or before the version asked for, as for documents stored before
+	// snapshots were kept.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
 // DocumentStore abstracts document persistence.
 // Implementations: MemoryStore (in-memory), FirestoreStore (Google Cloud Firestore).
 type DocumentStore interface {
+	// Create creates a plain-text (ot.Text) document.
 	Create(ctx context.Context, id, content string) error
+	// CreateTyped creates a document of the named ot.Type, failing with
+	// ErrDocumentExists if there is one with its ID.
+	CreateTyped(ctx context.Context, id, docType, content string) error
 	Get(ctx context.Context, id string) (*DocumentInfo, error)
 	List(ctx context.Context) ([]DocumentInfo, error)
 	UpdateContent(ctx context.Context, id, content string, version int) error
-	AppendOperation(ctx context.Context, id string, op ot.Operation, version int) error
-	GetOperations(ctx context.Context, id string, fromVersion int) ([]ot.Operation, error)
+	// AppendOperation records the edit that took the document to version,
+	// with its site and author.
+	AppendOperation(ctx context.Context, id string, e ot.Edit, version int) error
+	// GetOperations returns the edits made since fromVersion.
+	GetOperations(ctx context.Context, id string, fromVersion int) ([]ot.Edit, error)
+	// SaveSnapshot records the document's content at a version, so that
+	// past versions can be rebuilt without replaying all of history.
+	// Create and CreateTyped save the snapshot at version 0.
+	SaveSnapshot(ctx context.Context, id string, snap Snapshot) error
+	// GetSnapshot returns the latest snapshot at or before version,
+	// failing with ErrSnapshotNotFound if there is none.
+	GetSnapshot(ctx context.Context, id string, version int) (*Snapshot, error)
+	// SaveCheckpoint records a named checkpoint, failing with
+	// ErrCheckpointExists if the document has one by that name.
+	SaveCheckpoint(ctx context.Context, id string, cp Checkpoint) error
+	// ListCheckpoints returns the document's checkpoints, oldest first.
+	ListCheckpoints(ctx context.Context, id string) ([]Checkpoint, error)
+	// DeleteCheckpoint removes the named checkpoint, failing with
+	// ErrCheckpointNotFound if there is none.
+	DeleteCheckpoint(ctx context.Context, id, name string) error
+	// SaveFork records how a document was forked, replacing any record.
+	SaveFork(ctx context.Context, id string, f Fork) error
+	// GetFork returns how a document was forked, or nil if it wasn't.
+	GetFork(ctx context.Context, id string) (*Fork, error)
+	// SaveThread records a comment thread, replacing any with its ID.
+	SaveThread(ctx context.Context, id string, th Thread) error
+	// ListThreads returns the document's comment threads, oldest first.
+	ListThreads(ctx context.Context, id string) ([]Thread, error)
+}
+
+// ChatHistorySize is how many recent chat messages a session keeps, and so
+// the most it asks a ChatStore for. MemoryStore keeps no more than this for
+// each document.
+const ChatHistorySize = 50
+
+// ChatStore is implemented by stores that keep documents' chat history, so
+// that it survives restarts. Without it, only the chat since a document was
+// opened is kept, in memory.
+type ChatStore interface {
+	// AppendChat records a chat message sent in a document.
+	AppendChat(ctx context.Context, id string, m ChatMessage) error
+	// RecentChat returns the document's latest n chat messages, oldest
+	// first.
+	RecentChat(ctx context.Context, id string, n int) ([]ChatMessage, error)
 }